@@ -14,6 +14,15 @@
 
 package broker
 
+import "errors"
+
+var (
+	// ErrNotConnected is returned if the client is not connected to the broker
+	ErrNotConnected = errors.New("broker: not connected")
+	// ErrAckTimeout is returned if a message is acked after it's redelivered
+	ErrAckTimeout = errors.New("broker: ack timeout")
+)
+
 // Client is a client interface for various message brokers,
 // e.g., RabbitMQ, Kafka, NATS, etc.
 type Client interface {
@@ -44,6 +53,7 @@ type Subscription interface {
 
 // ----------------------------------------------------------------------------
 
+// NewClient returns the in-memory broker, which delivers the messages within the process
 func NewClient(opts ...Option) Client {
-	return nil
+	return newMemoryBroker(opts...)
 }
@@ -0,0 +1,33 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker_test
+
+import (
+	"testing"
+
+	"github.com/getamis/sirius/broker"
+	testutils "github.com/getamis/sirius/broker/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	client := broker.NewClient()
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	testutils.RunTestFanOut(t, client)
+	testutils.RunTestQueue(t, client)
+	testutils.RunTestRedelivery(t, client)
+}
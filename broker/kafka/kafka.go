@@ -0,0 +1,325 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	"github.com/getamis/sirius/log"
+	uuid "github.com/satori/go.uuid"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// retryInterval is the interval between the retries of the failed fetches
+const retryInterval = time.Second
+
+// client publishes the messages to the Kafka topics of the same names.
+// The subscriptions with a Queue join the consumer group of the name, so the
+// partitions are shared among them and the committed offsets are kept after
+// they're closed. A subscription without a Queue joins a group of its own from
+// the latest offsets, so it receives all the messages published after it subscribes.
+// The group is never committed, so Kafka drops it once it's empty instead of keeping
+// its offsets for offsets.retention.minutes, and its messages need no ack.
+// Kafka redelivers the unacked messages only after the partitions are rebalanced,
+// so AckTimeout is not supported.
+type client struct {
+	brokers []string
+	opts    options
+	dialer  *kafkago.Dialer
+
+	mu     sync.RWMutex
+	writer *kafkago.Writer
+	subs   map[*subscription]struct{}
+}
+
+// NewClient returns the Kafka client of the brokers, e.g., "localhost:9092"
+func NewClient(brokers []string, opts ...Option) broker.Client {
+	o := options{
+		dialTimeout:    defaultDialTimeout,
+		batchTimeout:   defaultBatchTimeout,
+		commitInterval: defaultCommitInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &client{
+		brokers: brokers,
+		opts:    o,
+		dialer: &kafkago.Dialer{
+			Timeout:   o.dialTimeout,
+			DualStack: true,
+			TLS:       tlsConfig(o.Options),
+		},
+		subs: make(map[*subscription]struct{}),
+	}
+}
+
+func tlsConfig(o broker.Options) *tls.Config {
+	if o.TLSConfig != nil {
+		return o.TLSConfig
+	}
+	if o.Secure {
+		return &tls.Config{}
+	}
+	return nil
+}
+
+// Connect checks if any of the brokers is reachable
+func (c *client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writer != nil {
+		return nil
+	}
+
+	var err error
+	for _, addr := range c.brokers {
+		var conn *kafkago.Conn
+		conn, err = c.dialer.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	c.writer = &kafkago.Writer{
+		Addr:                   kafkago.TCP(c.brokers...),
+		Balancer:               &kafkago.Hash{},
+		BatchTimeout:           c.opts.batchTimeout,
+		RequiredAcks:           kafkago.RequireAll,
+		AllowAutoTopicCreation: true,
+		Transport: &kafkago.Transport{
+			DialTimeout: c.opts.dialTimeout,
+			TLS:         c.dialer.TLS,
+		},
+	}
+	return nil
+}
+
+// Disconnect closes all the subscriptions and flushes the pending messages
+func (c *client) Disconnect() error {
+	c.mu.Lock()
+	writer := c.writer
+	c.writer = nil
+	subs := make([]*subscription, 0, len(c.subs))
+	for s := range c.subs {
+		subs = append(subs, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	if writer == nil {
+		return nil
+	}
+	return writer.Close()
+}
+
+// Publish writes the message to the partition chosen by the PartitionKey,
+// or by round-robin if there is no key
+func (c *client) Publish(topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	options := broker.PublishOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	c.mu.RLock()
+	writer := c.writer
+	c.mu.RUnlock()
+	if writer == nil {
+		return broker.ErrNotConnected
+	}
+
+	headers := make([]kafkago.Header, 0, len(msg.Header))
+	for k, v := range msg.Header {
+		headers = append(headers, kafkago.Header{Key: k, Value: []byte(v)})
+	}
+	return writer.WriteMessages(context.Background(), kafkago.Message{
+		Topic:   topic,
+		Key:     options.PartitionKey,
+		Value:   msg.Body,
+		Headers: headers,
+	})
+}
+
+func (c *client) Subscribe(topic string, opts ...broker.SubscribeOption) (broker.Subscription, error) {
+	options := broker.SubscribeOptions{
+		AutoAck: true,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writer == nil {
+		return nil, broker.ErrNotConnected
+	}
+
+	config := c.readerConfig(topic, options)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &subscription{
+		client: c,
+		topic:  topic,
+		opts:   options,
+		reader: kafkago.NewReader(config),
+		ch:     make(chan broker.Publication),
+		ctx:    ctx,
+		cancel: cancel,
+		exited: make(chan struct{}),
+	}
+	c.subs[s] = struct{}{}
+	go s.run()
+	return s, nil
+}
+
+func (c *client) readerConfig(topic string, options broker.SubscribeOptions) kafkago.ReaderConfig {
+	config := kafkago.ReaderConfig{
+		Brokers: c.brokers,
+		Topic:   topic,
+		Dialer:  c.dialer,
+		GroupID: options.Queue,
+	}
+	if options.Queue == "" {
+		config.GroupID = "sirius-" + uuid.NewV4().String()
+		config.StartOffset = kafkago.LastOffset
+	} else if options.AutoAck {
+		// the offsets are committed in the background
+		config.CommitInterval = c.opts.commitInterval
+	}
+	return config
+}
+
+func (c *client) remove(s *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, s)
+}
+
+type subscription struct {
+	client *client
+	topic  string
+	opts   broker.SubscribeOptions
+	reader *kafkago.Reader
+	ch     chan broker.Publication
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (s *subscription) Topic() string {
+	return s.topic
+}
+
+func (s *subscription) Chan() <-chan broker.Publication {
+	return s.ch
+}
+
+// Unsubscribe leaves the consumer group and closes the channel.
+// The unacked messages are redelivered to the others of the group from the committed offsets.
+func (s *subscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		s.client.remove(s)
+		s.cancel()
+		<-s.exited
+		err = s.reader.Close()
+		close(s.ch)
+	})
+	return err
+}
+
+func (s *subscription) run() {
+	defer close(s.exited)
+	for {
+		m, err := s.reader.FetchMessage(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			log.Warn("Failed to fetch the Kafka message", "topic", s.topic, "err", err)
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
+		p := newPublication(s, m)
+		select {
+		case s.ch <- p:
+		case <-s.ctx.Done():
+			return
+		}
+		if s.opts.AutoAck && s.opts.Queue != "" {
+			if err := s.commit(m); err != nil {
+				log.Warn("Failed to commit the Kafka message", "topic", s.topic, "offset", m.Offset, "err", err)
+			}
+		}
+	}
+}
+
+func (s *subscription) commit(m kafkago.Message) error {
+	return s.reader.CommitMessages(context.Background(), m)
+}
+
+type publication struct {
+	sub      *subscription
+	kafkaMsg kafkago.Message
+	message  *broker.Message
+}
+
+func newPublication(s *subscription, m kafkago.Message) *publication {
+	msg := &broker.Message{
+		Header: make(map[string]string, len(m.Headers)),
+		Body:   m.Value,
+	}
+	for _, h := range m.Headers {
+		msg.Header[h.Key] = string(h.Value)
+	}
+	return &publication{
+		sub:      s,
+		kafkaMsg: m,
+		message:  msg,
+	}
+}
+
+func (p *publication) Topic() string {
+	return p.kafkaMsg.Topic
+}
+
+func (p *publication) Message() *broker.Message {
+	return p.message
+}
+
+// Ack commits the offset of the message, which also acknowledges the previous
+// messages of the partition
+func (p *publication) Ack() error {
+	if p.sub.opts.AutoAck || p.sub.opts.Queue == "" {
+		return nil
+	}
+	return p.sub.commit(p.kafkaMsg)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	testutils "github.com/getamis/sirius/broker/test"
+	"github.com/getamis/sirius/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, sub broker.Subscription) broker.Publication {
+	select {
+	case p, ok := <-sub.Chan():
+		assert.True(t, ok, "should be true")
+		return p
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout waiting for the publication")
+	}
+	return nil
+}
+
+func TestKafkaClient(t *testing.T) {
+	container, err := test.NewKafkaContainer()
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, container.Start(), "should be no error")
+	defer container.Stop()
+
+	client := NewClient(container.Brokers)
+	assert.Equal(t, broker.ErrNotConnected, client.Publish("topic", &broker.Message{}), "should be equal")
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	testQueue(t, client)
+	testAck(t, client)
+	testutils.RunTestFanOut(t, client)
+	testutils.RunTestQueue(t, client)
+	testutils.RunTestRedelivery(t, client)
+}
+
+func testQueue(t *testing.T, client broker.Client) {
+	topic := "testQueue"
+	// create the topic before the subscriptions
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("init")}), "should be no error")
+
+	sub, err := client.Subscribe(topic, broker.Queue("testQueue"))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	assert.Equal(t, []byte("init"), receive(t, sub).Message().Body, "should be equal")
+
+	// the messages of the same key are kept in order
+	for i := 0; i < 5; i++ {
+		msg := &broker.Message{
+			Header: map[string]string{"index": fmt.Sprint(i)},
+			Body:   []byte(fmt.Sprintf("message %d", i)),
+		}
+		assert.NoError(t, client.Publish(topic, msg, broker.PartitionKey([]byte("key"))), "should be no error")
+	}
+	for i := 0; i < 5; i++ {
+		p := receive(t, sub)
+		assert.Equal(t, topic, p.Topic(), "should be equal")
+		assert.Equal(t, fmt.Sprint(i), p.Message().Header["index"], "should be equal")
+		assert.Equal(t, []byte(fmt.Sprintf("message %d", i)), p.Message().Body, "should be equal")
+	}
+}
+
+func testAck(t *testing.T, client broker.Client) {
+	topic := "testAck"
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("0")}), "should be no error")
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("1")}), "should be no error")
+
+	sub, err := client.Subscribe(topic, broker.Queue("testAck"), broker.AutoAck(false))
+	assert.NoError(t, err, "should be no error")
+	p := receive(t, sub)
+	assert.Equal(t, []byte("0"), p.Message().Body, "should be equal")
+	assert.NoError(t, p.Ack(), "should be no error")
+	assert.Equal(t, []byte("1"), receive(t, sub).Message().Body, "should be equal")
+	assert.NoError(t, sub.Unsubscribe(), "should be no error")
+
+	// the group resumes from the committed offset
+	sub, err = client.Subscribe(topic, broker.Queue("testAck"), broker.AutoAck(false))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	p = receive(t, sub)
+	assert.Equal(t, []byte("1"), p.Message().Body, "should be equal")
+	assert.NoError(t, p.Ack(), "should be no error")
+}
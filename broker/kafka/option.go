@@ -0,0 +1,71 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/getamis/sirius/broker"
+)
+
+const (
+	defaultDialTimeout  = 10 * time.Second
+	defaultBatchTimeout = 10 * time.Millisecond
+
+	defaultCommitInterval = time.Second
+)
+
+type options struct {
+	broker.Options
+
+	dialTimeout    time.Duration
+	batchTimeout   time.Duration
+	commitInterval time.Duration
+}
+
+// Option configures the Kafka client
+type Option func(o *options)
+
+// BrokerOptions returns an option to apply the common broker options, e.g., Secure and TLSConfig
+func BrokerOptions(opts ...broker.Option) Option {
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(&o.Options)
+		}
+	}
+}
+
+// DialTimeout returns an option to set the timeout of the connections to the brokers
+func DialTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = t
+	}
+}
+
+// BatchTimeout returns an option to set the time to wait for more messages of a batch
+// before it's written, which is 10ms by default
+func BatchTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.batchTimeout = t
+	}
+}
+
+// CommitInterval returns an option to set the interval of committing the offsets of the
+// AutoAck subscriptions in batches, which is 1s by default. The manual acks are committed at once.
+func CommitInterval(t time.Duration) Option {
+	return func(o *options) {
+		o.commitInterval = t
+	}
+}
@@ -0,0 +1,384 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultAckTimeout is the ack timeout of the in-memory broker if it's not specified
+const defaultAckTimeout = 30 * time.Second
+
+// memoryBroker delivers the messages to the subscriptions of the same process.
+// Each subscription without a queue receives all the messages of the topic, and
+// the subscriptions sharing a queue receive the messages in turn. The messages
+// of a queue are kept until a subscription joins if the queue has no one.
+type memoryBroker struct {
+	opts Options
+
+	mu        sync.Mutex
+	connected bool
+	topics    map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	queues map[string]*memoryGroup
+	subs   map[*memorySubscription]*memoryGroup
+}
+
+// memoryGroup is the subscriptions sharing the messages
+type memoryGroup struct {
+	members []*memorySubscription
+	next    int
+	backlog []*Message
+}
+
+func newMemoryBroker(opts ...Option) *memoryBroker {
+	b := &memoryBroker{
+		topics: make(map[string]*memoryTopic),
+	}
+	for _, opt := range opts {
+		opt(&b.opts)
+	}
+	return b
+}
+
+func (b *memoryBroker) Connect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = true
+	return nil
+}
+
+// Disconnect unsubscribes all the subscriptions and drops the undelivered messages
+func (b *memoryBroker) Disconnect() error {
+	b.mu.Lock()
+	b.connected = false
+	var subs []*memorySubscription
+	for _, t := range b.topics {
+		for _, g := range t.queues {
+			subs = append(subs, g.members...)
+		}
+		for s := range t.subs {
+			subs = append(subs, s)
+		}
+	}
+	b.topics = make(map[string]*memoryTopic)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	return nil
+}
+
+func (b *memoryBroker) Publish(topic string, msg *Message, opts ...PublishOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+
+	t, ok := b.topics[topic]
+	if !ok {
+		return nil
+	}
+	for _, g := range t.queues {
+		g.dispatch(copyMessage(msg))
+	}
+	for _, g := range t.subs {
+		g.dispatch(copyMessage(msg))
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(topic string, opts ...SubscribeOption) (Subscription, error) {
+	options := SubscribeOptions{
+		AutoAck:    true,
+		AckTimeout: defaultAckTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.AckTimeout <= 0 {
+		options.AckTimeout = defaultAckTimeout
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return nil, ErrNotConnected
+	}
+
+	t, ok := b.topics[topic]
+	if !ok {
+		t = &memoryTopic{
+			queues: make(map[string]*memoryGroup),
+			subs:   make(map[*memorySubscription]*memoryGroup),
+		}
+		b.topics[topic] = t
+	}
+
+	s := &memorySubscription{
+		broker:  b,
+		topic:   topic,
+		opts:    options,
+		unacked: make(map[*memoryPublication]struct{}),
+		notify:  make(chan struct{}, 1),
+		ch:      make(chan Publication),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	if options.Queue == "" {
+		s.group = &memoryGroup{}
+		t.subs[s] = s.group
+	} else {
+		g, ok := t.queues[options.Queue]
+		if !ok {
+			g = &memoryGroup{}
+			t.queues[options.Queue] = g
+		}
+		s.group = g
+	}
+	s.group.members = append(s.group.members, s)
+
+	// deliver the messages kept while the queue has no one
+	backlog := s.group.backlog
+	s.group.backlog = nil
+	for _, msg := range backlog {
+		s.group.dispatch(msg)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// dispatch delivers the message to the next member, or keeps it if there is no one.
+// It's called with the lock of the broker held.
+func (g *memoryGroup) dispatch(msg *Message) {
+	if len(g.members) == 0 {
+		g.backlog = append(g.backlog, msg)
+		return
+	}
+	s := g.members[g.next%len(g.members)]
+	g.next++
+	s.enqueue(msg)
+}
+
+func (g *memoryGroup) remove(s *memorySubscription) {
+	for i, m := range g.members {
+		if m == s {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			return
+		}
+	}
+}
+
+type memorySubscription struct {
+	broker *memoryBroker
+	topic  string
+	opts   SubscribeOptions
+	group  *memoryGroup
+
+	// guarded by the lock of the broker
+	backlog []*Message
+	unacked map[*memoryPublication]struct{}
+
+	notify   chan struct{}
+	ch       chan Publication
+	done     chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
+}
+
+func (s *memorySubscription) Topic() string {
+	return s.topic
+}
+
+func (s *memorySubscription) Chan() <-chan Publication {
+	return s.ch
+}
+
+// Unsubscribe closes the channel, and the undelivered and the unacked messages
+// are delivered to the other subscriptions of the queue
+func (s *memorySubscription) Unsubscribe() error {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		<-s.exited
+
+		b := s.broker
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		s.group.remove(s)
+		msgs := s.backlog
+		s.backlog = nil
+		for p := range s.unacked {
+			p.expire()
+			msgs = append(msgs, p.message)
+		}
+		s.unacked = nil
+
+		t, ok := b.topics[s.topic]
+		if !ok {
+			// disconnected
+			return
+		}
+		if s.opts.Queue == "" {
+			delete(t.subs, s)
+		} else {
+			for _, msg := range msgs {
+				s.group.dispatch(msg)
+			}
+		}
+		if len(t.subs) == 0 && len(t.queues) == 0 {
+			delete(b.topics, s.topic)
+		}
+	})
+	return nil
+}
+
+// enqueue is called with the lock of the broker held
+func (s *memorySubscription) enqueue(msg *Message) {
+	s.backlog = append(s.backlog, msg)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *memorySubscription) run() {
+	defer close(s.exited)
+	defer close(s.ch)
+
+	b := s.broker
+	for {
+		b.mu.Lock()
+		if len(s.backlog) == 0 {
+			b.mu.Unlock()
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		p := &memoryPublication{
+			sub:     s,
+			message: s.backlog[0],
+		}
+		s.backlog = s.backlog[1:]
+		if !s.opts.AutoAck {
+			// it's registered before it's sent because it may be acked right away
+			s.unacked[p] = struct{}{}
+		}
+		b.mu.Unlock()
+
+		select {
+		case s.ch <- p:
+			if !s.opts.AutoAck {
+				b.mu.Lock()
+				if !p.acked {
+					p.timer = time.AfterFunc(s.opts.AckTimeout, p.redeliver)
+				}
+				b.mu.Unlock()
+			}
+		case <-s.done:
+			b.mu.Lock()
+			delete(s.unacked, p)
+			s.backlog = append([]*Message{p.message}, s.backlog...)
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+type memoryPublication struct {
+	sub     *memorySubscription
+	message *Message
+
+	// guarded by the lock of the broker
+	acked   bool
+	expired bool
+	timer   *time.Timer
+}
+
+func (p *memoryPublication) Topic() string {
+	return p.sub.topic
+}
+
+func (p *memoryPublication) Message() *Message {
+	return p.message
+}
+
+// Ack acknowledges the message, and ErrAckTimeout is returned if it's redelivered
+func (p *memoryPublication) Ack() error {
+	if p.sub.opts.AutoAck {
+		return nil
+	}
+
+	b := p.sub.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.expired {
+		return ErrAckTimeout
+	}
+	if p.acked {
+		return nil
+	}
+	p.acked = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(p.sub.unacked, p)
+	return nil
+}
+
+// redeliver delivers the message again to the group if it's not acked in time
+func (p *memoryPublication) redeliver() {
+	b := p.sub.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p.acked || p.expired {
+		return
+	}
+	p.expire()
+	delete(p.sub.unacked, p)
+	p.sub.group.dispatch(p.message)
+}
+
+// expire is called with the lock of the broker held
+func (p *memoryPublication) expire() {
+	p.expired = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+// copyMessage copies the message, so the subscriptions don't share it with the publisher
+func copyMessage(msg *Message) *Message {
+	m := &Message{}
+	if msg.Header != nil {
+		m.Header = make(map[string]string, len(msg.Header))
+		for k, v := range msg.Header {
+			m.Header[k] = v
+		}
+	}
+	if msg.Body != nil {
+		m.Body = append([]byte{}, msg.Body...)
+	}
+	return m
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, sub Subscription) Publication {
+	select {
+	case p, ok := <-sub.Chan():
+		assert.True(t, ok, "should be true")
+		return p
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for the publication")
+	}
+	return nil
+}
+
+func assertNoPublication(t *testing.T, sub Subscription) {
+	select {
+	case p := <-sub.Chan():
+		t.Fatalf("unexpected publication %v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMemoryBrokerNotConnected(t *testing.T) {
+	client := NewClient()
+	assert.Equal(t, ErrNotConnected, client.Publish("topic", &Message{}), "should be equal")
+	_, err := client.Subscribe("topic")
+	assert.Equal(t, ErrNotConnected, err, "should be equal")
+}
+
+func TestMemoryBrokerFanOut(t *testing.T) {
+	client := NewClient()
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("topic")
+	assert.NoError(t, err, "should be no error")
+	sub2, err := client.Subscribe("topic")
+	assert.NoError(t, err, "should be no error")
+	other, err := client.Subscribe("other")
+	assert.NoError(t, err, "should be no error")
+
+	msg := &Message{
+		Header: map[string]string{"foo": "bar"},
+		Body:   []byte("hello"),
+	}
+	assert.NoError(t, client.Publish("topic", msg), "should be no error")
+	// the publisher owns the message
+	msg.Body[0] = 'j'
+
+	for _, sub := range []Subscription{sub1, sub2} {
+		p := receive(t, sub)
+		assert.Equal(t, "topic", p.Topic(), "should be equal")
+		assert.Equal(t, []byte("hello"), p.Message().Body, "should be equal")
+		assert.Equal(t, "bar", p.Message().Header["foo"], "should be equal")
+		assert.NoError(t, p.Ack(), "should be no error")
+	}
+	assertNoPublication(t, other)
+
+	// the channel is closed after it's unsubscribed
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	_, ok := <-sub1.Chan()
+	assert.False(t, ok, "should be false")
+	assert.NoError(t, client.Publish("topic", msg), "should be no error")
+	assert.Equal(t, []byte("jello"), receive(t, sub2).Message().Body, "should be equal")
+}
+
+func TestMemoryBrokerQueue(t *testing.T) {
+	client := NewClient()
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	// the messages are kept until a subscription joins the queue
+	sub, err := client.Subscribe("topic", Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, sub.Unsubscribe(), "should be no error")
+	assert.NoError(t, client.Publish("topic", &Message{Body: []byte("0")}), "should be no error")
+
+	sub1, err := client.Subscribe("topic", Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "0", string(receive(t, sub1).Message().Body), "should be equal")
+	sub2, err := client.Subscribe("topic", Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	sub3, err := client.Subscribe("topic", Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	for i := 1; i <= 6; i++ {
+		assert.NoError(t, client.Publish("topic", &Message{Body: []byte{'0' + byte(i)}}), "should be no error")
+	}
+
+	// round-robin
+	assert.Equal(t, "3", string(receive(t, sub1).Message().Body), "should be equal")
+	assert.Equal(t, "6", string(receive(t, sub1).Message().Body), "should be equal")
+	assert.Equal(t, "1", string(receive(t, sub2).Message().Body), "should be equal")
+	assert.Equal(t, "4", string(receive(t, sub2).Message().Body), "should be equal")
+	assert.Equal(t, "2", string(receive(t, sub3).Message().Body), "should be equal")
+	assert.Equal(t, "5", string(receive(t, sub3).Message().Body), "should be equal")
+}
+
+func TestMemoryBrokerAck(t *testing.T) {
+	client := NewClient()
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("topic", Queue("queue"), AutoAck(false), AckTimeout(100*time.Millisecond))
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, client.Publish("topic", &Message{Body: []byte("foo")}), "should be no error")
+
+	// the unacked message is redelivered after the timeout
+	p := receive(t, sub1)
+	redelivered := receive(t, sub1)
+	assert.Equal(t, []byte("foo"), redelivered.Message().Body, "should be equal")
+	assert.Equal(t, ErrAckTimeout, p.Ack(), "should be equal")
+	assert.NoError(t, redelivered.Ack(), "should be no error")
+	assert.NoError(t, redelivered.Ack(), "should be no error")
+	assertNoPublication(t, sub1)
+
+	// the unacked message is redelivered to the others of the queue after it's unsubscribed
+	sub2, err := client.Subscribe("topic", Queue("queue"), AutoAck(false))
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, client.Publish("topic", &Message{Body: []byte("bar")}), "should be no error")
+	p = receive(t, sub1)
+	assert.Equal(t, []byte("bar"), p.Message().Body, "should be equal")
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	redelivered = receive(t, sub2)
+	assert.Equal(t, []byte("bar"), redelivered.Message().Body, "should be equal")
+	assert.Equal(t, ErrAckTimeout, p.Ack(), "should be equal")
+	assert.NoError(t, redelivered.Ack(), "should be no error")
+}
+
+func TestMemoryBrokerDisconnect(t *testing.T) {
+	client := NewClient()
+	assert.NoError(t, client.Connect(), "should be no error")
+
+	sub, err := client.Subscribe("topic")
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, client.Disconnect(), "should be no error")
+	_, ok := <-sub.Chan()
+	assert.False(t, ok, "should be false")
+	assert.NoError(t, sub.Unsubscribe(), "should be no error")
+	assert.Equal(t, ErrNotConnected, client.Publish("topic", &Message{}), "should be equal")
+}
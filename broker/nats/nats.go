@@ -0,0 +1,493 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	"github.com/getamis/sirius/log"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// pendingSize is the max number of the pending messages of a core NATS subscription
+	pendingSize = 256
+	// retryInterval is the interval between the retries of the failed fetches
+	retryInterval = time.Second
+)
+
+// client publishes the messages to the NATS subjects of the topics.
+// A subscription without a Queue is a core NATS subscription, which receives the
+// messages published while it's subscribed, and Ack is a no-op.
+// A subscription with a Queue pulls the messages from the durable JetStream consumer
+// of the name, which is shared by the subscriptions of the Queue. The stream capturing
+// the topic and the consumer are created if they don't exist, and the messages which
+// are not acked in AckTimeout are redelivered.
+type client struct {
+	url  string
+	opts options
+
+	mu   sync.RWMutex
+	conn *nats.Conn
+	js   nats.JetStreamContext
+	subs map[broker.Subscription]struct{}
+
+	// captured caches whether a stream captures the topics
+	capturedMu sync.Mutex
+	captured   map[string]bool
+}
+
+// NewClient returns the NATS client of the url, e.g., "nats://localhost:4222".
+// The connection is re-established by the NATS client after it's lost.
+func NewClient(url string, opts ...Option) broker.Client {
+	o := options{
+		fetchWait: defaultFetchWait,
+		batchSize: defaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &client{
+		url:      url,
+		opts:     o,
+		subs:     make(map[broker.Subscription]struct{}),
+		captured: make(map[string]bool),
+	}
+}
+
+func (c *client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return nil
+	}
+
+	natsOpts := []nats.Option{
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warn("NATS connection is lost", "url", c.url, "err", err)
+			}
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			log.Info("NATS is reconnected", "url", c.url)
+		}),
+	}
+	if c.opts.TLSConfig != nil {
+		natsOpts = append(natsOpts, nats.Secure(c.opts.TLSConfig))
+	} else if c.opts.Secure {
+		natsOpts = append(natsOpts, nats.Secure(&tls.Config{}))
+	}
+
+	conn, err := nats.Connect(c.url, natsOpts...)
+	if err != nil {
+		return err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c.conn = conn
+	c.js = js
+	return nil
+}
+
+// Disconnect unsubscribes all the subscriptions and closes the connection
+func (c *client) Disconnect() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.js = nil
+	c.capturedMu.Lock()
+	c.captured = make(map[string]bool)
+	c.capturedMu.Unlock()
+	subs := make([]broker.Subscription, 0, len(c.subs))
+	for s := range c.subs {
+		subs = append(subs, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	return nil
+}
+
+// Publish publishes the message by JetStream if a stream captures the topic, so it
+// returns after the message is stored, or by core NATS otherwise. Whether a stream
+// captures the topic is looked up once per connection, so a stream created by others
+// afterwards is not used until the client reconnects.
+func (c *client) Publish(topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	c.mu.RLock()
+	conn, js := c.conn, c.js
+	c.mu.RUnlock()
+	if conn == nil {
+		return broker.ErrNotConnected
+	}
+
+	m := nats.NewMsg(topic)
+	for k, v := range msg.Header {
+		m.Header.Set(k, v)
+	}
+	m.Data = msg.Body
+
+	captured, err := c.isCaptured(js, topic)
+	if err != nil {
+		return err
+	}
+	if !captured {
+		return conn.PublishMsg(m)
+	}
+	_, err = js.PublishMsg(m)
+	return err
+}
+
+// isCaptured returns whether a stream captures the topic
+func (c *client) isCaptured(js nats.JetStreamContext, topic string) (bool, error) {
+	c.capturedMu.Lock()
+	captured, ok := c.captured[topic]
+	c.capturedMu.Unlock()
+	if ok {
+		return captured, nil
+	}
+
+	_, err := js.StreamNameBySubject(topic)
+	if err != nil && !errors.Is(err, nats.ErrNoMatchingStream) {
+		return false, err
+	}
+	c.setCaptured(topic, err == nil)
+	return err == nil, nil
+}
+
+func (c *client) setCaptured(topic string, captured bool) {
+	c.capturedMu.Lock()
+	defer c.capturedMu.Unlock()
+	c.captured[topic] = captured
+}
+
+func (c *client) Subscribe(topic string, opts ...broker.SubscribeOption) (broker.Subscription, error) {
+	options := broker.SubscribeOptions{
+		AutoAck: true,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil, broker.ErrNotConnected
+	}
+
+	var (
+		s   broker.Subscription
+		err error
+	)
+	if options.Queue == "" {
+		s, err = c.subscribeCore(topic)
+	} else {
+		s, err = c.subscribeJetStream(topic, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.subs[s] = struct{}{}
+	return s, nil
+}
+
+func (c *client) remove(s broker.Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, s)
+}
+
+// ----------------------------------------------------------------------------
+
+// coreSubscription drops the messages if the subscriber is too slow
+type coreSubscription struct {
+	client *client
+	topic  string
+	sub    *nats.Subscription
+	msgCh  chan *nats.Msg
+	ch     chan broker.Publication
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (c *client) subscribeCore(topic string) (*coreSubscription, error) {
+	s := &coreSubscription{
+		client: c,
+		topic:  topic,
+		msgCh:  make(chan *nats.Msg, pendingSize),
+		ch:     make(chan broker.Publication),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	sub, err := c.conn.ChanSubscribe(topic, s.msgCh)
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+	go s.run()
+	return s, nil
+}
+
+func (s *coreSubscription) Topic() string {
+	return s.topic
+}
+
+func (s *coreSubscription) Chan() <-chan broker.Publication {
+	return s.ch
+}
+
+func (s *coreSubscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		s.client.remove(s)
+		if err = s.sub.Unsubscribe(); err == nats.ErrConnectionClosed {
+			err = nil
+		}
+		close(s.done)
+		<-s.exited
+		close(s.ch)
+	})
+	return err
+}
+
+func (s *coreSubscription) run() {
+	defer close(s.exited)
+	for {
+		select {
+		case m := <-s.msgCh:
+			select {
+			case s.ch <- newPublication(m, true):
+			case <-s.done:
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// jetStreamSubscription pulls the messages from a durable consumer
+type jetStreamSubscription struct {
+	client *client
+	topic  string
+	opts   broker.SubscribeOptions
+	sub    *nats.Subscription
+	ch     chan broker.Publication
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (c *client) subscribeJetStream(topic string, options broker.SubscribeOptions) (*jetStreamSubscription, error) {
+	stream, err := c.ensureStream(topic)
+	if err != nil {
+		return nil, err
+	}
+	durable := sanitize(options.Queue)
+	if err := c.ensureConsumer(stream, durable, topic, options); err != nil {
+		return nil, err
+	}
+	// the consumer is bound, so it's kept after the subscription is closed
+	sub, err := c.js.PullSubscribe(topic, durable, nats.Bind(stream, durable))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &jetStreamSubscription{
+		client: c,
+		topic:  topic,
+		opts:   options,
+		sub:    sub,
+		ch:     make(chan broker.Publication),
+		ctx:    ctx,
+		cancel: cancel,
+		exited: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// ensureStream returns the stream capturing the topic, which is created if there is none
+func (c *client) ensureStream(topic string) (string, error) {
+	stream, err := c.js.StreamNameBySubject(topic)
+	if err == nil {
+		return stream, nil
+	}
+	if !errors.Is(err, nats.ErrNoMatchingStream) {
+		return "", err
+	}
+
+	info, err := c.js.AddStream(&nats.StreamConfig{
+		Name:     sanitize(topic),
+		Subjects: []string{topic},
+	})
+	if err != nil {
+		return "", err
+	}
+	c.setCaptured(topic, true)
+	return info.Config.Name, nil
+}
+
+func (c *client) ensureConsumer(stream, durable, topic string, options broker.SubscribeOptions) error {
+	_, err := c.js.ConsumerInfo(stream, durable)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+
+	_, err = c.js.AddConsumer(stream, &nats.ConsumerConfig{
+		Durable:       durable,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       options.AckTimeout,
+		FilterSubject: topic,
+	})
+	return err
+}
+
+// sanitize replaces the characters not allowed in the names of the streams and the consumers
+func sanitize(name string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(name)
+}
+
+func (s *jetStreamSubscription) Topic() string {
+	return s.topic
+}
+
+func (s *jetStreamSubscription) Chan() <-chan broker.Publication {
+	return s.ch
+}
+
+// Unsubscribe stops pulling the messages and closes the channel.
+// The undelivered messages are redelivered to the others of the Queue at once, and the
+// unacked messages are redelivered after AckTimeout.
+func (s *jetStreamSubscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		s.client.remove(s)
+		s.cancel()
+		<-s.exited
+		if err = s.sub.Unsubscribe(); err == nats.ErrConnectionClosed {
+			err = nil
+		}
+		close(s.ch)
+	})
+	return err
+}
+
+func (s *jetStreamSubscription) run() {
+	defer close(s.exited)
+	for {
+		ctx, cancel := context.WithTimeout(s.ctx, s.client.opts.fetchWait)
+		msgs, err := s.sub.Fetch(s.client.opts.batchSize, nats.Context(ctx))
+		cancel()
+		if s.ctx.Err() != nil {
+			nakAll(msgs)
+			return
+		}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+				continue
+			}
+			log.Warn("Failed to fetch the NATS messages", "topic", s.topic, "err", err)
+			select {
+			case <-time.After(retryInterval):
+				continue
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
+		for i, m := range msgs {
+			select {
+			case s.ch <- newPublication(m, s.opts.AutoAck):
+			case <-s.ctx.Done():
+				nakAll(msgs[i:])
+				return
+			}
+			if s.opts.AutoAck {
+				if err := m.Ack(); err != nil {
+					log.Warn("Failed to ack the NATS message", "topic", s.topic, "err", err)
+				}
+			}
+		}
+	}
+}
+
+func nakAll(msgs []*nats.Msg) {
+	for _, m := range msgs {
+		m.Nak()
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type publication struct {
+	msg     *nats.Msg
+	message *broker.Message
+	autoAck bool
+}
+
+func newPublication(m *nats.Msg, autoAck bool) *publication {
+	msg := &broker.Message{
+		Header: make(map[string]string, len(m.Header)),
+		Body:   m.Data,
+	}
+	for k := range m.Header {
+		msg.Header[k] = m.Header.Get(k)
+	}
+	return &publication{
+		msg:     m,
+		message: msg,
+		autoAck: autoAck,
+	}
+}
+
+func (p *publication) Topic() string {
+	return p.msg.Subject
+}
+
+func (p *publication) Message() *broker.Message {
+	return p.message
+}
+
+// Ack acknowledges the JetStream message, and it's a no-op for the core NATS messages
+func (p *publication) Ack() error {
+	if p.autoAck {
+		return nil
+	}
+	return p.msg.Ack()
+}
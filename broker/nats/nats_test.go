@@ -0,0 +1,132 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	testutils "github.com/getamis/sirius/broker/test"
+	"github.com/getamis/sirius/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, sub broker.Subscription) broker.Publication {
+	select {
+	case p, ok := <-sub.Chan():
+		assert.True(t, ok, "should be true")
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the publication")
+	}
+	return nil
+}
+
+func TestNATSClient(t *testing.T) {
+	container, err := test.NewNATSContainer()
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, container.Start(), "should be no error")
+	defer container.Stop()
+
+	testNATSClient(t, container.URL)
+}
+
+func testNATSClient(t *testing.T, url string) {
+	client := NewClient(url, FetchWait(time.Second))
+	assert.Equal(t, broker.ErrNotConnected, client.Publish("topic", &broker.Message{}), "should be equal")
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	testCore(t, client)
+	testQueue(t, client)
+	testRedelivery(t, client)
+	testutils.RunTestFanOut(t, client)
+	testutils.RunTestQueue(t, client)
+	testutils.RunTestRedelivery(t, client)
+}
+
+func testCore(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("core.foo")
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe("core.*")
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	msg := &broker.Message{
+		Header: map[string]string{"foo": "bar"},
+		Body:   []byte("hello"),
+	}
+	assert.NoError(t, client.Publish("core.foo", msg), "should be no error")
+	for _, sub := range []broker.Subscription{sub1, sub2} {
+		p := receive(t, sub)
+		assert.Equal(t, "core.foo", p.Topic(), "should be equal")
+		assert.Equal(t, msg, p.Message(), "should be equal")
+		assert.NoError(t, p.Ack(), "should be no error")
+	}
+}
+
+func testQueue(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("queue.orders", broker.Queue("testQueue"))
+	assert.NoError(t, err, "should be no error")
+	sub2, err := client.Subscribe("queue.orders", broker.Queue("testQueue"))
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	// the messages are shared by the subscriptions
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, client.Publish("queue.orders", &broker.Message{Body: []byte{'0' + byte(i)}}), "should be no error")
+	}
+	received := make(map[string]bool)
+	for len(received) < 10 {
+		select {
+		case p := <-sub1.Chan():
+			received[string(p.Message().Body)] = true
+		case p := <-sub2.Chan():
+			received[string(p.Message().Body)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the publication")
+		}
+	}
+
+	// the messages are kept while there is no subscription
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	assert.NoError(t, sub2.Unsubscribe(), "should be no error")
+	assert.NoError(t, client.Publish("queue.orders", &broker.Message{Body: []byte("kept")}), "should be no error")
+	sub, err := client.Subscribe("queue.orders", broker.Queue("testQueue"))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	assert.Equal(t, []byte("kept"), receive(t, sub).Message().Body, "should be equal")
+}
+
+func testRedelivery(t *testing.T, client broker.Client) {
+	sub, err := client.Subscribe("redelivery", broker.Queue("testRedelivery"), broker.AutoAck(false), broker.AckTimeout(time.Second))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+
+	assert.NoError(t, client.Publish("redelivery", &broker.Message{Body: []byte("foo")}), "should be no error")
+	receive(t, sub)
+	// the unacked message is redelivered after the timeout
+	p := receive(t, sub)
+	assert.Equal(t, []byte("foo"), p.Message().Body, "should be equal")
+	assert.NoError(t, p.Ack(), "should be no error")
+
+	select {
+	case p := <-sub.Chan():
+		t.Fatalf("unexpected publication %v", p)
+	case <-time.After(2 * time.Second):
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"time"
+
+	"github.com/getamis/sirius/broker"
+)
+
+const (
+	defaultFetchWait = 5 * time.Second
+	defaultBatchSize = 16
+)
+
+type options struct {
+	broker.Options
+
+	fetchWait time.Duration
+	batchSize int
+}
+
+// Option configures the NATS client
+type Option func(o *options)
+
+// BrokerOptions returns an option to apply the common broker options, e.g., Secure and TLSConfig
+func BrokerOptions(opts ...broker.Option) Option {
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(&o.Options)
+		}
+	}
+}
+
+// FetchWait returns an option to set the time to wait for the messages of a pull
+// of a Queue subscription, which is 5s by default
+func FetchWait(t time.Duration) Option {
+	return func(o *options) {
+		o.fetchWait = t
+	}
+}
+
+// BatchSize returns an option to set the max number of the messages of a pull
+// of a Queue subscription, which is 16 by default
+func BatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
@@ -16,6 +16,7 @@ package broker
 
 import (
 	"crypto/tls"
+	"time"
 )
 
 type Options struct {
@@ -42,10 +43,21 @@ type Option func(*Options)
 // ----------------------------------------------------------------------------
 
 type PublishOptions struct {
+	// PartitionKey is the key to choose the partition of the message,
+	// so the messages with the same key are kept in order.
+	// It's ignored by the brokers without partitions.
+	PartitionKey []byte
 }
 
 type PublishOption func(*PublishOptions)
 
+// PartitionKey sets the key to choose the partition of the message
+func PartitionKey(key []byte) PublishOption {
+	return func(o *PublishOptions) {
+		o.PartitionKey = key
+	}
+}
+
 // ----------------------------------------------------------------------------
 
 type SubscribeOptions struct {
@@ -56,6 +68,10 @@ type SubscribeOptions struct {
 	// will create a shared subscription where each
 	// receives a subset of messages.
 	Queue string
+	// AckTimeout is the time to wait for the ack of a message
+	// before it's redelivered if AutoAck is disabled.
+	// Zero uses the default of the broker.
+	AckTimeout time.Duration
 }
 
 type SubscribeOption func(*SubscribeOptions)
@@ -74,3 +90,10 @@ func Queue(name string) SubscribeOption {
 		o.Queue = name
 	}
 }
+
+// AckTimeout sets the time to wait for the ack of a message before it's redelivered
+func AckTimeout(t time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.AckTimeout = t
+	}
+}
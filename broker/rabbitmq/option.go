@@ -0,0 +1,68 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rabbitmq
+
+import (
+	"time"
+
+	"github.com/getamis/sirius/broker"
+)
+
+const (
+	defaultExchange          = "sirius"
+	defaultReconnectInterval = 5 * time.Second
+)
+
+type options struct {
+	broker.Options
+
+	exchange          string
+	prefetchCount     int
+	reconnectInterval time.Duration
+}
+
+// Option configures the RabbitMQ client
+type Option func(o *options)
+
+// BrokerOptions returns an option to apply the common broker options, e.g., Secure and TLSConfig
+func BrokerOptions(opts ...broker.Option) Option {
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(&o.Options)
+		}
+	}
+}
+
+// Exchange returns an option to set the topic exchange of the messages, which is "sirius" by default
+func Exchange(name string) Option {
+	return func(o *options) {
+		o.exchange = name
+	}
+}
+
+// PrefetchCount returns an option to limit the unacked messages of a subscription
+// without AutoAck, which is unlimited by default
+func PrefetchCount(n int) Option {
+	return func(o *options) {
+		o.prefetchCount = n
+	}
+}
+
+// ReconnectInterval returns an option to set the interval between the reconnections
+func ReconnectInterval(t time.Duration) Option {
+	return func(o *options) {
+		o.reconnectInterval = t
+	}
+}
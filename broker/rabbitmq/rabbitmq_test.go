@@ -0,0 +1,168 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	testutils "github.com/getamis/sirius/broker/test"
+	"github.com/getamis/sirius/test"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, sub broker.Subscription) broker.Publication {
+	select {
+	case p, ok := <-sub.Chan():
+		assert.True(t, ok, "should be true")
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the publication")
+	}
+	return nil
+}
+
+func TestRabbitMQClient(t *testing.T) {
+	container, err := test.NewRabbitMQContainer()
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, container.Start(), "should be no error")
+	defer container.Stop()
+
+	client := NewClient(container.URL, ReconnectInterval(time.Second))
+	assert.Equal(t, broker.ErrNotConnected, client.Publish("topic", &broker.Message{}), "should be equal")
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	testFanOut(t, client)
+	testQueue(t, client)
+	testQueuePerTopic(t, client)
+	testutils.RunTestFanOut(t, client)
+	testutils.RunTestQueue(t, client)
+	testutils.RunTestRedelivery(t, client)
+	testClosedChannels(t, client, container)
+	testReconnect(t, client, container)
+}
+
+func testFanOut(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("fanout.foo")
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe("fanout.*")
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	msg := &broker.Message{
+		Header: map[string]string{"foo": "bar"},
+		Body:   []byte("hello"),
+	}
+	assert.NoError(t, client.Publish("fanout.foo", msg), "should be no error")
+	for _, sub := range []broker.Subscription{sub1, sub2} {
+		p := receive(t, sub)
+		assert.Equal(t, "fanout.foo", p.Topic(), "should be equal")
+		assert.Equal(t, msg, p.Message(), "should be equal")
+		assert.NoError(t, p.Ack(), "should be no error")
+	}
+}
+
+func testQueue(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("queue", broker.Queue("testQueue"), broker.AutoAck(false))
+	assert.NoError(t, err, "should be no error")
+	sub2, err := client.Subscribe("queue", broker.Queue("testQueue"), broker.AutoAck(false))
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	assert.NoError(t, client.Publish("queue", &broker.Message{Body: []byte("0")}), "should be no error")
+	assert.NoError(t, client.Publish("queue", &broker.Message{Body: []byte("1")}), "should be no error")
+	p1 := receive(t, sub1)
+	p2 := receive(t, sub2)
+	assert.NotEqual(t, p1.Message().Body, p2.Message().Body, "should be not equal")
+	assert.NoError(t, p2.Ack(), "should be no error")
+
+	// the unacked message is redelivered to the other
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	p := receive(t, sub2)
+	assert.Equal(t, p1.Message().Body, p.Message().Body, "should be equal")
+	assert.NoError(t, p.Ack(), "should be no error")
+}
+
+func testQueuePerTopic(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("perTopic.foo", broker.Queue("testQueuePerTopic"))
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe("perTopic.bar", broker.Queue("testQueuePerTopic"))
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	// the same queue name on another topic doesn't receive the messages
+	assert.NoError(t, client.Publish("perTopic.foo", &broker.Message{Body: []byte("foo")}), "should be no error")
+	assert.Equal(t, []byte("foo"), receive(t, sub1).Message().Body, "should be equal")
+	select {
+	case <-sub2.Chan():
+		t.Fatal("should not receive the message of another topic")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func testClosedChannels(t *testing.T, client broker.Client, container *test.RabbitMQContainer) {
+	sub, err := client.Subscribe("closed", broker.Queue("testClosedChannels"))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+
+	conn, err := amqp.Dial(container.URL)
+	assert.NoError(t, err, "should be no error")
+	defer conn.Close()
+	ch, err := conn.Channel()
+	assert.NoError(t, err, "should be no error")
+
+	// the consumer is cancelled after the queue is deleted, and it's consumed again
+	_, err = ch.QueueDelete("closed.testClosedChannels", false, false, false)
+	assert.NoError(t, err, "should be no error")
+	time.Sleep(time.Second)
+	assert.NoError(t, client.Publish("closed", &broker.Message{Body: []byte("hello")}), "should be no error")
+	assert.Equal(t, []byte("hello"), receive(t, sub).Message().Body, "should be equal")
+
+	// the publishing channel is closed by publishing to the deleted exchange, and it's opened again
+	assert.NoError(t, ch.ExchangeDelete(defaultExchange, false, false), "should be no error")
+	client.Publish("closed", &broker.Message{Body: []byte("lost")})
+	published := false
+	for i := 0; i < 10 && !published; i++ {
+		time.Sleep(500 * time.Millisecond)
+		published = client.Publish("closed", &broker.Message{Body: []byte("hello")}) == nil
+	}
+	assert.True(t, published, "should be true")
+}
+
+func testReconnect(t *testing.T, client broker.Client, container *test.RabbitMQContainer) {
+	sub, err := client.Subscribe("reconnect", broker.Queue("testReconnect"))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+
+	assert.NoError(t, container.Suspend(), "should be no error")
+	time.Sleep(time.Second)
+	assert.NoError(t, container.Start(), "should be no error")
+
+	// the subscription is restored after the reconnection
+	published := false
+	for i := 0; i < 30 && !published; i++ {
+		published = client.Publish("reconnect", &broker.Message{Body: []byte("hello")}) == nil
+		if !published {
+			time.Sleep(time.Second)
+		}
+	}
+	assert.True(t, published, "should be true")
+	assert.Equal(t, []byte("hello"), receive(t, sub).Message().Body, "should be equal")
+}
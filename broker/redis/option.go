@@ -0,0 +1,191 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	"gopkg.in/redis.v5"
+)
+
+const (
+	defaultClaimInterval = 5 * time.Second
+	defaultAckTimeout    = 30 * time.Second
+	defaultBatchSize     = 16
+
+	// defaultReadTimeout is the read timeout of redis.v5 if it's not set
+	defaultReadTimeout = 3 * time.Second
+)
+
+type mode int
+
+const (
+	standaloneMode mode = iota
+	clusterMode
+	sentinelMode
+)
+
+type options struct {
+	redis.Options
+	broker broker.Options
+
+	mode       mode
+	masterName string
+
+	maxLen        int64
+	claimInterval time.Duration
+	batchSize     int64
+}
+
+// tlsConfig returns the TLS config of the broker options, or nil if TLS is disabled
+func (o *options) tlsConfig() *tls.Config {
+	if o.broker.TLSConfig == nil && o.broker.Secure {
+		return &tls.Config{}
+	}
+	return o.broker.TLSConfig
+}
+
+func (o *options) newClient(endpoints []string) redisClient {
+	switch o.mode {
+	case clusterMode:
+		return redis.NewClusterClient(o.clusterOptions(endpoints))
+	case sentinelMode:
+		return redis.NewFailoverClient(o.failoverOptions(endpoints))
+	default:
+		opt := o.Options
+		opt.Addr = endpoints[0]
+		opt.TLSConfig = o.tlsConfig()
+		return redis.NewClient(&opt)
+	}
+}
+
+// readerOptions returns the options of the dedicated connection of the blocking reads
+// of a subscription, which has to wait readBlock longer than the read timeout.
+func (o *options) readerOptions() *options {
+	reader := *o
+	reader.PoolSize = 1
+	if reader.ReadTimeout == 0 {
+		reader.ReadTimeout = defaultReadTimeout
+	}
+	if reader.ReadTimeout > 0 {
+		reader.ReadTimeout += readBlock
+	}
+	return &reader
+}
+
+func (o *options) clusterOptions(endpoints []string) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Addrs:        endpoints,
+		Password:     o.Password,
+		DialTimeout:  o.DialTimeout,
+		ReadTimeout:  o.ReadTimeout,
+		WriteTimeout: o.WriteTimeout,
+		PoolSize:     o.PoolSize,
+		PoolTimeout:  o.PoolTimeout,
+	}
+}
+
+func (o *options) failoverOptions(endpoints []string) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:    o.masterName,
+		SentinelAddrs: endpoints,
+		Password:      o.Password,
+		DialTimeout:   o.DialTimeout,
+		ReadTimeout:   o.ReadTimeout,
+		WriteTimeout:  o.WriteTimeout,
+		PoolSize:      o.PoolSize,
+		PoolTimeout:   o.PoolTimeout,
+	}
+}
+
+// Option configures the Redis Streams client
+type Option func(o *options)
+
+// BrokerOptions returns an option to apply the common broker options, e.g., Secure and TLSConfig,
+// which are supported in the standalone mode only
+func BrokerOptions(opts ...broker.Option) Option {
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(&o.broker)
+		}
+	}
+}
+
+// Cluster connects to a Redis Cluster, the endpoints are the seed nodes of the cluster
+func Cluster() Option {
+	return func(o *options) {
+		o.mode = clusterMode
+	}
+}
+
+// Sentinel connects to the master of a Sentinel-managed failover group,
+// the endpoints are the addresses of the sentinels
+func Sentinel(masterName string) Option {
+	return func(o *options) {
+		o.mode = sentinelMode
+		o.masterName = masterName
+	}
+}
+
+// MaxLen returns an option to trim the streams to about n messages on publishing,
+// which are not trimmed by default
+func MaxLen(n int64) Option {
+	return func(o *options) {
+		o.maxLen = n
+	}
+}
+
+// ClaimInterval returns an option to set the interval between the claims of the messages
+// whose consumers don't ack them in AckTimeout, which is 5s by default
+func ClaimInterval(t time.Duration) Option {
+	return func(o *options) {
+		o.claimInterval = t
+	}
+}
+
+// BatchSize returns an option to set the max number of the messages of a read, which is 16 by default
+func BatchSize(n int64) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+func DialTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.DialTimeout = t
+	}
+}
+
+// ReadTimeout returns an option to set the read timeout of the commands, which is 3s by default.
+// The blocking reads of the subscriptions wait readBlock longer on their own connections.
+func ReadTimeout(t time.Duration) Option {
+	return func(o *options) {
+		o.ReadTimeout = t
+	}
+}
+
+func PoolSize(size int) Option {
+	return func(o *options) {
+		o.PoolSize = size
+	}
+}
+
+func Password(pwd string) Option {
+	return func(o *options) {
+		o.Password = pwd
+	}
+}
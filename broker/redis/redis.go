@@ -0,0 +1,534 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	"github.com/getamis/sirius/log"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/redis.v5"
+)
+
+// ErrTLSNotSupported is returned if TLS is enabled in the cluster or the sentinel mode,
+// which redis.v5 doesn't support
+var ErrTLSNotSupported = errors.New("redis: TLS is supported in the standalone mode only")
+
+const (
+	// readBlock is how long a read blocks, and the readers wait it longer than the read timeout
+	readBlock = time.Second
+
+	// headerPrefix is the prefix of the fields of the headers in the stream entries
+	headerPrefix = "header:"
+	bodyField    = "body"
+)
+
+// client publishes the messages to the Redis streams of the topics by XADD.
+// A subscription without a Queue reads the messages added after it subscribes by XREAD,
+// and Ack is a no-op. The subscriptions with a Queue share the messages by the consumer
+// group of the name, which is created at the end of the stream if it doesn't exist,
+// and Ack is XACK. The pending messages which are not acked in AckTimeout, e.g., the
+// consumer died, are claimed by the others of the group by XAUTOCLAIM, which requires
+// Redis 6.2 or later.
+// Each subscription blocks on a connection of its own, so the reads don't hold the
+// pooled connections of the publishing and the acks.
+type client struct {
+	endpoints []string
+	opts      options
+
+	mu     sync.RWMutex
+	client redisClient
+	subs   map[*subscription]struct{}
+}
+
+// redisClient is implemented by *redis.Client, the sentinel failover client
+// and *redis.ClusterClient
+type redisClient interface {
+	Ping() *redis.StatusCmd
+	Process(cmd redis.Cmder) error
+	Close() error
+}
+
+// NewClient returns the Redis Streams client of the endpoints
+func NewClient(endpoints []string, opts ...Option) broker.Client {
+	o := options{
+		claimInterval: defaultClaimInterval,
+		batchSize:     defaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &client{
+		endpoints: endpoints,
+		opts:      o,
+		subs:      make(map[*subscription]struct{}),
+	}
+}
+
+func (c *client) Connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return nil
+	}
+
+	if c.opts.tlsConfig() != nil && c.opts.mode != standaloneMode {
+		return ErrTLSNotSupported
+	}
+
+	client := c.opts.newClient(c.endpoints)
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return err
+	}
+	c.client = client
+	return nil
+}
+
+// Disconnect unsubscribes all the subscriptions and closes the client
+func (c *client) Disconnect() error {
+	c.mu.Lock()
+	client := c.client
+	subs := make([]*subscription, 0, len(c.subs))
+	for s := range c.subs {
+		subs = append(subs, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+
+	c.mu.Lock()
+	c.client = nil
+	c.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+func (c *client) Publish(topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	if client == nil {
+		return broker.ErrNotConnected
+	}
+
+	args := []interface{}{"xadd", topic}
+	if c.opts.maxLen > 0 {
+		args = append(args, "maxlen", "~", c.opts.maxLen)
+	}
+	args = append(args, "*", bodyField, msg.Body)
+	for k, v := range msg.Header {
+		args = append(args, headerPrefix+k, v)
+	}
+	return client.Process(redis.NewStringCmd(args...))
+}
+
+func (c *client) Subscribe(topic string, opts ...broker.SubscribeOption) (broker.Subscription, error) {
+	options := broker.SubscribeOptions{
+		AutoAck:    true,
+		AckTimeout: defaultAckTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.AckTimeout <= 0 {
+		options.AckTimeout = defaultAckTimeout
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil, broker.ErrNotConnected
+	}
+
+	s := &subscription{
+		client:   c.client,
+		reader:   c.opts.readerOptions().newClient(c.endpoints),
+		opts:     options,
+		topic:    topic,
+		batch:    c.opts.batchSize,
+		interval: c.opts.claimInterval,
+		ch:       make(chan broker.Publication),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		remove:   c.remove,
+	}
+	if options.Queue == "" {
+		from, err := s.lastID()
+		if err != nil {
+			s.reader.Close()
+			return nil, err
+		}
+		s.from = from
+	} else {
+		if err := s.createGroup(); err != nil {
+			s.reader.Close()
+			return nil, err
+		}
+		s.consumer = uuid.NewV4().String()
+	}
+	c.subs[s] = struct{}{}
+	go s.run()
+	return s, nil
+}
+
+func (c *client) remove(s *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, s)
+}
+
+type subscription struct {
+	client redisClient
+	// reader is the dedicated client of the blocking reads
+	reader   redisClient
+	topic    string
+	opts     broker.SubscribeOptions
+	batch    int64
+	interval time.Duration
+	remove   func(*subscription)
+
+	// from is the id of the last message read without a Queue
+	from string
+	// consumer is the name of the consumer in the group of the Queue
+	consumer string
+
+	ch     chan broker.Publication
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (s *subscription) Topic() string {
+	return s.topic
+}
+
+func (s *subscription) Chan() <-chan broker.Publication {
+	return s.ch
+}
+
+// Unsubscribe stops reading and closes the channel.
+// The consumer is removed from the group if it has no pending messages, otherwise
+// it's removed by the others once they claim the pending messages after AckTimeout.
+func (s *subscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		s.remove(s)
+		close(s.done)
+		<-s.exited
+		close(s.ch)
+		s.reader.Close()
+		if s.consumer != "" {
+			err = s.deleteConsumer()
+		}
+	})
+	return err
+}
+
+func (s *subscription) run() {
+	defer close(s.exited)
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		var (
+			entries []*entry
+			err     error
+		)
+		if s.consumer == "" {
+			entries, err = s.read()
+		} else if time.Since(lastClaim) >= s.interval {
+			lastClaim = time.Now()
+			entries, err = s.claim()
+		} else {
+			entries, err = s.readGroup()
+		}
+		if err != nil {
+			log.Warn("Failed to read the stream", "topic", s.topic, "queue", s.opts.Queue, "err", err)
+			select {
+			case <-s.done:
+				return
+			case <-time.After(readBlock):
+			}
+			continue
+		}
+
+		for _, e := range entries {
+			select {
+			case s.ch <- s.newPublication(e):
+			case <-s.done:
+				return
+			}
+			if s.consumer != "" && s.opts.AutoAck {
+				if err := s.ack(e.id); err != nil {
+					log.Warn("Failed to ack the message", "topic", s.topic, "queue", s.opts.Queue, "id", e.id, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// lastID returns the id of the last message of the stream, or "0-0" if it's empty
+func (s *subscription) lastID() (string, error) {
+	cmd := redis.NewSliceCmd("xrevrange", s.topic, "+", "-", "count", 1)
+	if err := s.client.Process(cmd); err != nil {
+		return "", err
+	}
+	for _, reply := range cmd.Val() {
+		if e, ok := parseEntry(reply); ok {
+			return e.id, nil
+		}
+	}
+	return "0-0", nil
+}
+
+// read reads the messages after s.from
+func (s *subscription) read() ([]*entry, error) {
+	cmd := redis.NewSliceCmd(
+		"xread",
+		"count", s.batch,
+		"block", int64(readBlock/time.Millisecond),
+		"streams", s.topic, s.from,
+	)
+	entries, err := s.readStreams(cmd)
+	if len(entries) > 0 {
+		s.from = entries[len(entries)-1].id
+	}
+	return entries, err
+}
+
+// readGroup reads the messages never delivered to the group
+func (s *subscription) readGroup() ([]*entry, error) {
+	cmd := redis.NewSliceCmd(
+		"xreadgroup",
+		"group", s.opts.Queue, s.consumer,
+		"count", s.batch,
+		"block", int64(readBlock/time.Millisecond),
+		"streams", s.topic, ">",
+	)
+	return s.readStreams(cmd)
+}
+
+func (s *subscription) readStreams(cmd *redis.SliceCmd) ([]*entry, error) {
+	if err := s.reader.Process(cmd); err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*entry
+	for _, stream := range cmd.Val() {
+		reply, ok := stream.([]interface{})
+		if !ok || len(reply) != 2 {
+			continue
+		}
+		replies, _ := reply[1].([]interface{})
+		for _, r := range replies {
+			if e, ok := parseEntry(r); ok {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// claim claims the messages which are pending longer than AckTimeout in the group,
+// and then removes the idle consumers left without pending messages
+func (s *subscription) claim() ([]*entry, error) {
+	entries, err := s.autoClaim()
+	if err == nil {
+		if err := s.deleteIdleConsumers(); err != nil {
+			log.Warn("Failed to delete the idle consumers", "topic", s.topic, "queue", s.opts.Queue, "err", err)
+		}
+	}
+	return entries, err
+}
+
+func (s *subscription) autoClaim() ([]*entry, error) {
+	var entries []*entry
+	cursor := "0-0"
+	for {
+		cmd := redis.NewSliceCmd(
+			"xautoclaim", s.topic, s.opts.Queue, s.consumer,
+			int64(s.opts.AckTimeout/time.Millisecond), cursor,
+			"count", s.batch,
+		)
+		if err := s.client.Process(cmd); err != nil {
+			return entries, err
+		}
+
+		reply := cmd.Val()
+		if len(reply) < 2 {
+			return entries, nil
+		}
+		replies, _ := reply[1].([]interface{})
+		for _, r := range replies {
+			if e, ok := parseEntry(r); ok {
+				entries = append(entries, e)
+			}
+		}
+		cursor, _ = reply[0].(string)
+		if cursor == "" || cursor == "0-0" || int64(len(entries)) >= s.batch {
+			return entries, nil
+		}
+	}
+}
+
+// createGroup creates the group of the Queue at the end of the stream if it doesn't exist
+func (s *subscription) createGroup() error {
+	cmd := redis.NewStatusCmd("xgroup", "create", s.topic, s.opts.Queue, "$", "mkstream")
+	err := s.client.Process(cmd)
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// deleteIdleConsumers deletes the other consumers of the group which have no pending
+// messages and are idle longer than AckTimeout, e.g. the ones whose pending messages
+// are claimed after they're gone. A consumer blocking on a read is idle for readBlock at most.
+func (s *subscription) deleteIdleConsumers() error {
+	cmd := redis.NewSliceCmd("xinfo", "consumers", s.topic, s.opts.Queue)
+	if err := s.client.Process(cmd); err != nil {
+		return err
+	}
+	maxIdle := int64((s.opts.AckTimeout + readBlock) / time.Millisecond)
+	for _, reply := range cmd.Val() {
+		fields, ok := reply.([]interface{})
+		if !ok {
+			continue
+		}
+		var (
+			name          string
+			pending, idle int64
+		)
+		for i := 0; i+1 < len(fields); i += 2 {
+			switch fields[i] {
+			case "name":
+				name, _ = fields[i+1].(string)
+			case "pending":
+				pending, _ = fields[i+1].(int64)
+			case "idle":
+				idle, _ = fields[i+1].(int64)
+			}
+		}
+		if name == "" || name == s.consumer || pending > 0 || idle <= maxIdle {
+			continue
+		}
+		err := s.client.Process(redis.NewIntCmd("xgroup", "delconsumer", s.topic, s.opts.Queue, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *subscription) deleteConsumer() error {
+	pending := redis.NewSliceCmd("xpending", s.topic, s.opts.Queue, "-", "+", 1, s.consumer)
+	if err := s.client.Process(pending); err != nil {
+		return err
+	}
+	if len(pending.Val()) > 0 {
+		return nil
+	}
+	return s.client.Process(redis.NewIntCmd("xgroup", "delconsumer", s.topic, s.opts.Queue, s.consumer))
+}
+
+func (s *subscription) ack(id string) error {
+	return s.client.Process(redis.NewIntCmd("xack", s.topic, s.opts.Queue, id))
+}
+
+// entry is a message in the stream
+type entry struct {
+	id     string
+	fields map[string]string
+}
+
+func parseEntry(reply interface{}) (*entry, bool) {
+	r, ok := reply.([]interface{})
+	if !ok || len(r) != 2 {
+		return nil, false
+	}
+	id, ok := r[0].(string)
+	if !ok {
+		return nil, false
+	}
+	values, ok := r[1].([]interface{})
+	if !ok {
+		// the message is deleted from the stream
+		return nil, false
+	}
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		field, _ := values[i].(string)
+		value, _ := values[i+1].(string)
+		fields[field] = value
+	}
+	return &entry{id: id, fields: fields}, true
+}
+
+type publication struct {
+	sub     *subscription
+	id      string
+	message *broker.Message
+}
+
+func (s *subscription) newPublication(e *entry) *publication {
+	msg := &broker.Message{
+		Header: make(map[string]string),
+	}
+	for field, value := range e.fields {
+		if field == bodyField {
+			msg.Body = []byte(value)
+		} else if strings.HasPrefix(field, headerPrefix) {
+			msg.Header[strings.TrimPrefix(field, headerPrefix)] = value
+		}
+	}
+	return &publication{
+		sub:     s,
+		id:      e.id,
+		message: msg,
+	}
+}
+
+func (p *publication) Topic() string {
+	return p.sub.topic
+}
+
+func (p *publication) Message() *broker.Message {
+	return p.message
+}
+
+// Ack acknowledges the message by XACK, and it's a no-op without a Queue
+func (p *publication) Ack() error {
+	if p.sub.consumer == "" || p.sub.opts.AutoAck {
+		return nil
+	}
+	return p.sub.ack(p.id)
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	testutils "github.com/getamis/sirius/broker/test"
+	"github.com/getamis/sirius/test"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/redis.v5"
+)
+
+func receive(t *testing.T, sub broker.Subscription) broker.Publication {
+	select {
+	case p, ok := <-sub.Chan():
+		assert.True(t, ok, "should be true")
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the publication")
+	}
+	return nil
+}
+
+func TestRedisClient(t *testing.T) {
+	container := test.NewRedisContainer(test.LoadRedisOptions())
+	assert.NoError(t, container.Start(), "should be no error")
+	defer container.Stop()
+
+	testRedisClient(t, container.Endpoint)
+}
+
+func testRedisClient(t *testing.T, endpoint string) {
+	client := NewClient([]string{endpoint}, ClaimInterval(100*time.Millisecond))
+	assert.Equal(t, broker.ErrNotConnected, client.Publish("topic", &broker.Message{}), "should be equal")
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	testFanOut(t, client)
+	testQueue(t, client)
+	testutils.RunTestFanOut(t, client)
+	testutils.RunTestQueue(t, client)
+	testutils.RunTestRedelivery(t, client)
+	testClaim(t, client, endpoint)
+	testBlockingReads(t, endpoint)
+}
+
+func testBlockingReads(t *testing.T, endpoint string) {
+	// the blocking reads don't hold the only pooled connection, and don't time out
+	client := NewClient([]string{endpoint}, PoolSize(1), ReadTimeout(readBlock/2))
+	assert.NoError(t, client.Connect(), "should be no error")
+	defer client.Disconnect()
+
+	sub1, err := client.Subscribe("testBlockingReads")
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe("testBlockingReads", broker.Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	time.Sleep(2 * readBlock)
+	assert.NoError(t, client.Publish("testBlockingReads", &broker.Message{Body: []byte("foo")}), "should be no error")
+	for _, sub := range []broker.Subscription{sub1, sub2} {
+		assert.Equal(t, []byte("foo"), receive(t, sub).Message().Body, "should be equal")
+	}
+}
+
+func TestReaderOptions(t *testing.T) {
+	o := options{}
+	o.PoolSize = 10
+	reader := o.readerOptions()
+	assert.Equal(t, 1, reader.PoolSize, "should be equal")
+	assert.Equal(t, defaultReadTimeout+readBlock, reader.ReadTimeout, "should be equal")
+	assert.Equal(t, 10, o.PoolSize, "should be equal")
+
+	o.ReadTimeout = -1
+	assert.Equal(t, time.Duration(-1), o.readerOptions().ReadTimeout, "should be equal")
+}
+
+func testFanOut(t *testing.T, client broker.Client) {
+	// the messages published before the subscriptions are not received
+	assert.NoError(t, client.Publish("testFanOut", &broker.Message{Body: []byte("old")}), "should be no error")
+
+	sub1, err := client.Subscribe("testFanOut")
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe("testFanOut")
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	msg := &broker.Message{
+		Header: map[string]string{"foo": "bar"},
+		Body:   []byte("hello"),
+	}
+	assert.NoError(t, client.Publish("testFanOut", msg), "should be no error")
+	for _, sub := range []broker.Subscription{sub1, sub2} {
+		p := receive(t, sub)
+		assert.Equal(t, "testFanOut", p.Topic(), "should be equal")
+		assert.Equal(t, msg, p.Message(), "should be equal")
+		assert.NoError(t, p.Ack(), "should be no error")
+	}
+}
+
+func testQueue(t *testing.T, client broker.Client) {
+	sub1, err := client.Subscribe("testQueue", broker.Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	sub2, err := client.Subscribe("testQueue", broker.Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+
+	// the messages are shared by the consumers of the group
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, client.Publish("testQueue", &broker.Message{Body: []byte{'0' + byte(i)}}), "should be no error")
+	}
+	received := make(map[string]bool)
+	for len(received) < 10 {
+		select {
+		case p := <-sub1.Chan():
+			assert.False(t, received[string(p.Message().Body)], "should be false")
+			received[string(p.Message().Body)] = true
+		case p := <-sub2.Chan():
+			assert.False(t, received[string(p.Message().Body)], "should be false")
+			received[string(p.Message().Body)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the publication")
+		}
+	}
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+
+	// the group keeps the messages while there is no consumer
+	assert.NoError(t, sub2.Unsubscribe(), "should be no error")
+	assert.NoError(t, client.Publish("testQueue", &broker.Message{Body: []byte("kept")}), "should be no error")
+	sub, err := client.Subscribe("testQueue", broker.Queue("queue"))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	assert.Equal(t, []byte("kept"), receive(t, sub).Message().Body, "should be equal")
+}
+
+func testClaim(t *testing.T, client broker.Client, endpoint string) {
+	sub1, err := client.Subscribe("testClaim", broker.Queue("queue"), broker.AutoAck(false), broker.AckTimeout(500*time.Millisecond))
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, client.Publish("testClaim", &broker.Message{Body: []byte("foo")}), "should be no error")
+	assert.Equal(t, []byte("foo"), receive(t, sub1).Message().Body, "should be equal")
+
+	// the consumer dies without acking the message, which is claimed by another one
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	sub2, err := client.Subscribe("testClaim", broker.Queue("queue"), broker.AutoAck(false), broker.AckTimeout(500*time.Millisecond))
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+	p := receive(t, sub2)
+	assert.Equal(t, []byte("foo"), p.Message().Body, "should be equal")
+	assert.NoError(t, p.Ack(), "should be no error")
+
+	select {
+	case p := <-sub2.Chan():
+		t.Fatalf("unexpected publication %v", p)
+	case <-time.After(time.Second):
+	}
+
+	// the dead consumer is deleted once it's idle without pending messages
+	c := redis.NewClient(&redis.Options{Addr: endpoint})
+	defer c.Close()
+	deleted := false
+	for i := 0; i < 50 && !deleted; i++ {
+		cmd := redis.NewSliceCmd("xinfo", "consumers", "testClaim", "queue")
+		assert.NoError(t, c.Process(cmd), "should be no error")
+		deleted = len(cmd.Val()) == 1
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, deleted, "should be true")
+}
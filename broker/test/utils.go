@@ -0,0 +1,185 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// receiveTimeout is long enough for the brokers joining the groups asynchronously, e.g., Kafka
+	receiveTimeout = 30 * time.Second
+	// publishInterval is the interval between the publications before they're received
+	publishInterval = 100 * time.Millisecond
+)
+
+// RunTestFanOut tests every subscription without a Queue receives the messages
+// published after it subscribes
+func RunTestFanOut(t *testing.T, client broker.Client) {
+	testFanOut(t, client)
+}
+
+// RunTestQueue tests the subscriptions of a Queue share the messages, which are
+// kept while there is no subscription
+func RunTestQueue(t *testing.T, client broker.Client) {
+	testQueue(t, client)
+}
+
+// RunTestRedelivery tests the unacked message of a Queue is redelivered to the
+// others after it's unsubscribed, and the acked one isn't
+func RunTestRedelivery(t *testing.T, client broker.Client) {
+	testRedelivery(t, client)
+}
+
+// receiveBody receives the publications until the one of the body, and the others
+// are acked and skipped, e.g., the redeliveries of the at-least-once brokers
+func receiveBody(t *testing.T, sub broker.Subscription, body string) broker.Publication {
+	timeout := time.After(receiveTimeout)
+	for {
+		select {
+		case p, ok := <-sub.Chan():
+			if !assert.True(t, ok, "should be true") {
+				t.FailNow()
+			}
+			if string(p.Message().Body) == body {
+				return p
+			}
+			assert.NoError(t, p.Ack(), "should be no error")
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", body)
+		}
+	}
+}
+
+func assertNoPublication(t *testing.T, sub broker.Subscription, wait time.Duration) {
+	select {
+	case p := <-sub.Chan():
+		t.Fatalf("unexpected publication %q", p.Message().Body)
+	case <-time.After(wait):
+	}
+}
+
+func testFanOut(t *testing.T, client broker.Client) {
+	topic := "test.fanout"
+	// the message published before the subscriptions is not received, and it
+	// creates the topic for the brokers which require it, e.g., Kafka
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("before")}), "should be no error")
+
+	sub1, err := client.Subscribe(topic)
+	assert.NoError(t, err, "should be no error")
+	defer sub1.Unsubscribe()
+	sub2, err := client.Subscribe(topic)
+	assert.NoError(t, err, "should be no error")
+	defer sub2.Unsubscribe()
+
+	// the subscriptions may start after Subscribe returns, so the message is
+	// published until they receive it
+	msg := &broker.Message{
+		Header: map[string]string{"foo": "bar"},
+		Body:   []byte("hello"),
+	}
+	for _, sub := range []broker.Subscription{sub1, sub2} {
+		var p broker.Publication
+		timeout := time.After(receiveTimeout)
+		for p == nil {
+			assert.NoError(t, client.Publish(topic, msg), "should be no error")
+			select {
+			case p = <-sub.Chan():
+			case <-time.After(publishInterval):
+			case <-timeout:
+				t.Fatal("timeout waiting for the publication")
+			}
+		}
+		assert.Equal(t, topic, p.Topic(), "should be equal")
+		assert.Equal(t, msg.Body, p.Message().Body, "should be equal")
+		assert.Equal(t, "bar", p.Message().Header["foo"], "should be equal")
+		assert.NoError(t, p.Ack(), "should be no error")
+	}
+}
+
+func testQueue(t *testing.T, client broker.Client) {
+	topic := "test.queue"
+	queue := "testQueue"
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("init")}), "should be no error")
+
+	sub1, err := client.Subscribe(topic, broker.Queue(queue))
+	assert.NoError(t, err, "should be no error")
+	sub2, err := client.Subscribe(topic, broker.Queue(queue))
+	assert.NoError(t, err, "should be no error")
+
+	// the messages are shared by the subscriptions, and each is received once
+	bodies := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		body := fmt.Sprintf("message %d", i)
+		bodies[body] = true
+		assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte(body)}), "should be no error")
+	}
+	received := make(map[string]bool)
+	timeout := time.After(receiveTimeout)
+	for len(received) < len(bodies) {
+		var p broker.Publication
+		select {
+		case p = <-sub1.Chan():
+		case p = <-sub2.Chan():
+		case <-timeout:
+			t.Fatal("timeout waiting for the publication")
+		}
+		body := string(p.Message().Body)
+		if !bodies[body] {
+			continue
+		}
+		assert.False(t, received[body], "should be false")
+		received[body] = true
+	}
+	assert.NoError(t, sub1.Unsubscribe(), "should be no error")
+	assert.NoError(t, sub2.Unsubscribe(), "should be no error")
+
+	// the messages are kept while there is no subscription
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("kept")}), "should be no error")
+	sub, err := client.Subscribe(topic, broker.Queue(queue))
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	assert.NoError(t, receiveBody(t, sub, "kept").Ack(), "should be no error")
+}
+
+func testRedelivery(t *testing.T, client broker.Client) {
+	topic := "test.redelivery"
+	opts := []broker.SubscribeOption{
+		broker.Queue("testRedelivery"),
+		broker.AutoAck(false),
+		broker.AckTimeout(time.Second),
+	}
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("init")}), "should be no error")
+
+	sub, err := client.Subscribe(topic, opts...)
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, client.Publish(topic, &broker.Message{Body: []byte("foo")}), "should be no error")
+	receiveBody(t, sub, "foo")
+
+	// the unacked message is redelivered to the others of the Queue
+	assert.NoError(t, sub.Unsubscribe(), "should be no error")
+	sub, err = client.Subscribe(topic, opts...)
+	assert.NoError(t, err, "should be no error")
+	defer sub.Unsubscribe()
+	assert.NoError(t, receiveBody(t, sub, "foo").Ack(), "should be no error")
+
+	// the acked message isn't redelivered after AckTimeout
+	assertNoPublication(t, sub, 2*time.Second)
+}
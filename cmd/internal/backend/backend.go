@@ -0,0 +1,114 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/etcd"
+	"github.com/getamis/sirius/kv/redis"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// ErrUnknownBackend is returned when the backend is neither redis nor etcd
+	ErrUnknownBackend = errors.New("unknown backend")
+	// ErrUnknownCodec is returned when the codec is not json, binary or hash
+	ErrUnknownCodec = errors.New("unknown codec")
+)
+
+// Flags are the flags to connect to a kv.Store backend
+type Flags struct {
+	Backend   string
+	Endpoints []string
+	Cluster   bool
+	Sentinel  string
+	Codec     string
+	Username  string
+	Password  string
+	Timeout   time.Duration
+
+	EventStream   int64
+	Notifications bool
+}
+
+// AddFlags adds the flags to the flag set
+func (f *Flags) AddFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&f.Backend, "backend", "redis", "Backend of the kv store, redis or etcd")
+	flags.StringSliceVar(&f.Endpoints, "endpoints", []string{"localhost:6379"}, "Endpoints of the backend")
+	flags.BoolVar(&f.Cluster, "cluster", false, "Connect to a redis cluster")
+	flags.StringVar(&f.Sentinel, "sentinel", "", "Master name of the redis sentinels")
+	flags.StringVar(&f.Codec, "codec", "json", "Codec of the redis values, json, binary or hash")
+	flags.StringVar(&f.Username, "username", "", "Username of etcd")
+	flags.StringVar(&f.Password, "password", "", "Password of the backend")
+	flags.DurationVar(&f.Timeout, "timeout", 5*time.Second, "Dial timeout of the backend")
+	flags.Int64Var(&f.EventStream, "event-stream", 0, "Max length of the redis event stream set by the EventStream option of the clients, which the watches read if it's not 0")
+	flags.BoolVar(&f.Notifications, "enable-notifications", false, "Enable the keyspace notifications on every redis master by CONFIG SET, which the watches need without --event-stream")
+}
+
+// Open connects to the backend. The config of redis is left alone unless
+// the keyspace notifications are asked to be enabled.
+func (f *Flags) Open() (kv.Store, error) {
+	switch f.Backend {
+	case "redis":
+		codec, err := Codec(f.Codec)
+		if err != nil {
+			return nil, err
+		}
+		options := []redis.RedisOption{
+			redis.ValueCodec(codec),
+			redis.DialTimeout(f.Timeout),
+		}
+		if f.Cluster {
+			options = append(options, redis.Cluster())
+		}
+		if f.Sentinel != "" {
+			options = append(options, redis.Sentinel(f.Sentinel))
+		}
+		if f.Password != "" {
+			options = append(options, redis.Password(f.Password))
+		}
+		if f.EventStream > 0 {
+			options = append(options, redis.EventStream(f.EventStream, 0))
+		}
+		return redis.New(f.Endpoints, f.Notifications, options...)
+
+	case "etcd":
+		options := []etcd.EtcdOption{
+			etcd.DialTimeout(f.Timeout),
+		}
+		if f.Username != "" {
+			options = append(options, etcd.Username(f.Username), etcd.Password(f.Password))
+		}
+		return etcd.New(f.Endpoints, options...)
+	}
+	return nil, fmt.Errorf("%v: %s", ErrUnknownBackend, f.Backend)
+}
+
+// Codec returns the redis codec of the name
+func Codec(name string) (redis.Codec, error) {
+	switch name {
+	case "json":
+		return redis.JSONCodec, nil
+	case "binary":
+		return redis.BinaryCodec, nil
+	case "hash":
+		return redis.HashCodec, nil
+	}
+	return nil, fmt.Errorf("%v: %s", ErrUnknownCodec, name)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var deleteConfirmed bool
+
+// DeleteTreeCmd represents the delete-tree command
+var DeleteTreeCmd = &cobra.Command{
+	Use:   "delete-tree <prefix>",
+	Short: "delete-tree deletes the keys with the prefix",
+	Long: `delete-tree deletes the keys with the prefix.
+Only the number of the keys to delete is printed unless --yes is given.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		pairs, err := store.List(args[0])
+		if err != nil {
+			return err
+		}
+		if !deleteConfirmed {
+			fmt.Printf("%d keys would be deleted, run with --yes to delete them\n", len(pairs))
+			return nil
+		}
+		if err := store.DeleteTree(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("%d keys deleted\n", len(pairs))
+		return nil
+	},
+}
+
+func init() {
+	DeleteTreeCmd.Flags().BoolVar(&deleteConfirmed, "yes", false, "Delete the keys")
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// GetCmd represents the get command
+var GetCmd = &cobra.Command{
+	Use:          "get <key>",
+	Short:        "get prints the value, the revision and the TTL of the key",
+	Long:         `get prints the value, the revision and the TTL of the key`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		pair, err := store.Get(args[0])
+		if err != nil {
+			return err
+		}
+		ttl, err := remainingTTL(store, args[0])
+		if err != nil {
+			return err
+		}
+		return printPair(os.Stdout, pair, ttl)
+	},
+}
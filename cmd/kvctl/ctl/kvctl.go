@@ -0,0 +1,122 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/getamis/sirius/cmd/internal/backend"
+	"github.com/getamis/sirius/kv"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backendFlags backend.Flags
+	jsonOutput   bool
+)
+
+var KVCtlCmd = &cobra.Command{
+	Use:   "kvctl",
+	Short: "kvctl inspects the keys of a kv store",
+	Long: `kvctl gets, puts, lists and watches the keys of a kv store, and inspects the locks and the TTLs.
+The redis values are decoded by the codec given by --codec, the same as the library does.`,
+}
+
+func init() {
+	KVCtlCmd.AddCommand(GetCmd)
+	KVCtlCmd.AddCommand(PutCmd)
+	KVCtlCmd.AddCommand(ListCmd)
+	KVCtlCmd.AddCommand(WatchCmd)
+	KVCtlCmd.AddCommand(DeleteTreeCmd)
+	KVCtlCmd.AddCommand(LockCmd)
+	KVCtlCmd.AddCommand(TTLCmd)
+
+	// add persistent flags for all subcommands
+	backendFlags.AddFlags(KVCtlCmd.PersistentFlags())
+	KVCtlCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print the keys in JSON, where the values are base64 encoded")
+}
+
+// pairOutput is a key printed by the commands
+type pairOutput struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value,omitempty"`
+	Revision uint64 `json:"revision"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+// printPair prints the key, and the TTL if it's not nil
+func printPair(w io.Writer, pair *kv.KeyValue, ttl *time.Duration) error {
+	out := &pairOutput{
+		Key:      pair.Key,
+		Value:    pair.Value,
+		Revision: pair.Revision,
+	}
+	if ttl != nil {
+		out.TTL = formatTTL(*ttl)
+	}
+
+	if jsonOutput {
+		return json.NewEncoder(w).Encode(out)
+	}
+	_, err := fmt.Fprintf(w, "%s\trevision=%d", out.Key, out.Revision)
+	if err != nil {
+		return err
+	}
+	if out.TTL != "" {
+		if _, err := fmt.Fprintf(w, "\tttl=%s", out.TTL); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "\t%s\n", formatValue(out.Value))
+	return err
+}
+
+// formatValue prints the value as it is if it's a printable UTF-8 string, otherwise it's quoted
+func formatValue(value []byte) string {
+	if !utf8.Valid(value) {
+		return strconv.Quote(string(value))
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(string(value))
+		}
+	}
+	return string(value)
+}
+
+func formatTTL(ttl time.Duration) string {
+	if ttl == 0 {
+		return "none"
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+// remainingTTL returns the TTL of the key, and nil if the backend doesn't report it
+func remainingTTL(store kv.Store, key string) (*time.Duration, error) {
+	ttl, err := kv.TTL(store, key)
+	if err == kv.ErrNotSupported {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ttl, nil
+}
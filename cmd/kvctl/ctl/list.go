@@ -0,0 +1,73 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"os"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/spf13/cobra"
+)
+
+var listTTL bool
+
+// ListCmd represents the list command
+var ListCmd = &cobra.Command{
+	Use:          "list <prefix>",
+	Short:        "list prints the keys with the prefix",
+	Long:         `list prints the keys with the prefix page by page, and their TTLs if --ttl is given`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		cursor := ""
+		for {
+			pairs, next, err := store.ListPage(args[0], kv.ListCursor(cursor))
+			if err != nil {
+				return err
+			}
+			for _, pair := range pairs {
+				var ttl *time.Duration
+				if listTTL {
+					ttl, err = remainingTTL(store, pair.Key)
+					if err == kv.ErrKeyNotFound {
+						// expired after it's listed
+						continue
+					}
+					if err != nil {
+						return err
+					}
+				}
+				if err := printPair(os.Stdout, pair, ttl); err != nil {
+					return err
+				}
+			}
+			if next == "" {
+				return nil
+			}
+			cursor = next
+		}
+	},
+}
+
+func init() {
+	ListCmd.Flags().BoolVar(&listTTL, "ttl", false, "Print the TTLs of the keys")
+}
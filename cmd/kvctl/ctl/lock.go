@@ -0,0 +1,120 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/spf13/cobra"
+)
+
+// lockOutput is the state of a lock
+type lockOutput struct {
+	Key      string `json:"key"`
+	Held     bool   `json:"held"`
+	Owner    string `json:"owner,omitempty"`
+	Token    uint64 `json:"token,omitempty"`
+	Holders  int    `json:"holders,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+// lockValue is the value of a kv/redis lock, which keeps the owner, the fencing token
+// and the number of the reentrant holders along with the value given by the holder
+type lockValue struct {
+	Owner   string `json:"owner"`
+	Token   uint64 `json:"token"`
+	Holders int    `json:"holders"`
+	Value   []byte `json:"value"`
+}
+
+// setValue decodes the value of the lock, which is the value given by the holder
+// unless it's a kv/redis lock
+func (o *lockOutput) setValue(pair *kv.KeyValue) {
+	v := &lockValue{}
+	if err := json.Unmarshal(pair.Value, v); err != nil || v.Owner == "" {
+		o.Value = formatValue(pair.Value)
+		return
+	}
+	o.Owner = v.Owner
+	// the token is written by the first change after the creation
+	o.Token = v.Token
+	if o.Token == 0 {
+		o.Token = pair.Revision
+	}
+	o.Holders = v.Holders
+	o.Value = formatValue(v.Value)
+}
+
+// LockCmd represents the lock command
+var LockCmd = &cobra.Command{
+	Use:   "lock <key>",
+	Short: "lock prints whether the lock of the key is held",
+	Long: `lock prints whether the lock of the key is held, and the value given by the holder,
+the revision of the key and the TTL before the lock expires if it's not renewed.
+The owner, the fencing token and the number of the holders are printed for the redis locks.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		out := &lockOutput{Key: args[0]}
+		pair, err := store.Get(args[0])
+		switch err {
+		case nil:
+			out.Held = true
+			out.setValue(pair)
+			out.Revision = pair.Revision
+			ttl, err := remainingTTL(store, args[0])
+			if err != nil && err != kv.ErrKeyNotFound {
+				return err
+			}
+			if err == kv.ErrKeyNotFound {
+				// released after it's read
+				out = &lockOutput{Key: args[0]}
+			} else if ttl != nil {
+				out.TTL = formatTTL(*ttl)
+			}
+		case kv.ErrKeyNotFound:
+		default:
+			return err
+		}
+
+		if jsonOutput {
+			return json.NewEncoder(os.Stdout).Encode(out)
+		}
+		if !out.Held {
+			fmt.Printf("%s\tnot held\n", out.Key)
+			return nil
+		}
+		fmt.Printf("%s\theld\trevision=%d", out.Key, out.Revision)
+		if out.TTL != "" {
+			fmt.Printf("\tttl=%s", out.TTL)
+		}
+		if out.Owner != "" {
+			fmt.Printf("\towner=%s\ttoken=%d\tholders=%d", out.Owner, out.Token, out.Holders)
+		}
+		fmt.Printf("\t%s\n", out.Value)
+		return nil
+	},
+}
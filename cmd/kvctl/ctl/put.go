@@ -0,0 +1,50 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/spf13/cobra"
+)
+
+var putTTL time.Duration
+
+// PutCmd represents the put command
+var PutCmd = &cobra.Command{
+	Use:          "put <key> <value>",
+	Short:        "put puts the value with the key",
+	Long:         `put puts the value with the key, which expires after --ttl if it's given`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		var opts []kv.PutOption
+		if putTTL > 0 {
+			opts = append(opts, kv.PutExpiration(putTTL))
+		}
+		return store.Put(args[0], []byte(args[1]), opts...)
+	},
+}
+
+func init() {
+	PutCmd.Flags().DurationVar(&putTTL, "ttl", 0, "TTL of the key, 0 for no expiration")
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"fmt"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/spf13/cobra"
+)
+
+// TTLCmd represents the ttl command
+var TTLCmd = &cobra.Command{
+	Use:          "ttl <key>",
+	Short:        "ttl prints the remaining TTL of the key",
+	Long:         `ttl prints the remaining TTL of the key, or none if the key doesn't expire`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		ttl, err := kv.TTL(store, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(formatTTL(ttl))
+		return nil
+	},
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var watchTree bool
+
+// WatchCmd represents the watch command
+var WatchCmd = &cobra.Command{
+	Use:   "watch <key>",
+	Short: "watch prints the changes of the key until it's interrupted",
+	Long: `watch prints the changes of the key until it's interrupted.
+The keys with the prefix are printed on every change of them if --tree is given.
+The redis watches read the event stream if --event-stream is given, otherwise they need the
+keyspace notifications, which are only enabled by --enable-notifications.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		stopCh := make(chan struct{})
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+		go func() {
+			<-sigCh
+			close(stopCh)
+		}()
+
+		if !watchTree {
+			changes, err := store.Watch(args[0], stopCh)
+			if err != nil {
+				return err
+			}
+			for pair := range changes {
+				if err := printPair(os.Stdout, pair, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		changes, err := store.WatchTree(args[0], stopCh)
+		if err != nil {
+			return err
+		}
+		for pairs := range changes {
+			if !jsonOutput {
+				fmt.Printf("--- %d keys\n", len(pairs))
+			}
+			for _, pair := range pairs {
+				if err := printPair(os.Stdout, pair, nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	WatchCmd.Flags().BoolVar(&watchTree, "tree", false, "Watch the keys with the prefix")
+}
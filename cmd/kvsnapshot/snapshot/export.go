@@ -0,0 +1,72 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	kvsnapshot "github.com/getamis/sirius/kv/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var prefix string
+
+// ExportCmd represents the export command
+var ExportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "export writes the keys under the prefix into the snapshot file",
+	Long:         `export writes the keys under the prefix with their values, revisions and remaining TTLs into the snapshot file`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		var out io.Writer = os.Stdout
+		if file != "-" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+
+		count, err := kvsnapshot.Export(store, prefix, w)
+		if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		// the snapshot may be written to stdout
+		fmt.Fprintf(os.Stderr, "exported %d keys\n", count)
+		return nil
+	},
+}
+
+func init() {
+	ExportCmd.Flags().StringVar(&prefix, "prefix", "", "Prefix of the keys to export")
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	kvsnapshot "github.com/getamis/sirius/kv/snapshot"
+	"github.com/spf13/cobra"
+)
+
+// ErrUnknownPolicy is returned when the policy is not skip, overwrite or cas
+var ErrUnknownPolicy = errors.New("unknown policy")
+
+var (
+	policy            string
+	dryRun            bool
+	resume            int
+	ignoreUnknownTTLs bool
+)
+
+var policies = map[string]kvsnapshot.Policy{
+	"skip":      kvsnapshot.Skip,
+	"overwrite": kvsnapshot.Overwrite,
+	"cas":       kvsnapshot.CAS,
+}
+
+// ImportCmd represents the import command
+var ImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import loads the snapshot file into the kv store",
+	Long: `import loads the snapshot file into the kv store.
+The existing keys are kept, overwritten, or only overwritten if they're still at the exported
+revisions, by the skip, overwrite and cas policies. A failed import is able to be resumed by
+the --resume flag with the number of the processed records. A snapshot exported from a store
+which doesn't report the TTLs is refused unless --ignore-unknown-ttls is set.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, ok := policies[policy]
+		if !ok {
+			return fmt.Errorf("%v: %s", ErrUnknownPolicy, policy)
+		}
+		opts := []kvsnapshot.ImportOption{
+			kvsnapshot.ConflictPolicy(p),
+			kvsnapshot.ResumeFrom(resume),
+		}
+		if dryRun {
+			opts = append(opts, kvsnapshot.DryRun())
+		}
+		if ignoreUnknownTTLs {
+			opts = append(opts, kvsnapshot.IgnoreUnknownTTLs())
+		}
+
+		store, err := backendFlags.Open()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		var in io.Reader = os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		result, err := kvsnapshot.Import(store, bufio.NewReader(in), opts...)
+		if result != nil {
+			fmt.Printf("processed: %d, imported: %d, skipped: %d, conflicts: %d, expired: %d\n",
+				result.Processed, result.Imported, result.Skipped, result.Conflicts, result.Expired)
+		}
+		if err != nil {
+			if result != nil {
+				fmt.Printf("resume the import by --resume=%d\n", result.Processed)
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	ImportCmd.Flags().StringVar(&policy, "policy", "skip", "Policy of the existing keys, skip, overwrite or cas")
+	ImportCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be imported without writing the kv store")
+	ImportCmd.Flags().IntVar(&resume, "resume", 0, "Skip the records processed by a previous import")
+	ImportCmd.Flags().BoolVar(&ignoreUnknownTTLs, "ignore-unknown-ttls", false, "Import the keys without TTLs if the TTLs of the snapshot are unknown")
+}
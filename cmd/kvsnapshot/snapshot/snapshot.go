@@ -0,0 +1,41 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"github.com/getamis/sirius/cmd/internal/backend"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backendFlags backend.Flags
+	file         string
+)
+
+var SnapshotCmd = &cobra.Command{
+	Use:   "kvsnapshot",
+	Short: "kvsnapshot exports and imports the keys of a kv store",
+	Long: `kvsnapshot exports the keys under a prefix of a kv store into a snapshot file, and imports
+the snapshot into another kv store, which may be of another backend`,
+}
+
+func init() {
+	SnapshotCmd.AddCommand(ExportCmd)
+	SnapshotCmd.AddCommand(ImportCmd)
+
+	// add persistent flags for all subcommands
+	backendFlags.AddFlags(SnapshotCmd.PersistentFlags())
+	SnapshotCmd.PersistentFlags().StringVar(&file, "file", "-", "Snapshot file, - for stdin or stdout")
+}
@@ -0,0 +1,539 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/metrics"
+)
+
+const defaultSize = 1000
+
+// Cache is a kv.Store which keeps a bounded LRU cache of the results of Get and List.
+// The cached results are invalidated by a single watch of the whole underlying store,
+// by the writes through the cache, or by the TTL of a key put through the cache.
+// The watch is WatchEvents, or WatchTree if the events are not supported, which
+// delivers every key of the store on each change, so it's costly on a large store.
+// Nothing is cached while the store can't be watched.
+type Cache struct {
+	kv.Store
+
+	size        int
+	registry    metrics.Registry
+	metricsOpts []metrics.Option
+
+	hits      metrics.Counter
+	misses    metrics.Counter
+	evictions metrics.Counter
+
+	// watchMu serializes the subscriptions to the changes of the store
+	watchMu sync.Mutex
+
+	mu       sync.Mutex
+	lru      *list.List
+	entries  map[string]*entry
+	loads    map[*load]struct{}
+	expiries map[string]time.Time
+	queue    expiryQueue
+	stopCh   chan struct{}
+	closed   bool
+}
+
+// entry is a cached result of Get or List
+type entry struct {
+	id       string
+	key      string
+	isList   bool
+	pair     *kv.KeyValue
+	pairs    []*kv.KeyValue
+	expireAt time.Time
+
+	elem *list.Element
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// load is a Get or List in flight, whose result is not cached if the key is changed meanwhile
+type load struct {
+	key    string
+	isList bool
+	stale  bool
+}
+
+// expiry is the expiration of a key put through the cache
+type expiry struct {
+	key string
+	at  time.Time
+}
+
+// expiryQueue is a min-heap of the expirations
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiry)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// New wraps the store with a read-through cache
+func New(store kv.Store, options ...Option) *Cache {
+	c := &Cache{
+		Store:    store,
+		size:     defaultSize,
+		registry: metrics.DefaultRegistry,
+		lru:      list.New(),
+		entries:  make(map[string]*entry),
+		loads:    make(map[*load]struct{}),
+		expiries: make(map[string]time.Time),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.size <= 0 {
+		c.size = defaultSize
+	}
+
+	c.hits = c.registry.NewCounter("kv_cache_hits", c.metricsOpts...)
+	c.misses = c.registry.NewCounter("kv_cache_misses", c.metricsOpts...)
+	c.evictions = c.registry.NewCounter("kv_cache_evictions", c.metricsOpts...)
+	return c
+}
+
+// Get a value with given key, which is cached until it's changed
+func (c *Cache) Get(key string) (*kv.KeyValue, error) {
+	nKey := kv.Normalize(key)
+	id := getID(nKey)
+	if e := c.lookup(id); e != nil {
+		c.hits.Inc()
+		return copyKeyValue(e.pair), nil
+	}
+	c.misses.Inc()
+
+	// the load starts after the watch, so no change after the get is missed
+	l := c.startLoad(nKey, false)
+	if l == nil {
+		return c.Store.Get(key)
+	}
+	pair, err := c.Store.Get(key)
+	if err != nil {
+		c.endLoad(l)
+		return nil, err
+	}
+
+	c.add(l, &entry{
+		id:   id,
+		key:  nKey,
+		pair: pair,
+	})
+	return copyKeyValue(pair), nil
+}
+
+// List the content with given prefix, which is cached until any child is changed
+func (c *Cache) List(prefix string) ([]*kv.KeyValue, error) {
+	nKey := kv.Normalize(prefix)
+	id := listID(nKey)
+	if e := c.lookup(id); e != nil {
+		c.hits.Inc()
+		return copyKeyValues(e.pairs), nil
+	}
+	c.misses.Inc()
+
+	l := c.startLoad(nKey, true)
+	if l == nil {
+		return c.Store.List(prefix)
+	}
+	pairs, err := c.Store.List(prefix)
+	if err != nil {
+		c.endLoad(l)
+		return nil, err
+	}
+
+	c.add(l, &entry{
+		id:     id,
+		key:    nKey,
+		isList: true,
+		pairs:  pairs,
+	})
+	return copyKeyValues(pairs), nil
+}
+
+// Put a value with the specified key, and the cached result expires with the TTL
+func (c *Cache) Put(key string, value []byte, opts ...kv.PutOption) error {
+	o := &kv.PutOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	defer c.invalidate(key, o.TTL)
+	return c.Store.Put(key, value, opts...)
+}
+
+func (c *Cache) AtomicPut(key string, value []byte, expected *kv.KeyValue, opts ...kv.PutOption) (*kv.KeyValue, error) {
+	o := &kv.PutOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	defer c.invalidate(key, o.TTL)
+	return c.Store.AtomicPut(key, value, expected, opts...)
+}
+
+func (c *Cache) Delete(key string) error {
+	defer c.invalidate(key, 0)
+	return c.Store.Delete(key)
+}
+
+func (c *Cache) AtomicDelete(key string, expected *kv.KeyValue) (bool, error) {
+	defer c.invalidate(key, 0)
+	return c.Store.AtomicDelete(key, expected)
+}
+
+func (c *Cache) DeleteTree(prefix string) error {
+	defer c.invalidateTree(prefix)
+	return c.Store.DeleteTree(prefix)
+}
+
+func (c *Cache) Txn(compares []kv.Compare, ops []kv.Op) ([]*kv.KeyValue, error) {
+	defer func() {
+		for _, op := range ops {
+			c.invalidate(op.Key, op.TTL)
+		}
+	}()
+	return c.Store.Txn(compares, ops)
+}
+
+// Semaphore creates a semaphore of the key if the backend supports it
+func (c *Cache) Semaphore(key string, limit int, opts ...kv.SemaphoreOption) (kv.Semaphore, error) {
+	return kv.NewSemaphore(c.Store, key, limit, opts...)
+}
+
+// RateLimiter creates a rate limiter of the key if the backend supports it
+func (c *Cache) RateLimiter(key string, rate kv.RateLimit) (kv.RateLimiter, error) {
+	return kv.NewRateLimiter(c.Store, key, rate)
+}
+
+// TTL returns the remaining TTL of the key if the backend supports it
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	return kv.TTL(c.Store, key)
+}
+
+// TTLs returns the remaining TTLs of the keys if the backend supports it
+func (c *Cache) TTLs(keys []string) (map[string]time.Duration, error) {
+	return kv.TTLs(c.Store, keys)
+}
+
+// Close stops the watch of the store and closes the store
+func (c *Cache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.unwatch()
+	c.mu.Unlock()
+
+	c.Store.Close()
+}
+
+// lookup returns the cached entry which is not expired
+func (c *Cache) lookup(id string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	if e.expired(time.Now()) {
+		c.remove(e)
+		return nil
+	}
+	c.lru.MoveToFront(e.elem)
+	return e
+}
+
+// startLoad watches the store and starts a load of the key.
+// It returns nil if the store can't be watched, so the result can't be cached.
+func (c *Cache) startLoad(nKey string, isList bool) *load {
+	if !c.watch() {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the watch may be stopped meanwhile
+	if c.stopCh == nil {
+		return nil
+	}
+	l := &load{
+		key:    nKey,
+		isList: isList,
+	}
+	c.loads[l] = struct{}{}
+	return l
+}
+
+// endLoad ends the load without caching its result
+func (c *Cache) endLoad(l *load) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.loads, l)
+}
+
+// add ends the load and caches its entry unless the key is changed meanwhile,
+// and evicts the least recently used entries
+func (c *Cache) add(l *load, e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.loads, l)
+	if l.stale {
+		return
+	}
+	// keep the expiration of the key put through the cache
+	if !e.isList {
+		e.expireAt = c.expiries[e.key]
+	}
+	if old, ok := c.entries[e.id]; ok {
+		c.remove(old)
+	}
+	c.entries[e.id] = e
+	e.elem = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*entry))
+		c.evictions.Inc()
+	}
+}
+
+// remove drops the entry, the caller must hold the lock
+func (c *Cache) remove(e *entry) {
+	if c.entries[e.id] == e {
+		delete(c.entries, e.id)
+		c.lru.Remove(e.elem)
+	}
+}
+
+// invalidate drops the results of the key and of the lists containing it.
+// The TTL is kept to expire the result which is cached later.
+func (c *Cache) invalidate(key string, ttl time.Duration) {
+	nKey := kv.Normalize(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.changed(nKey)
+	c.expire(nKey, ttl)
+}
+
+// invalidateTree drops the results under the prefix and of the lists containing it
+func (c *Cache) invalidateTree(prefix string) {
+	nKey := kv.Normalize(prefix)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.entries {
+		if strings.HasPrefix(e.key, nKey) || (e.isList && strings.HasPrefix(nKey, e.key)) {
+			c.remove(e)
+		}
+	}
+	for l := range c.loads {
+		if strings.HasPrefix(l.key, nKey) || (l.isList && strings.HasPrefix(nKey, l.key)) {
+			l.stale = true
+		}
+	}
+	for key := range c.expiries {
+		if strings.HasPrefix(key, nKey) {
+			delete(c.expiries, key)
+		}
+	}
+}
+
+// changed drops the results of the key and of the lists containing it, and
+// keeps the loads in flight from caching them, the caller must hold the lock
+func (c *Cache) changed(nKey string) {
+	if e, ok := c.entries[getID(nKey)]; ok {
+		c.remove(e)
+	}
+	for _, e := range c.entries {
+		if e.isList && strings.HasPrefix(nKey, e.key) {
+			c.remove(e)
+		}
+	}
+	for l := range c.loads {
+		if l.key == nKey || (l.isList && strings.HasPrefix(nKey, l.key)) {
+			l.stale = true
+		}
+	}
+}
+
+// expire keeps the expiration of the key apart from the cached results, and
+// forgets the expirations which are passed, the caller must hold the lock
+func (c *Cache) expire(nKey string, ttl time.Duration) {
+	now := time.Now()
+	for c.queue.Len() > 0 && !now.Before(c.queue[0].at) {
+		x := heap.Pop(&c.queue).(expiry)
+		if c.expiries[x.key].Equal(x.at) {
+			delete(c.expiries, x.key)
+		}
+	}
+
+	if ttl <= 0 {
+		delete(c.expiries, nKey)
+		return
+	}
+	at := now.Add(ttl)
+	c.expiries[nKey] = at
+	heap.Push(&c.queue, expiry{key: nKey, at: at})
+}
+
+// watch subscribes to the changes of the whole store unless it's subscribed.
+// It returns false if the store can't be watched.
+func (c *Cache) watch() bool {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	c.mu.Lock()
+	watching, closed := c.stopCh != nil, c.closed
+	c.mu.Unlock()
+	if closed {
+		return false
+	}
+	if watching {
+		return true
+	}
+
+	stopCh := make(chan struct{})
+	var follow func()
+	events, err := c.Store.WatchEvents("", stopCh)
+	if err == nil {
+		follow = func() {
+			for ev := range events {
+				c.mu.Lock()
+				c.changed(kv.Normalize(ev.KV.Key))
+				c.mu.Unlock()
+			}
+		}
+	} else {
+		// the events may be unavailable in the store, e.g. kv/redis without the event stream
+		changes, err := c.Store.WatchTree("", stopCh)
+		if err != nil {
+			close(stopCh)
+			return false
+		}
+		// the first list is the base of the changes
+		pairs, ok := <-changes
+		if !ok {
+			close(stopCh)
+			return false
+		}
+		follow = func() {
+			revisions := revisionsOf(pairs)
+			for pairs := range changes {
+				next := revisionsOf(pairs)
+				c.mu.Lock()
+				for key, rev := range next {
+					if old, ok := revisions[key]; !ok || old != rev {
+						c.changed(key)
+					}
+				}
+				for key := range revisions {
+					if _, ok := next[key]; !ok {
+						c.changed(key)
+					}
+				}
+				c.mu.Unlock()
+				revisions = next
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(stopCh)
+		return false
+	}
+	c.stopCh = stopCh
+	go func() {
+		follow()
+
+		// the cached results are not followed anymore once the watch ends
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.stopCh == stopCh {
+			c.unwatch()
+		}
+	}()
+	return true
+}
+
+// unwatch stops the watch and drops the cached results and the loads in flight,
+// the caller must hold the lock
+func (c *Cache) unwatch() {
+	if c.stopCh != nil {
+		close(c.stopCh)
+		c.stopCh = nil
+	}
+	c.entries = make(map[string]*entry)
+	c.lru.Init()
+	for l := range c.loads {
+		l.stale = true
+	}
+}
+
+func revisionsOf(pairs []*kv.KeyValue) map[string]uint64 {
+	revisions := make(map[string]uint64, len(pairs))
+	for _, pair := range pairs {
+		revisions[kv.Normalize(pair.Key)] = pair.Revision
+	}
+	return revisions
+}
+
+func getID(nKey string) string {
+	return "get:" + nKey
+}
+
+func listID(nKey string) string {
+	return "list:" + nKey
+}
+
+func copyKeyValue(pair *kv.KeyValue) *kv.KeyValue {
+	c := *pair
+	if pair.Value != nil {
+		c.Value = append([]byte(nil), pair.Value...)
+	}
+	return &c
+}
+
+func copyKeyValues(pairs []*kv.KeyValue) []*kv.KeyValue {
+	copied := make([]*kv.KeyValue, len(pairs))
+	for i, pair := range pairs {
+		copied[i] = copyKeyValue(pair)
+	}
+	return copied
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheGet(t *testing.T) {
+	store := kv.New()
+	registry := metrics.NewPrometheusRegistry()
+	cache := New(store, Registry(registry))
+	defer cache.Close()
+
+	key := "testCacheGet/key"
+	_, err := cache.Get(key)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	assert.NoError(t, store.Put(key, []byte("foo")), "should be no error")
+	pair, err := cache.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("foo"), pair.Value, "should be equal")
+
+	// the returned value is a copy
+	pair.Value[0] = 'b'
+	pair, err = cache.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("foo"), pair.Value, "should be equal")
+	assert.Equal(t, 1.0, counterValue(t, registry, "kv_cache_hits"), "should be equal")
+	assert.Equal(t, 2.0, counterValue(t, registry, "kv_cache_misses"), "should be equal")
+
+	// a put through the cache invalidates the result
+	assert.NoError(t, cache.Put(key, []byte("bar")), "should be no error")
+	pair, err = cache.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("bar"), pair.Value, "should be equal")
+
+	// a put of another client is notified by the watch
+	assert.NoError(t, store.Put(key, []byte("baz")), "should be no error")
+	assert.True(t, eventually(func() bool {
+		pair, err := cache.Get(key)
+		return err == nil && string(pair.Value) == "baz"
+	}), "should be true")
+
+	// so is a delete
+	assert.NoError(t, store.Delete(key), "should be no error")
+	assert.True(t, eventually(func() bool {
+		_, err := cache.Get(key)
+		return err == kv.ErrKeyNotFound
+	}), "should be true")
+}
+
+// noEventsStore fails WatchEvents like kv/redis without the event stream
+type noEventsStore struct {
+	kv.Store
+}
+
+func (s *noEventsStore) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	return nil, errors.New("event stream is disabled")
+}
+
+func TestCacheGetWithoutEvents(t *testing.T) {
+	store := &noEventsStore{Store: kv.New()}
+	registry := metrics.NewPrometheusRegistry()
+	cache := New(store, Registry(registry))
+	defer cache.Close()
+
+	key := "testCacheGetWithoutEvents/key"
+	assert.NoError(t, store.Put(key, []byte("foo")), "should be no error")
+	for i := 0; i < 3; i++ {
+		pair, err := cache.Get(key)
+		assert.NoError(t, err, "should be no error")
+		assert.Equal(t, []byte("foo"), pair.Value, "should be equal")
+	}
+	assert.Equal(t, 2.0, counterValue(t, registry, "kv_cache_hits"), "should be equal")
+	assert.Equal(t, 1.0, counterValue(t, registry, "kv_cache_misses"), "should be equal")
+
+	// the changes are notified by Watch
+	assert.NoError(t, store.Put(key, []byte("bar")), "should be no error")
+	assert.True(t, eventually(func() bool {
+		pair, err := cache.Get(key)
+		return err == nil && string(pair.Value) == "bar"
+	}), "should be true")
+}
+
+func TestCacheList(t *testing.T) {
+	store := kv.New()
+	cache := New(store)
+	defer cache.Close()
+
+	dir := "testCacheList"
+	assert.NoError(t, store.Put(dir+"/a", []byte("a")), "should be no error")
+	pairs, err := cache.List(dir)
+	assert.NoError(t, err, "should be no error")
+	assert.Len(t, pairs, 1, "should be 1")
+
+	// a put through the cache invalidates the lists containing the key
+	assert.NoError(t, cache.Put(dir+"/b", []byte("b")), "should be no error")
+	pairs, err = cache.List(dir)
+	assert.NoError(t, err, "should be no error")
+	assert.Len(t, pairs, 2, "should be 2")
+
+	// a change of another client is notified by the watch
+	assert.NoError(t, store.Delete(dir+"/a"), "should be no error")
+	assert.True(t, eventually(func() bool {
+		pairs, err := cache.List(dir)
+		return err == nil && len(pairs) == 1
+	}), "should be true")
+
+	assert.NoError(t, cache.DeleteTree(dir), "should be no error")
+	_, err = cache.List(dir)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+}
+
+func TestCacheExpiration(t *testing.T) {
+	store := kv.New()
+	cache := New(store)
+	defer cache.Close()
+
+	key := "testCacheExpiration/key"
+	assert.NoError(t, cache.Put(key, []byte("foo"), kv.PutExpiration(200*time.Millisecond)), "should be no error")
+	pair, err := cache.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("foo"), pair.Value, "should be equal")
+
+	time.Sleep(300 * time.Millisecond)
+	_, err = cache.Get(key)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+}
+
+func TestCacheEviction(t *testing.T) {
+	store := kv.New()
+	registry := metrics.NewPrometheusRegistry()
+	cache := New(store, Size(2), Registry(registry))
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Put("testCacheEviction/"+key, []byte(key)), "should be no error")
+	}
+	for _, key := range []string{"a", "b", "a", "c"} {
+		_, err := cache.Get("testCacheEviction/" + key)
+		assert.NoError(t, err, "should be no error")
+	}
+	// b is the least recently used one
+	assert.Equal(t, 1.0, counterValue(t, registry, "kv_cache_evictions"), "should be equal")
+	_, err := cache.Get("testCacheEviction/a")
+	assert.NoError(t, err, "should be no error")
+	_, err = cache.Get("testCacheEviction/b")
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 2.0, counterValue(t, registry, "kv_cache_hits"), "should be equal")
+	assert.Equal(t, 4.0, counterValue(t, registry, "kv_cache_misses"), "should be equal")
+}
+
+func TestCacheExpirationsKeptApart(t *testing.T) {
+	store := kv.New()
+	registry := metrics.NewPrometheusRegistry()
+	cache := New(store, Size(2), Registry(registry))
+	defer cache.Close()
+
+	for _, key := range []string{"a", "b"} {
+		assert.NoError(t, store.Put("testCacheExpirationsKeptApart/"+key, []byte(key)), "should be no error")
+		_, err := cache.Get("testCacheExpirationsKeptApart/" + key)
+		assert.NoError(t, err, "should be no error")
+	}
+
+	// the expirations of the puts don't evict the cached results
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("testCacheExpirationsKeptApart/ttl/%d", i)
+		assert.NoError(t, cache.Put(key, []byte("ttl"), kv.PutExpiration(time.Minute)), "should be no error")
+	}
+	for _, key := range []string{"a", "b"} {
+		_, err := cache.Get("testCacheExpirationsKeptApart/" + key)
+		assert.NoError(t, err, "should be no error")
+	}
+	assert.Equal(t, 2.0, counterValue(t, registry, "kv_cache_hits"), "should be equal")
+	assert.Equal(t, 0.0, counterValue(t, registry, "kv_cache_evictions"), "should be equal")
+}
+
+// countingStore counts the watches of the store
+type countingStore struct {
+	kv.Store
+	watches int32
+}
+
+func (s *countingStore) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	atomic.AddInt32(&s.watches, 1)
+	return s.Store.WatchEvents(prefix, stopCh, opts...)
+}
+
+func TestCacheSharedWatch(t *testing.T) {
+	store := &countingStore{Store: kv.New()}
+	cache := New(store)
+	defer cache.Close()
+
+	dir := "testCacheSharedWatch"
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Put(dir+"/"+key, []byte(key)), "should be no error")
+		_, err := cache.Get(dir + "/" + key)
+		assert.NoError(t, err, "should be no error")
+	}
+	_, err := cache.List(dir)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.watches), "should be equal")
+
+	// the changes are routed to the results of the key
+	assert.NoError(t, store.Put(dir+"/b", []byte("bb")), "should be no error")
+	assert.True(t, eventually(func() bool {
+		pair, err := cache.Get(dir + "/b")
+		return err == nil && string(pair.Value) == "bb"
+	}), "should be true")
+	assert.True(t, eventually(func() bool {
+		pairs, err := cache.List(dir)
+		return err == nil && len(pairs) == 3 && string(pairs[1].Value) == "bb"
+	}), "should be true")
+	pair, err := cache.Get(dir + "/a")
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("a"), pair.Value, "should be equal")
+}
+
+func TestCacheLimits(t *testing.T) {
+	cache := New(kv.New())
+	defer cache.Close()
+
+	sem, err := kv.NewSemaphore(cache, "testCacheLimits/sem", 1)
+	assert.NoError(t, err, "should be no error")
+	ok, err := sem.TryAcquire()
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, ok, "should be true")
+
+	limiter, err := kv.NewRateLimiter(cache, "testCacheLimits/rate", kv.RateLimit{Limit: 1, Window: time.Minute})
+	assert.NoError(t, err, "should be no error")
+	ok, _, err = limiter.Allow()
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, ok, "should be true")
+}
+
+func counterValue(t *testing.T, registry *metrics.PrometheusRegistry, name string) float64 {
+	families, err := registry.Gather()
+	assert.NoError(t, err, "should be no error")
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func eventually(cond func() bool) bool {
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
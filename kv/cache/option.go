@@ -0,0 +1,41 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "github.com/getamis/sirius/metrics"
+
+type Option func(c *Cache)
+
+// Size returns an option to set the max number of the cached results
+func Size(size int) Option {
+	return func(c *Cache) {
+		c.size = size
+	}
+}
+
+// Registry returns an option to set the registry of the hit, miss and eviction counters
+func Registry(registry metrics.Registry) Option {
+	return func(c *Cache) {
+		c.registry = registry
+	}
+}
+
+// MetricsOptions returns an option to set the options of the counters, e.g. the labels
+// to tell the caches apart
+func MetricsOptions(opts ...metrics.Option) Option {
+	return func(c *Cache) {
+		c.metricsOpts = append(c.metricsOpts, opts...)
+	}
+}
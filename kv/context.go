@@ -0,0 +1,316 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import "context"
+
+// ContextStore is the context-first variant of Store.
+// The operations return ctx.Err() once the context is done,
+// and the watches are stopped with the context.
+// The backends which honor the context natively, e.g., kv/redis, bound the calls
+// by the deadline and never return ctx.Err() while a write may still be applied.
+// Otherwise a call in flight only stops being waited for: it keeps running in the
+// background and may still be applied, so it's bounded by the timeouts of the backend.
+type ContextStore interface {
+	// Put a value with the specified key
+	Put(ctx context.Context, key string, value []byte, opts ...PutOption) error
+
+	// AtomicPut puts a single value and gets previous one if exists.
+	// Pass previous = nil to create a new key.
+	AtomicPut(ctx context.Context, key string, value []byte, expected *KeyValue, opts ...PutOption) (*KeyValue, error)
+
+	// Get a value with given key
+	Get(ctx context.Context, key string) (*KeyValue, error)
+
+	// List the content with given prefix
+	List(ctx context.Context, prefix string) ([]*KeyValue, error)
+
+	// ListPage lists a page of the content with given prefix.
+	// The returned cursor is used to get the next page and it's empty after the last page.
+	ListPage(ctx context.Context, prefix string, opts ...ListOption) ([]*KeyValue, string, error)
+
+	// Delete the value with the specified key
+	Delete(ctx context.Context, key string) error
+
+	// Atomic delete of a single value
+	AtomicDelete(ctx context.Context, key string, expected *KeyValue) (bool, error)
+
+	// DeleteTree deletes a range of keys under a given prefix
+	DeleteTree(ctx context.Context, prefix string) error
+
+	// Txn applies the ops only if all the compares hold, so either all ops are committed or none.
+	// It returns the results of the ops in order, which are nil for the deletes.
+	Txn(ctx context.Context, compares []Compare, ops []Op) ([]*KeyValue, error)
+
+	// Exists checks if a key exists in the backend
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Watch for changes on a key until the context is done
+	Watch(ctx context.Context, key string) (<-chan *KeyValue, error)
+
+	// WatchTree watches for changes on child nodes under a given prefix until the context is done
+	WatchTree(ctx context.Context, prefix string) (<-chan []*KeyValue, error)
+
+	// WatchEvents watches the puts, deletes and expirations of the keys with given prefix
+	// until the context is done
+	WatchEvents(ctx context.Context, prefix string, opts ...WatchOption) (<-chan *Event, error)
+
+	// Lock locks the given key.
+	// The returned ContextLocker is not held and must be acquired
+	Lock(ctx context.Context, key string, opts ...LockOption) (ContextLocker, error)
+
+	// Close the connection
+	Close()
+}
+
+// ContextLocker is the context-first variant of Locker.
+// The context of Lock only bounds the acquisition, the lock is held until Unlock
+// or until it's lost, which is notified by closing the returned channel.
+type ContextLocker interface {
+	Lock(ctx context.Context) (<-chan struct{}, error)
+	Unlock(ctx context.Context) error
+	Token() uint64
+	Err() error
+}
+
+// contextStorer is implemented by the backends which honor the context natively
+type contextStorer interface {
+	ContextStore() ContextStore
+}
+
+// NewContextStore adapts a Store to ContextStore.
+// The native variant of the backend is used if there is one, otherwise the
+// context is checked around the calls and is turned into the stop channels.
+func NewContextStore(s Store) ContextStore {
+	if cs, ok := s.(contextStorer); ok {
+		return cs.ContextStore()
+	}
+	return &contextStore{store: s}
+}
+
+type contextStore struct {
+	store Store
+}
+
+func (s *contextStore) Put(ctx context.Context, key string, value []byte, opts ...PutOption) error {
+	return Do(ctx, func() error {
+		return s.store.Put(key, value, opts...)
+	})
+}
+
+func (s *contextStore) AtomicPut(ctx context.Context, key string, value []byte, expected *KeyValue, opts ...PutOption) (*KeyValue, error) {
+	var pair *KeyValue
+	err := Do(ctx, func() (err error) {
+		pair, err = s.store.AtomicPut(key, value, expected, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *contextStore) Get(ctx context.Context, key string) (*KeyValue, error) {
+	var pair *KeyValue
+	err := Do(ctx, func() (err error) {
+		pair, err = s.store.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func (s *contextStore) List(ctx context.Context, prefix string) ([]*KeyValue, error) {
+	var pairs []*KeyValue
+	err := Do(ctx, func() (err error) {
+		pairs, err = s.store.List(prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+func (s *contextStore) ListPage(ctx context.Context, prefix string, opts ...ListOption) ([]*KeyValue, string, error) {
+	var (
+		pairs  []*KeyValue
+		cursor string
+	)
+	err := Do(ctx, func() (err error) {
+		pairs, cursor, err = s.store.ListPage(prefix, opts...)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pairs, cursor, nil
+}
+
+func (s *contextStore) Delete(ctx context.Context, key string) error {
+	return Do(ctx, func() error {
+		return s.store.Delete(key)
+	})
+}
+
+func (s *contextStore) AtomicDelete(ctx context.Context, key string, expected *KeyValue) (bool, error) {
+	var deleted bool
+	err := Do(ctx, func() (err error) {
+		deleted, err = s.store.AtomicDelete(key, expected)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+func (s *contextStore) DeleteTree(ctx context.Context, prefix string) error {
+	return Do(ctx, func() error {
+		return s.store.DeleteTree(prefix)
+	})
+}
+
+func (s *contextStore) Txn(ctx context.Context, compares []Compare, ops []Op) ([]*KeyValue, error) {
+	var results []*KeyValue
+	err := Do(ctx, func() (err error) {
+		results, err = s.store.Txn(compares, ops)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *contextStore) Exists(ctx context.Context, key string) (bool, error) {
+	var exist bool
+	err := Do(ctx, func() (err error) {
+		exist, err = s.store.Exists(key)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+func (s *contextStore) Watch(ctx context.Context, key string) (<-chan *KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.Watch(key, ctx.Done())
+}
+
+func (s *contextStore) WatchTree(ctx context.Context, prefix string) (<-chan []*KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.WatchTree(prefix, ctx.Done())
+}
+
+func (s *contextStore) WatchEvents(ctx context.Context, prefix string, opts ...WatchOption) (<-chan *Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.store.WatchEvents(prefix, ctx.Done(), opts...)
+}
+
+func (s *contextStore) Lock(ctx context.Context, key string, opts ...LockOption) (ContextLocker, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	locker, err := s.store.Lock(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &contextLocker{locker: locker}, nil
+}
+
+func (s *contextStore) Close() {
+	s.store.Close()
+}
+
+type contextLocker struct {
+	locker Locker
+}
+
+// Lock acquires the lock with a stop channel which is closed if the context
+// is done before the lock is acquired. The stop channel is left open after
+// that, so the lock is held until Unlock.
+func (l *contextLocker) Lock(ctx context.Context) (<-chan struct{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stopCh := make(chan struct{})
+	acquired := make(chan struct{})
+	cancelled := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stopCh)
+			close(cancelled)
+		case <-acquired:
+		}
+	}()
+
+	lockHeld, err := l.locker.Lock(stopCh)
+	close(acquired)
+	if err != nil {
+		select {
+		case <-cancelled:
+			return nil, ctx.Err()
+		default:
+			return nil, err
+		}
+	}
+	return lockHeld, nil
+}
+
+func (l *contextLocker) Unlock(ctx context.Context) error {
+	return Do(ctx, l.locker.Unlock)
+}
+
+func (l *contextLocker) Token() uint64 {
+	return l.locker.Token()
+}
+
+func (l *contextLocker) Err() error {
+	return l.locker.Err()
+}
+
+// Do calls fn and waits until it returns or the context is done.
+// fn keeps running in the background after the context is done, so it must
+// not share the results with the caller unless Do returns nil.
+func Do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+// +build go1.9
+
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Semaphore creates a semaphore of the key with limit slots
+func (s *defaultStore) Semaphore(key string, limit int, opts ...SemaphoreOption) (Semaphore, error) {
+	o := &SemaphoreOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if limit <= 0 {
+		return nil, ErrInvalidLimit
+	}
+	ttl := defaultLockTTL
+	if o.TTL > 0 {
+		ttl = o.TTL
+	}
+
+	return &defaultSemaphore{
+		limits: s.limits,
+		key:    Normalize(key),
+		limit:  limit,
+		ttl:    ttl,
+	}, nil
+}
+
+// RateLimiter creates a rate limiter of the key
+func (s *defaultStore) RateLimiter(key string, rate RateLimit) (RateLimiter, error) {
+	if rate.Limit <= 0 || rate.Window <= 0 {
+		return nil, ErrInvalidLimit
+	}
+	return &defaultRateLimiter{
+		limits: s.limits,
+		key:    Normalize(key),
+		rate:   rate,
+	}, nil
+}
+
+// limits keeps the states of the semaphores and the rate limiters, which are
+// not visible through the Store methods
+type limits struct {
+	mu      sync.Mutex
+	slots   map[string]map[*defaultSemaphore]time.Time
+	buckets map[string]*bucket
+	windows map[string][]time.Time
+}
+
+func newLimits() *limits {
+	return &limits{
+		slots:   make(map[string]map[*defaultSemaphore]time.Time),
+		buckets: make(map[string]*bucket),
+		windows: make(map[string][]time.Time),
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type defaultSemaphore struct {
+	limits *limits
+	key    string
+	limit  int
+	ttl    time.Duration
+}
+
+func (s *defaultSemaphore) Acquire(stopCh <-chan struct{}) error {
+	return WaitFor(stopCh, func() (bool, time.Duration, error) {
+		ok, err := s.TryAcquire()
+		return ok, 0, err
+	})
+}
+
+func (s *defaultSemaphore) TryAcquire() (bool, error) {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+
+	now := time.Now()
+	slots := s.limits.slots[s.key]
+	if slots == nil {
+		slots = make(map[*defaultSemaphore]time.Time)
+		s.limits.slots[s.key] = slots
+	}
+	for holder, expireAt := range slots {
+		if !now.Before(expireAt) {
+			delete(slots, holder)
+		}
+	}
+
+	if _, ok := slots[s]; !ok && len(slots) >= s.limit {
+		return false, nil
+	}
+	slots[s] = now.Add(s.ttl)
+	return true, nil
+}
+
+func (s *defaultSemaphore) Release() error {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+
+	slots := s.limits.slots[s.key]
+	expireAt, ok := slots[s]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(slots, s)
+	if len(slots) == 0 {
+		delete(s.limits.slots, s.key)
+	}
+	if !time.Now().Before(expireAt) {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+type defaultRateLimiter struct {
+	limits *limits
+	key    string
+	rate   RateLimit
+}
+
+func (l *defaultRateLimiter) Wait(stopCh <-chan struct{}) error {
+	return WaitFor(stopCh, l.Allow)
+}
+
+func (l *defaultRateLimiter) Allow() (bool, time.Duration, error) {
+	l.limits.mu.Lock()
+	defer l.limits.mu.Unlock()
+
+	now := time.Now()
+	if l.rate.Algorithm == SlidingWindow {
+		return l.slide(now)
+	}
+	return l.take(now)
+}
+
+// take takes a token from the bucket, which is refilled by Limit tokens per Window
+func (l *defaultRateLimiter) take(now time.Time) (bool, time.Duration, error) {
+	limit := float64(l.rate.Limit)
+	b, ok := l.limits.buckets[l.key]
+	if !ok {
+		b = &bucket{tokens: limit, last: now}
+		l.limits.buckets[l.key] = b
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(limit, b.tokens+float64(now.Sub(b.last))*limit/float64(l.rate.Window))
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration(math.Ceil((1 - b.tokens) * float64(l.rate.Window) / limit)), nil
+}
+
+// slide records the call if there are less than Limit calls in the last Window
+func (l *defaultRateLimiter) slide(now time.Time) (bool, time.Duration, error) {
+	calls := l.limits.windows[l.key]
+	start := now.Add(-l.rate.Window)
+	for len(calls) > 0 && !calls[0].After(start) {
+		calls = calls[1:]
+	}
+
+	if len(calls) < l.rate.Limit {
+		l.limits.windows[l.key] = append(calls, now)
+		return true, 0, nil
+	}
+	l.limits.windows[l.key] = calls
+	return false, calls[0].Sub(start), nil
+}
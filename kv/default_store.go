@@ -16,17 +16,57 @@
 
 package kv
 
-import "sync"
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLockTTL = 60 * time.Second
+
+	// eventHistory is the number of the latest events kept for resuming the watches
+	eventHistory = 1000
+)
 
 // New creates in-memory key-value store
 func New() Store {
-	return &defaultStore{}
+	return &defaultStore{
+		data:     make(map[string]*entry),
+		watchers: make(map[*watcher]struct{}),
+		closers:  make(map[chan struct{}]struct{}),
+		limits:   newLimits(),
+	}
 }
 
 // ----------------------------------------------------------------------------
 
+// entry is a stored key-value pair and its optional expiration timer
+type entry struct {
+	kv       *KeyValue
+	expireAt time.Time
+	timer    *time.Timer
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// defaultStore keeps everything in a map which is keyed by the normalized key.
+// Each write bumps a store-wide revision, so revisions are strictly increasing.
 type defaultStore struct {
-	m sync.Map
+	mu       sync.RWMutex
+	data     map[string]*entry
+	revision uint64
+
+	// events are the latest events, and compacted is the revision of the last dropped one
+	events    []memEvent
+	compacted uint64
+
+	watchers map[*watcher]struct{}
+	closers  map[chan struct{}]struct{}
+	limits   *limits
 }
 
 func (s *defaultStore) Put(key string, value []byte, opts ...PutOption) error {
@@ -35,75 +75,816 @@ func (s *defaultStore) Put(key string, value []byte, opts ...PutOption) error {
 		opt(o)
 	}
 
-	if o.IsPrefix || o.TTL > 0 {
-		return ErrNotSupported
-	}
-
-	s.m.Store(key, value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	s.set(key, value, o.TTL)
 	return nil
 }
 
 func (s *defaultStore) AtomicPut(key string, value []byte, expected *KeyValue, opts ...PutOption) (*KeyValue, error) {
-	return nil, ErrNotSupported
+	o := &PutOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.lookup(Normalize(key))
+	if expected == nil {
+		if e != nil {
+			return nil, ErrKeyExists
+		}
+		return copyKeyValue(s.set(key, value, o.TTL)), nil
+	}
+	if e == nil {
+		return nil, ErrKeyNotFound
+	}
+	if e.kv.Revision != expected.Revision {
+		return nil, ErrKeyModified
+	}
+	return copyKeyValue(s.set(key, value, o.TTL)), nil
 }
 
 func (s *defaultStore) Get(key string) (*KeyValue, error) {
-	if v, ok := s.m.Load(key); ok {
-		return &KeyValue{
-			Key:      key,
-			Value:    v.([]byte),
-			Revision: 1,
-		}, nil
-	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return nil, ErrKeyNotFound
+	e := s.lookup(Normalize(key))
+	if e == nil {
+		return nil, ErrKeyNotFound
+	}
+	return copyKeyValue(e.kv), nil
 }
 
 func (s *defaultStore) List(prefix string) ([]*KeyValue, error) {
-	return nil, ErrNotSupported
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.list(Normalize(prefix))
 }
 
-func (s *defaultStore) Delete(key string) error {
-	exist, err := s.Exists(key)
-	if err != nil {
-		return err
+// list returns the children of the given normalized directory. The directory
+// itself is not returned, but it's counted as a hit when deciding ErrKeyNotFound.
+func (s *defaultStore) list(directory string) ([]*KeyValue, error) {
+	found := false
+	pairs := []*KeyValue{}
+	now := time.Now()
+	for k, e := range s.data {
+		if !strings.HasPrefix(k, directory) || e.expired(now) {
+			continue
+		}
+		found = true
+		if k != directory {
+			pairs = append(pairs, copyKeyValue(e.kv))
+		}
+	}
+	if !found {
+		return nil, ErrKeyNotFound
 	}
+	return pairs, nil
+}
 
-	if exist {
-		s.m.Delete(key)
-		return nil
+// ListPage lists a page of the children in the order of the keys.
+// The cursor is the last normalized key of the page.
+func (s *defaultStore) ListPage(prefix string, opts ...ListOption) ([]*KeyValue, string, error) {
+	o := NewListOptions(opts...)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	directory := Normalize(prefix)
+	now := time.Now()
+	var keys []string
+	for k, e := range s.data {
+		if k == directory || !strings.HasPrefix(k, directory) || k <= o.Cursor || e.expired(now) {
+			continue
+		}
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return ErrKeyNotFound
+	cursor := ""
+	if len(keys) > o.Limit {
+		keys = keys[:o.Limit]
+		cursor = keys[len(keys)-1]
+	}
+	pairs := make([]*KeyValue, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, copyKeyValue(s.data[k].kv))
+	}
+	return pairs, cursor, nil
+}
+
+func (s *defaultStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nKey := Normalize(key)
+	if s.lookup(nKey) == nil {
+		return ErrKeyNotFound
+	}
+	s.remove(nKey, false)
+	return nil
 }
 
 func (s *defaultStore) AtomicDelete(key string, expected *KeyValue) (bool, error) {
-	return false, ErrNotSupported
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nKey := Normalize(key)
+	e := s.lookup(nKey)
+	if e == nil {
+		return false, ErrKeyNotFound
+	}
+	if expected == nil || e.kv.Revision != expected.Revision {
+		return false, ErrKeyModified
+	}
+	s.remove(nKey, false)
+	return true, nil
 }
 
 func (s *defaultStore) DeleteTree(prefix string) error {
-	return ErrNotSupported
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nPrefix := Normalize(prefix)
+	now := time.Now()
+	var keys []string
+	for k, e := range s.data {
+		if strings.HasPrefix(k, nPrefix) && !e.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ErrKeyNotFound
+	}
+	for _, k := range keys {
+		s.remove(k, false)
+	}
+	return nil
+}
+
+func (s *defaultStore) Txn(compares []Compare, ops []Op) ([]*KeyValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, cmp := range compares {
+		e := s.lookup(Normalize(cmp.Key))
+		if cmp.Revision == 0 {
+			if e != nil {
+				return nil, ErrKeyExists
+			}
+			continue
+		}
+		if e == nil {
+			return nil, ErrKeyNotFound
+		}
+		if e.kv.Revision != cmp.Revision {
+			return nil, ErrKeyModified
+		}
+	}
+
+	results := make([]*KeyValue, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case OpPut:
+			results[i] = copyKeyValue(s.set(op.Key, op.Value, op.TTL))
+		case OpDelete:
+			nKey := Normalize(op.Key)
+			if s.lookup(nKey) != nil {
+				s.remove(nKey, false)
+			}
+		}
+	}
+	return results, nil
 }
 
 func (s *defaultStore) Exists(key string) (bool, error) {
-	if _, ok := s.m.Load(key); ok {
-		return true, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookup(Normalize(key)) != nil, nil
+}
+
+// TTL returns the remaining TTL of the key, which is 0 if the key doesn't expire
+func (s *defaultStore) TTL(key string) (time.Duration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.lookup(Normalize(key))
+	if e == nil {
+		return 0, ErrKeyNotFound
 	}
+	if e.expireAt.IsZero() {
+		return 0, nil
+	}
+	return time.Until(e.expireAt), nil
+}
+
+// TTLs returns the remaining TTLs of the keys, and the keys which don't exist are left out
+func (s *defaultStore) TTLs(keys []string) (map[string]time.Duration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return false, nil
+	ttls := make(map[string]time.Duration, len(keys))
+	for _, key := range keys {
+		e := s.lookup(Normalize(key))
+		if e == nil {
+			continue
+		}
+		if e.expireAt.IsZero() {
+			ttls[key] = 0
+		} else {
+			ttls[key] = time.Until(e.expireAt)
+		}
+	}
+	return ttls, nil
 }
 
+// Watch for changes on a key. The current value is delivered first if the key exists,
+// and deletions or expirations are not delivered.
 func (s *defaultStore) Watch(key string, stopCh <-chan struct{}) (<-chan *KeyValue, error) {
-	return nil, ErrNotSupported
+	watchCh := make(chan *KeyValue)
+
+	s.mu.Lock()
+	w := s.subscribe(Normalize(key), false)
+	var current *KeyValue
+	if e := s.lookup(w.key); e != nil {
+		current = copyKeyValue(e.kv)
+	}
+	s.mu.Unlock()
+
+	go func() {
+		defer close(watchCh)
+		defer s.unsubscribe(w)
+
+		send := func(kv *KeyValue) bool {
+			select {
+			case watchCh <- kv:
+				return true
+			case <-stopCh:
+			case <-w.done:
+			}
+			return false
+		}
+
+		if current != nil && !send(current) {
+			return
+		}
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.done:
+				return
+			case <-w.notify:
+				for _, ev := range w.pop() {
+					if ev.event.Type != EventPut {
+						continue
+					}
+					if !send(copyKeyValue(ev.event.KV)) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return watchCh, nil
 }
 
+// WatchTree watches for changes on child nodes under a given prefix.
+// Each change delivers the whole list of children.
 func (s *defaultStore) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*KeyValue, error) {
-	return nil, ErrNotSupported
+	watchCh := make(chan []*KeyValue)
+
+	s.mu.Lock()
+	w := s.subscribe(Normalize(prefix), true)
+	s.mu.Unlock()
+
+	go func() {
+		defer close(watchCh)
+		defer s.unsubscribe(w)
+
+		send := func() bool {
+			s.mu.RLock()
+			pairs, err := s.list(w.key)
+			s.mu.RUnlock()
+			if err != nil {
+				pairs = []*KeyValue{}
+			}
+
+			select {
+			case watchCh <- pairs:
+				return true
+			case <-stopCh:
+			case <-w.done:
+			}
+			return false
+		}
+
+		if !send() {
+			return
+		}
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.done:
+				return
+			case <-w.notify:
+				w.pop()
+				if !send() {
+					return
+				}
+			}
+		}
+	}()
+
+	return watchCh, nil
+}
+
+// WatchEvents watches the puts, deletes and expirations of the keys with given prefix.
+// Only the latest events are kept, so ErrCompacted is returned if the watch is
+// resumed from a revision which is too old.
+func (s *defaultStore) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...WatchOption) (<-chan *Event, error) {
+	o := &WatchOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s.mu.Lock()
+	if o.FromRevision > 0 && o.FromRevision <= s.compacted {
+		s.mu.Unlock()
+		return nil, ErrCompacted
+	}
+	w := s.subscribe(Normalize(prefix), true)
+	var history []memEvent
+	if o.FromRevision > 0 {
+		for _, ev := range s.events {
+			if ev.event.KV.Revision >= o.FromRevision && w.match(ev.key) {
+				history = append(history, ev)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	watchCh := make(chan *Event)
+	go func() {
+		defer close(watchCh)
+		defer s.unsubscribe(w)
+
+		send := func(events []memEvent) bool {
+			for _, ev := range events {
+				select {
+				case watchCh <- copyEvent(ev.event):
+				case <-stopCh:
+					return false
+				case <-w.done:
+					return false
+				}
+			}
+			return true
+		}
+
+		if !send(history) {
+			return
+		}
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.done:
+				return
+			case <-w.notify:
+				if !send(w.pop()) {
+					return
+				}
+			}
+		}
+	}()
+
+	return watchCh, nil
 }
 
+// Lock creates a lock for a given key.
+// The returned Locker is not held and must be acquired with `.Lock`.
 func (s *defaultStore) Lock(key string, opts ...LockOption) (Locker, error) {
-	return nil, ErrNotSupported
+	o := &LockOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ttl := defaultLockTTL
+	if o.TTL > 0 {
+		ttl = o.TTL
+	}
+
+	return &defaultLock{
+		store:   s,
+		key:     key,
+		value:   o.Value,
+		ttl:     ttl,
+		renewCh: o.RenewLock,
+	}, nil
+}
+
+// Close stops all the watches and lock renewals started from this store.
+// The stored data is kept.
+func (s *defaultStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for w := range s.watchers {
+		w.stop()
+		delete(s.watchers, w)
+	}
+	for ch := range s.closers {
+		close(ch)
+		delete(s.closers, ch)
+	}
+}
+
+// lookup returns the live entry of the normalized key, the caller must hold the lock
+func (s *defaultStore) lookup(nKey string) *entry {
+	e, ok := s.data[nKey]
+	if !ok || e.expired(time.Now()) {
+		return nil
+	}
+	return e
+}
+
+// set stores the value with a new revision, the caller must hold the write lock
+func (s *defaultStore) set(key string, value []byte, ttl time.Duration) *KeyValue {
+	nKey := Normalize(key)
+	var prev uint64
+	if old, ok := s.data[nKey]; ok {
+		if old.expired(time.Now()) {
+			// report the expiration which is not yet done by the timer
+			s.remove(nKey, true)
+		} else {
+			if old.timer != nil {
+				old.timer.Stop()
+			}
+			prev = old.kv.Revision
+		}
+	}
+
+	s.revision++
+	e := &entry{
+		kv: &KeyValue{
+			Key:      key,
+			Value:    copyBytes(value),
+			Revision: s.revision,
+		},
+	}
+	if ttl > 0 {
+		e.expireAt = time.Now().Add(ttl)
+		revision := s.revision
+		e.timer = time.AfterFunc(ttl, func() {
+			s.expire(nKey, revision)
+		})
+	}
+	s.data[nKey] = e
+	s.notify(nKey, &Event{
+		Type:         EventPut,
+		KV:           copyKeyValue(e.kv),
+		PrevRevision: prev,
+	})
+	return e.kv
+}
+
+// remove deletes the normalized key with a new revision, the caller must hold the write lock
+func (s *defaultStore) remove(nKey string, expired bool) {
+	e, ok := s.data[nKey]
+	if !ok {
+		return
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	delete(s.data, nKey)
+
+	s.revision++
+	ev := &Event{
+		Type: EventDelete,
+		KV: &KeyValue{
+			Key:      e.kv.Key,
+			Revision: s.revision,
+		},
+		PrevRevision: e.kv.Revision,
+	}
+	if expired {
+		ev.Type = EventExpire
+	}
+	s.notify(nKey, ev)
+}
+
+func (s *defaultStore) expire(nKey string, revision uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.data[nKey]; ok && e.kv.Revision == revision {
+		s.remove(nKey, true)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+// memEvent is a change on a single key which is delivered to the watchers.
+// The event is shared by the watchers, so it's copied before it's delivered.
+type memEvent struct {
+	key   string
+	event *Event
+}
+
+// watcher receives the events of a key or of all keys under a prefix.
+// Events are queued without blocking the writers.
+type watcher struct {
+	key    string
+	prefix bool
+
+	mu     sync.Mutex
+	queue  []memEvent
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (w *watcher) match(nKey string) bool {
+	if w.prefix {
+		return strings.HasPrefix(nKey, w.key)
+	}
+	return nKey == w.key
+}
+
+func (w *watcher) push(ev memEvent) {
+	w.mu.Lock()
+	w.queue = append(w.queue, ev)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
 }
 
-func (s *defaultStore) Close() {}
+func (w *watcher) pop() []memEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	q := w.queue
+	w.queue = nil
+	return q
+}
+
+func (w *watcher) stop() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+// subscribe registers a watcher, the caller must hold the write lock
+func (s *defaultStore) subscribe(nKey string, prefix bool) *watcher {
+	w := &watcher{
+		key:    nKey,
+		prefix: prefix,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	s.watchers[w] = struct{}{}
+	return w
+}
+
+func (s *defaultStore) unsubscribe(w *watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.stop()
+	delete(s.watchers, w)
+}
+
+// notify records the event and pushes it to the interested watchers,
+// the caller must hold the write lock
+func (s *defaultStore) notify(nKey string, event *Event) {
+	ev := memEvent{key: nKey, event: event}
+	s.events = append(s.events, ev)
+	if len(s.events) > eventHistory {
+		s.compacted = s.events[0].event.KV.Revision
+		s.events = s.events[1:]
+	}
+
+	for w := range s.watchers {
+		if w.match(nKey) {
+			w.push(ev)
+		}
+	}
+}
+
+// closer returns a channel which is closed when the store is closed
+func (s *defaultStore) closer() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan struct{})
+	s.closers[ch] = struct{}{}
+	return ch
+}
+
+func (s *defaultStore) releaseCloser(ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.closers, ch)
+}
+
+// ----------------------------------------------------------------------------
+
+// defaultLock is a lock on top of AtomicPut/AtomicDelete of the in-memory store.
+// The lock is renewed every ttl/3 until it's unlocked, the RenewLock channel is closed
+// or the store is closed.
+type defaultLock struct {
+	store   *defaultStore
+	key     string
+	value   []byte
+	ttl     time.Duration
+	renewCh chan struct{}
+
+	mu       sync.Mutex
+	last     *KeyValue
+	unlockCh chan struct{}
+	token    uint64
+	err      error
+}
+
+func (l *defaultLock) Lock(stopCh chan struct{}) (<-chan struct{}, error) {
+	l.store.mu.Lock()
+	w := l.store.subscribe(Normalize(l.key), false)
+	l.store.mu.Unlock()
+	defer l.store.unsubscribe(w)
+
+	for {
+		lockHeld, err := l.tryLock(stopCh)
+		if err != nil {
+			return nil, err
+		}
+		if lockHeld != nil {
+			return lockHeld, nil
+		}
+
+		// wait for changes on the key
+		select {
+		case <-stopCh:
+			return nil, ErrUnableToLock
+		case <-w.done:
+			return nil, ErrUnableToLock
+		case <-w.notify:
+			w.pop()
+		}
+	}
+}
+
+// tryLock returns the lockHeld channel if the lock is acquired,
+// or nil if the lock is held by someone else
+func (l *defaultLock) tryLock(stopCh chan struct{}) (<-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kv, err := l.store.AtomicPut(l.key, l.value, l.last, PutExpiration(l.ttl))
+	if err == ErrKeyExists || err == ErrKeyModified || err == ErrKeyNotFound {
+		// a stale revision can't be used to acquire the lock again
+		l.last = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	l.last = kv
+	l.token = kv.Revision
+	l.err = nil
+	l.unlockCh = make(chan struct{})
+	lockHeld := make(chan struct{})
+	go l.holdLock(lockHeld, l.unlockCh, stopCh)
+	return lockHeld, nil
+}
+
+func (l *defaultLock) holdLock(lockHeld, unlockCh, stopCh chan struct{}) {
+	defer close(lockHeld)
+
+	closeCh := l.store.closer()
+	defer l.store.releaseCloser(closeCh)
+
+	hold := func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		kv, err := l.store.AtomicPut(l.key, l.value, l.last, PutExpiration(l.ttl))
+		if err != nil {
+			return err
+		}
+		l.last = kv
+		return nil
+	}
+
+	heartbeat := time.NewTicker(l.ttl / 3)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			if err := hold(); err != nil {
+				l.lost(lockLost(err))
+				return
+			}
+		case <-unlockCh:
+			return
+		case <-stopCh:
+			return
+		case <-l.renewCh:
+			return
+		case <-closeCh:
+			l.lost(ErrLockUnreachable)
+			return
+		}
+	}
+}
+
+func (l *defaultLock) lost(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.err = err
+}
+
+func (l *defaultLock) Token() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.token
+}
+
+func (l *defaultLock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+func (l *defaultLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.unlockCh != nil {
+		close(l.unlockCh)
+		l.unlockCh = nil
+	}
+	if l.last == nil {
+		return ErrKeyNotFound
+	}
+
+	_, err := l.store.AtomicDelete(l.key, l.last)
+	l.last = nil
+	l.err = nil
+	return err
+}
+
+// lockLost returns why the lock is lost after its renewal fails
+func lockLost(err error) error {
+	switch err {
+	case ErrKeyNotFound:
+		return ErrLockExpired
+	case ErrKeyModified, ErrKeyExists:
+		return ErrLockStolen
+	}
+	return ErrLockUnreachable
+}
+
+// ----------------------------------------------------------------------------
+
+func copyKeyValue(kv *KeyValue) *KeyValue {
+	return &KeyValue{
+		Key:      kv.Key,
+		Value:    copyBytes(kv.Value),
+		Revision: kv.Revision,
+	}
+}
+
+func copyEvent(ev *Event) *Event {
+	return &Event{
+		Type:         ev.Type,
+		KV:           copyKeyValue(ev.KV),
+		PrevRevision: ev.PrevRevision,
+	}
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
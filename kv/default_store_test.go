@@ -14,34 +14,41 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package kv
+package kv_test
 
 import (
 	"testing"
+	"time"
 
+	store "github.com/getamis/sirius/kv"
+	testutils "github.com/getamis/sirius/kv/test"
 	"github.com/stretchr/testify/assert"
 )
 
-// AtomicPut is not supported
-//
-// func TestDefaultStoreAtomicPut(t *testing.T) {
-// 	kv := New()
-
-// 	previous, err := kv.AtomicPut("test-key-1", []byte("test-value-1"))
-// 	assert.NoError(t, err, "should be no error")
-// 	assert.Nil(t, previous, "should be nil")
-
-// 	previous, err = kv.AtomicPut("test-key-1", []byte("test-value-2"))
-// 	assert.NoError(t, err, "should be no error")
-// 	assert.Equal(t, previous.Value, []byte("test-value-1"), "should be equal")
-
-// 	previous, err = kv.AtomicPut("test-key-1", []byte("test-value-3"))
-// 	assert.NoError(t, err, "should be no error")
-// 	assert.Equal(t, previous.Value, []byte("test-value-2"), "should be equal")
-// }
+func TestDefaultStore(t *testing.T) {
+	kv := store.New()
+
+	// RunTestListLock is skipped because the lock is held on the key itself,
+	// the same as kv/redis.
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestListPage(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestRevision(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestWatchEvents(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestSemaphore(t, kv)
+	testutils.RunTestRateLimiter(t, kv)
+	testutils.RunTestContext(t, store.NewContextStore(kv))
+	testutils.RunTestRemainingTTL(t, kv)
+	testutils.RunTestLockTTL(t, kv, kv)
+	testutils.RunTestTTL(t, kv, kv)
+	testutils.RunCleanup(t, kv)
+}
 
 func TestDefaultStorePutGet(t *testing.T) {
-	kv := New()
+	kv := store.New()
 
 	err := kv.Put("test-key-1", []byte("test-value-1"))
 	assert.NoError(t, err, "should be no error")
@@ -54,14 +61,15 @@ func TestDefaultStorePutGet(t *testing.T) {
 	assert.NoError(t, err, "should be no error")
 	assert.Equal(t, v.Value, []byte("test-value-1"), "should be equal")
 
-	_, err = kv.List("test-key-1")
-	assert.Equal(t, ErrNotSupported, err, "should be equal")
+	pairs, err := kv.List("test-key-1")
+	assert.NoError(t, err, "should be no error")
+	assert.Empty(t, pairs, "should be empty")
 }
 
 func TestDefaultStoreDelete(t *testing.T) {
-	kv := New()
+	kv := store.New()
 
-	testData := &KeyValue{
+	testData := &store.KeyValue{
 		Key:   "test-key-1",
 		Value: []byte("test-value-1"),
 	}
@@ -77,24 +85,99 @@ func TestDefaultStoreDelete(t *testing.T) {
 	assert.NoError(t, err, "should be no error")
 
 	err = kv.Delete("test-key-1")
-	assert.Equal(t, ErrKeyNotFound, err, "should be equal")
-
-	// AtomicDelete is not supported
-	//
-	// testData = &KeyValue{
-	// 	Key:   "test-key-2",
-	// 	Value: []byte("test-value-2"),
-	// }
-
-	// err = kv.Put("test-key-2", []byte("test-value-2"))
-	// assert.NoError(t, err, "should be no error")
-
-	// v, err := kv.AtomicDelete("test-key-2")
-	// assert.Equal(t, testData.Key, v.Key, "should be equal")
-	// assert.Equal(t, testData.Value, v.Value, "should be equal")
-	// assert.NoError(t, err, "should be no error")
-
-	// v, err = kv.AtomicDelete("test-key-2")
-	// assert.Nil(t, v, "should be nil")
-	// assert.Equal(t, ErrKeyNotFound, err, "should be equal")
+	assert.Equal(t, store.ErrKeyNotFound, err, "should be equal")
+}
+
+func TestDefaultStoreRevision(t *testing.T) {
+	kv := store.New()
+
+	assert.NoError(t, kv.Put("test-key-1", []byte("test-value-1")))
+	first, err := kv.Get("test-key-1")
+	assert.NoError(t, err, "should be no error")
+
+	assert.NoError(t, kv.Put("test-key-2", []byte("test-value-2")))
+	second, err := kv.Get("test-key-2")
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, second.Revision > first.Revision, "should be increasing")
+
+	updated, err := kv.AtomicPut("test-key-1", []byte("test-value-3"), first)
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, updated.Revision > second.Revision, "should be increasing")
+
+	_, err = kv.AtomicPut("test-key-1", []byte("test-value-4"), first)
+	assert.Equal(t, store.ErrKeyModified, err, "should be equal")
+}
+
+func TestDefaultStoreExpiration(t *testing.T) {
+	kv := store.New()
+
+	assert.NoError(t, kv.Put("test-key-1", []byte("test-value-1"), store.PutExpiration(100*time.Millisecond)))
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := kv.WatchTree("test-key", stopCh)
+	assert.NoError(t, err, "should be no error")
+
+	pairs := <-events
+	assert.Len(t, pairs, 1, "should be equal")
+
+	// the expiration is delivered as a change of the tree
+	select {
+	case pairs = <-events:
+		assert.Empty(t, pairs, "should be empty")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+
+	exist, err := kv.Exists("test-key-1")
+	assert.False(t, exist, "should be false")
+	assert.NoError(t, err, "should be no error")
+}
+
+func TestDefaultStoreWatchEvents(t *testing.T) {
+	kv := store.New()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := kv.WatchEvents("test-key", stopCh)
+	assert.NoError(t, err, "should be no error")
+
+	assert.NoError(t, kv.Put("test-key-1", []byte("test-value-1"), store.PutExpiration(100*time.Millisecond)))
+	created := <-events
+	assert.Equal(t, store.EventPut, created.Type, "should be equal")
+
+	select {
+	case expired := <-events:
+		assert.Equal(t, store.EventExpire, expired.Type, "should be equal")
+		assert.Equal(t, "test-key-1", expired.KV.Key, "should be equal")
+		assert.Equal(t, created.KV.Revision, expired.PrevRevision, "should be equal")
+		assert.True(t, expired.KV.Revision > created.KV.Revision, "should be increasing")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+
+	// the oldest events are dropped
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, kv.Put("test-key-2", []byte("test-value-2")))
+	}
+	_, err = kv.WatchEvents("test-key", stopCh, store.WatchFromRevision(created.KV.Revision))
+	assert.Equal(t, store.ErrCompacted, err, "should be equal")
+}
+
+func TestDefaultStoreLockLost(t *testing.T) {
+	kv := store.New()
+
+	lock, err := kv.Lock("test-key-1", store.LockExpiration(300*time.Millisecond))
+	assert.NoError(t, err, "should be no error")
+	lockHeld, err := lock.Lock(nil)
+	assert.NoError(t, err, "should be no error")
+
+	// the key is changed by someone else, so the renewal fails
+	assert.NoError(t, kv.Put("test-key-1", []byte("test-value-1")))
+	select {
+	case <-lockHeld:
+		assert.Equal(t, store.ErrLockStolen, lock.Err(), "should be equal")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
 }
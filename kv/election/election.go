@@ -0,0 +1,320 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+)
+
+const defaultTTL = 15 * time.Second
+
+var (
+	// ErrNoLeader is returned when there is no leader
+	ErrNoLeader = errors.New("election: no leader")
+	// ErrCampaigning is returned when the election is campaigned again before it's resigned
+	ErrCampaigning = errors.New("election: already campaigning")
+	// ErrNotLeader is returned when the election is resigned but it's not the leader
+	ErrNotLeader = errors.New("election: not the leader")
+)
+
+// Election elects a leader among the candidates campaigning on the same key.
+// It's built on the lock of the key, and the identity of the leader is the
+// value of the lock, so it works with any kv.Store supporting AtomicPut and Watch,
+// and the leaders are observed through WatchEvents, or through Watch if it's not supported.
+type Election struct {
+	store kv.ContextStore
+	key   string
+	ttl   time.Duration
+
+	mu          sync.Mutex
+	campaigning bool
+	locker      kv.ContextLocker
+	leadership  chan bool
+}
+
+// New creates an election on the key
+func New(store kv.Store, key string, options ...Option) *Election {
+	e := &Election{
+		store:      kv.NewContextStore(store),
+		key:        key,
+		ttl:        defaultTTL,
+		leadership: make(chan bool, 1),
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+// Campaign blocks until the candidate is elected as the leader or ctx is done.
+// The leadership is kept until Resign, or until it's lost, which is notified by Leadership.
+func (e *Election) Campaign(ctx context.Context, id string) error {
+	e.mu.Lock()
+	if e.campaigning {
+		e.mu.Unlock()
+		return ErrCampaigning
+	}
+	e.campaigning = true
+	e.mu.Unlock()
+
+	locker, lockHeld, err := e.lock(ctx, id)
+	if err != nil {
+		e.mu.Lock()
+		e.campaigning = false
+		e.mu.Unlock()
+		return err
+	}
+
+	e.mu.Lock()
+	e.locker = locker
+	e.notify(true)
+	e.mu.Unlock()
+
+	go func() {
+		<-lockHeld
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		// the leadership is lost unless it's resigned
+		if e.locker == locker {
+			e.locker = nil
+			e.campaigning = false
+			e.notify(false)
+		}
+	}()
+	return nil
+}
+
+func (e *Election) lock(ctx context.Context, id string) (kv.ContextLocker, <-chan struct{}, error) {
+	locker, err := e.store.Lock(ctx, e.key,
+		kv.LockValue([]byte(id)),
+		kv.LockExpiration(e.ttl),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	lockHeld, err := locker.Lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return locker, lockHeld, nil
+}
+
+// Resign gives up the leadership, so another candidate can be elected
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	locker := e.locker
+	if locker == nil {
+		e.mu.Unlock()
+		return ErrNotLeader
+	}
+	e.locker = nil
+	e.campaigning = false
+	e.notify(false)
+	e.mu.Unlock()
+
+	return locker.Unlock(ctx)
+}
+
+// IsLeader returns true if the candidate is the leader
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.locker != nil
+}
+
+// Leadership returns the channel which delivers the leadership of the candidate
+// when it's changed. Only the latest change is kept if it's not received in time.
+func (e *Election) Leadership() <-chan bool {
+	return e.leadership
+}
+
+// Leader returns the identity of the current leader
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	pair, err := e.store.Get(ctx, e.key)
+	if err == kv.ErrKeyNotFound {
+		return "", ErrNoLeader
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(pair.Value), nil
+}
+
+// Observe delivers the identity of the leader whenever a leader is elected, and
+// an empty identity when the leader resigns or expires, until ctx is done.
+// The current leader is delivered first if there is one. Every election is
+// delivered, even if the same candidate is elected again.
+// If the store doesn't support WatchEvents, e.g. kv/redis without the EventStream
+// option, it falls back to Watch, which misses the re-elections of the same
+// candidate, and the leader being gone is noticed by reading the key every half of the TTL.
+func (e *Election) Observe(ctx context.Context) (<-chan string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var watchCh <-chan *kv.KeyValue
+	events, err := e.store.WatchEvents(ctx, e.key)
+	if err != nil {
+		watchCh, err = e.store.Watch(ctx, e.key)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the events before the current leader are skipped
+	current, err := e.store.Get(ctx, e.key)
+	if err != nil && err != kv.ErrKeyNotFound {
+		cancel()
+		return nil, err
+	}
+
+	o := &observer{
+		ctx:      ctx,
+		leaderCh: make(chan string),
+	}
+	go func() {
+		defer cancel()
+		defer close(o.leaderCh)
+
+		var since uint64
+		if current != nil {
+			since = current.Revision
+			if !o.elect(string(current.Value)) {
+				return
+			}
+		}
+		if events != nil {
+			o.followEvents(e.key, events, since)
+		} else {
+			e.followWatch(o, watchCh)
+		}
+	}()
+	return o.leaderCh, nil
+}
+
+// observer delivers the leaders observed
+type observer struct {
+	ctx      context.Context
+	leaderCh chan string
+	leader   *string
+}
+
+func (o *observer) send(id string) bool {
+	select {
+	case o.leaderCh <- id:
+		return true
+	case <-o.ctx.Done():
+		return false
+	}
+}
+
+// elect delivers the elected leader
+func (o *observer) elect(id string) bool {
+	o.leader = &id
+	return o.send(id)
+}
+
+// resign delivers an empty leader if there was a leader
+func (o *observer) resign() bool {
+	if o.leader == nil {
+		return true
+	}
+	o.leader = nil
+	return o.send("")
+}
+
+// followEvents delivers the leaders by the events after the given revision
+func (o *observer) followEvents(key string, events <-chan *kv.Event, since uint64) {
+	key = kv.Normalize(key)
+	for event := range events {
+		if kv.Normalize(event.KV.Key) != key || event.KV.Revision <= since {
+			continue
+		}
+
+		switch event.Type {
+		case kv.EventPut:
+			// the renewals of the lock are skipped, and an election creates the key
+			id := string(event.KV.Value)
+			if event.PrevRevision != 0 && o.leader != nil && *o.leader == id {
+				continue
+			}
+			if !o.elect(id) {
+				return
+			}
+		case kv.EventDelete, kv.EventExpire:
+			if !o.resign() {
+				return
+			}
+		}
+	}
+}
+
+// followWatch delivers the leaders by Watch, which doesn't report the deletions,
+// so the key is read every half of the TTL, and the watch is set up again if it's closed.
+func (e *Election) followWatch(o *observer, watchCh <-chan *kv.KeyValue) {
+	ticker := time.NewTicker(e.ttl / 2)
+	defer ticker.Stop()
+
+	put := func(pair *kv.KeyValue) bool {
+		id := string(pair.Value)
+		if o.leader != nil && *o.leader == id {
+			return true
+		}
+		return o.elect(id)
+	}
+
+	for {
+		select {
+		case <-o.ctx.Done():
+			return
+		case pair, ok := <-watchCh:
+			if !ok {
+				watchCh = nil
+				continue
+			}
+			if !put(pair) {
+				return
+			}
+		case <-ticker.C:
+			pair, err := e.store.Get(o.ctx, e.key)
+			if err == kv.ErrKeyNotFound {
+				if !o.resign() {
+					return
+				}
+			} else if err == nil {
+				if !put(pair) {
+					return
+				}
+			}
+			if watchCh == nil {
+				watchCh, _ = e.store.Watch(o.ctx, e.key)
+			}
+		}
+	}
+}
+
+// notify replaces the undelivered leadership with the latest one, the caller must hold the lock
+func (e *Election) notify(leader bool) {
+	select {
+	case <-e.leadership:
+	default:
+	}
+	e.leadership <- leader
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElection(t *testing.T) {
+	store := kv.New()
+	ctx := context.Background()
+
+	first := New(store, "election", TTL(time.Second))
+	second := New(store, "election", TTL(time.Second))
+
+	_, err := first.Leader(ctx)
+	assert.Equal(t, ErrNoLeader, err, "should be equal")
+
+	observeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	leaders, err := first.Observe(observeCtx)
+	assert.NoError(t, err, "should be no error")
+
+	assert.NoError(t, first.Campaign(ctx, "first"), "should be no error")
+	assert.True(t, first.IsLeader(), "should be true")
+	assert.True(t, <-first.Leadership(), "should be true")
+	assert.Equal(t, ErrCampaigning, first.Campaign(ctx, "first"), "should be equal")
+	assert.Equal(t, "first", <-leaders, "should be equal")
+
+	leader, err := second.Leader(ctx)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "first", leader, "should be equal")
+
+	// the second candidate is elected after the first one resigns
+	elected := make(chan error)
+	go func() {
+		elected <- second.Campaign(ctx, "second")
+	}()
+	select {
+	case <-elected:
+		t.Fatal("should not be elected")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.NoError(t, first.Resign(ctx), "should be no error")
+	assert.False(t, <-first.Leadership(), "should be false")
+	assert.Equal(t, ErrNotLeader, first.Resign(ctx), "should be equal")
+
+	select {
+	case err := <-elected:
+		assert.NoError(t, err, "should be no error")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+	assert.True(t, second.IsLeader(), "should be true")
+	assert.Equal(t, "", <-leaders, "should be equal")
+	assert.Equal(t, "second", <-leaders, "should be equal")
+
+	// the re-election of the same candidate is observed as well
+	assert.NoError(t, second.Resign(ctx), "should be no error")
+	assert.Equal(t, "", <-leaders, "should be equal")
+	assert.NoError(t, second.Campaign(ctx, "second"), "should be no error")
+	assert.Equal(t, "second", <-leaders, "should be equal")
+	assert.NoError(t, second.Resign(ctx), "should be no error")
+	assert.Equal(t, "", <-leaders, "should be equal")
+}
+
+func TestObserveExpired(t *testing.T) {
+	store := kv.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, store.Put("election", []byte("leader"), kv.PutExpiration(200*time.Millisecond)), "should be no error")
+	leaders, err := New(store, "election").Observe(ctx)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "leader", <-leaders, "should be equal")
+
+	select {
+	case leader := <-leaders:
+		assert.Equal(t, "", leader, "should be equal")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout reached")
+	}
+}
+
+func TestElectionCanceled(t *testing.T) {
+	store := kv.New()
+
+	first := New(store, "election")
+	assert.NoError(t, first.Campaign(context.Background(), "first"), "should be no error")
+	defer first.Resign(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	second := New(store, "election")
+	assert.Equal(t, context.DeadlineExceeded, second.Campaign(ctx, "second"), "should be equal")
+
+	// it can campaign again after the failure
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, second.Campaign(ctx, "second"), "should be equal")
+}
+
+func TestElectionLost(t *testing.T) {
+	store := kv.New()
+	ctx := context.Background()
+
+	e := New(store, "election", TTL(300*time.Millisecond))
+	assert.NoError(t, e.Campaign(ctx, "leader"), "should be no error")
+	assert.True(t, <-e.Leadership(), "should be true")
+
+	// the lock is stolen
+	assert.NoError(t, store.Put("election", []byte("someone")), "should be no error")
+	select {
+	case leader := <-e.Leadership():
+		assert.False(t, leader, "should be false")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+	assert.False(t, e.IsLeader(), "should be false")
+}
+
+// watchOnly is a store without WatchEvents
+type watchOnly struct {
+	kv.Store
+}
+
+func (watchOnly) WatchEvents(string, <-chan struct{}, ...kv.WatchOption) (<-chan *kv.Event, error) {
+	return nil, kv.ErrNotSupported
+}
+
+func TestObserveByWatch(t *testing.T) {
+	store := watchOnly{kv.New()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := New(store, "election", TTL(300*time.Millisecond))
+	second := New(store, "election", TTL(300*time.Millisecond))
+	leaders, err := first.Observe(ctx)
+	assert.NoError(t, err, "should be no error")
+
+	assert.NoError(t, first.Campaign(ctx, "first"), "should be no error")
+	assert.Equal(t, "first", <-leaders, "should be equal")
+
+	// the resignation is noticed by reading the key
+	assert.NoError(t, first.Resign(ctx), "should be no error")
+	select {
+	case leader := <-leaders:
+		assert.Equal(t, "", leader, "should be equal")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+
+	assert.NoError(t, second.Campaign(ctx, "second"), "should be no error")
+	select {
+	case leader := <-leaders:
+		assert.Equal(t, "second", leader, "should be equal")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout reached")
+	}
+	assert.NoError(t, second.Resign(ctx), "should be no error")
+}
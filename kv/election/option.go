@@ -0,0 +1,26 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import "time"
+
+type Option func(e *Election)
+
+// TTL returns an option to set how long the leadership is kept after the leader is gone
+func TTL(ttl time.Duration) Option {
+	return func(e *Election) {
+		e.ttl = ttl
+	}
+}
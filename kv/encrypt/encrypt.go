@@ -0,0 +1,335 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/internal/watch"
+	"github.com/getamis/sirius/log"
+)
+
+// Store is a kv.Store which encrypts the values with AES-GCM before they're
+// written to the underlying store, and decrypts them after they're read.
+// The keys and the revisions are kept in plaintext.
+// A value encrypted by a previous key is re-encrypted by the current key in
+// the background once it's read, unless it expires or Reencrypt(false) is given.
+type Store struct {
+	store     kv.Store
+	provider  KeyProvider
+	reencrypt bool
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+	wg       sync.WaitGroup
+}
+
+// New wraps the store with the encryption of the keys of the provider
+func New(store kv.Store, provider KeyProvider, options ...Option) *Store {
+	s := &Store{
+		store:     store,
+		provider:  provider,
+		reencrypt: true,
+		inflight:  make(map[string]struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+func (s *Store) Put(key string, value []byte, opts ...kv.PutOption) error {
+	envelope, err := seal(s.provider, kv.Normalize(key), value)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(key, envelope, opts...)
+}
+
+func (s *Store) AtomicPut(key string, value []byte, expected *kv.KeyValue, opts ...kv.PutOption) (*kv.KeyValue, error) {
+	envelope, err := seal(s.provider, kv.Normalize(key), value)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := s.store.AtomicPut(key, envelope, expected, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return withValue(pair, value), nil
+}
+
+func (s *Store) Get(key string) (*kv.KeyValue, error) {
+	pair, err := s.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(pair)
+}
+
+func (s *Store) List(prefix string) ([]*kv.KeyValue, error) {
+	pairs, err := s.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptAll(pairs)
+}
+
+func (s *Store) ListPage(prefix string, opts ...kv.ListOption) ([]*kv.KeyValue, string, error) {
+	pairs, cursor, err := s.store.ListPage(prefix, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	pairs, err = s.decryptAll(pairs)
+	if err != nil {
+		return nil, "", err
+	}
+	return pairs, cursor, nil
+}
+
+func (s *Store) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+func (s *Store) AtomicDelete(key string, expected *kv.KeyValue) (bool, error) {
+	return s.store.AtomicDelete(key, expected)
+}
+
+func (s *Store) DeleteTree(prefix string) error {
+	return s.store.DeleteTree(prefix)
+}
+
+func (s *Store) Txn(compares []kv.Compare, ops []kv.Op) ([]*kv.KeyValue, error) {
+	sealed := make([]kv.Op, len(ops))
+	for i, op := range ops {
+		if op.Type == kv.OpPut {
+			envelope, err := seal(s.provider, kv.Normalize(op.Key), op.Value)
+			if err != nil {
+				return nil, err
+			}
+			op.Value = envelope
+		}
+		sealed[i] = op
+	}
+
+	results, err := s.store.Txn(compares, sealed)
+	if err != nil {
+		return nil, err
+	}
+	for i, pair := range results {
+		if pair != nil {
+			results[i] = withValue(pair, ops[i].Value)
+		}
+	}
+	return results, nil
+}
+
+func (s *Store) Exists(key string) (bool, error) {
+	return s.store.Exists(key)
+}
+
+// Watch decrypts the changes of the key, and the ones failing to be decrypted are dropped
+func (s *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *kv.KeyValue, error) {
+	changes, err := s.store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, changes, stopCh, func(pair *kv.KeyValue) (*kv.KeyValue, bool) {
+			d, err := s.decrypt(pair)
+			if err != nil {
+				log.Warn("Failed to decrypt the value in Watch", "key", key, "err", err)
+				return nil, false
+			}
+			return d, true
+		})
+	}()
+	return watchCh, nil
+}
+
+// WatchTree decrypts the children, and the ones failing to be decrypted are dropped
+func (s *Store) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*kv.KeyValue, error) {
+	changes, err := s.store.WatchTree(prefix, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, changes, stopCh, func(pairs []*kv.KeyValue) ([]*kv.KeyValue, bool) {
+			decrypted := make([]*kv.KeyValue, 0, len(pairs))
+			for _, pair := range pairs {
+				d, err := s.decrypt(pair)
+				if err != nil {
+					log.Warn("Failed to decrypt the value in WatchTree", "key", pair.Key, "err", err)
+					continue
+				}
+				decrypted = append(decrypted, d)
+			}
+			return decrypted, true
+		})
+	}()
+	return watchCh, nil
+}
+
+// WatchEvents decrypts the values of the puts, and the ones failing to be decrypted are dropped
+func (s *Store) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	events, err := s.store.WatchEvents(prefix, stopCh, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *kv.Event)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, events, stopCh, func(ev *kv.Event) (*kv.Event, bool) {
+			if ev.Type != kv.EventPut {
+				return ev, true
+			}
+			d, err := s.decrypt(ev.KV)
+			if err != nil {
+				log.Warn("Failed to decrypt the value in WatchEvents", "key", ev.KV.Key, "err", err)
+				return nil, false
+			}
+			e := *ev
+			e.KV = d
+			return &e, true
+		})
+	}()
+	return watchCh, nil
+}
+
+// Lock locks the given key, and the value of the lock is encrypted since it's able to be read by Get
+func (s *Store) Lock(key string, opts ...kv.LockOption) (kv.Locker, error) {
+	o := &kv.LockOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Value != nil {
+		envelope, err := seal(s.provider, kv.Normalize(key), o.Value)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts[:len(opts):len(opts)], kv.LockValue(envelope))
+	}
+	return s.store.Lock(key, opts...)
+}
+
+// Semaphore creates a semaphore of the key if the backend supports it
+func (s *Store) Semaphore(key string, limit int, opts ...kv.SemaphoreOption) (kv.Semaphore, error) {
+	return kv.NewSemaphore(s.store, key, limit, opts...)
+}
+
+// RateLimiter creates a rate limiter of the key if the backend supports it
+func (s *Store) RateLimiter(key string, rate kv.RateLimit) (kv.RateLimiter, error) {
+	return kv.NewRateLimiter(s.store, key, rate)
+}
+
+// TTL returns the remaining TTL of the key if the backend supports it
+func (s *Store) TTL(key string) (time.Duration, error) {
+	return kv.TTL(s.store, key)
+}
+
+// TTLs returns the remaining TTLs of the keys if the backend supports it
+func (s *Store) TTLs(keys []string) (map[string]time.Duration, error) {
+	return kv.TTLs(s.store, keys)
+}
+
+// Close waits for the re-encryptions and closes the underlying store
+func (s *Store) Close() {
+	s.wg.Wait()
+	s.store.Close()
+}
+
+// decrypt returns a copy of the pair with the decrypted value
+func (s *Store) decrypt(pair *kv.KeyValue) (*kv.KeyValue, error) {
+	value, id, err := open(s.provider, kv.Normalize(pair.Key), pair.Value)
+	if err != nil {
+		return nil, err
+	}
+	if s.reencrypt {
+		s.rotate(pair, value, id)
+	}
+	return withValue(pair, value), nil
+}
+
+func (s *Store) decryptAll(pairs []*kv.KeyValue) ([]*kv.KeyValue, error) {
+	decrypted := make([]*kv.KeyValue, len(pairs))
+	for i, pair := range pairs {
+		d, err := s.decrypt(pair)
+		if err != nil {
+			return nil, err
+		}
+		decrypted[i] = d
+	}
+	return decrypted, nil
+}
+
+// rotate re-encrypts the value by the current key in the background if it's
+// encrypted by another one. The value is only rewritten if it's not changed.
+// The expiring values, including the locks, are left to be rewritten by their
+// writers, since the rewrite can't keep the TTL or the revision they hold.
+func (s *Store) rotate(pair *kv.KeyValue, value []byte, id string) {
+	current, _, err := s.provider.CurrentKey()
+	if err != nil || current == id {
+		return
+	}
+
+	nKey := kv.Normalize(pair.Key)
+	s.mu.Lock()
+	if _, ok := s.inflight[nKey]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.inflight[nKey] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	expected := *pair
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.inflight, nKey)
+			s.mu.Unlock()
+		}()
+
+		ttl, err := kv.TTL(s.store, expected.Key)
+		if err != nil || ttl > 0 {
+			return
+		}
+
+		envelope, err := seal(s.provider, nKey, value)
+		if err != nil {
+			log.Warn("Failed to re-encrypt the value", "key", expected.Key, "err", err)
+			return
+		}
+		_, err = s.store.AtomicPut(expected.Key, envelope, &expected)
+		if err != nil && err != kv.ErrKeyModified && err != kv.ErrKeyNotFound {
+			log.Warn("Failed to re-encrypt the value", "key", expected.Key, "err", err)
+		}
+	}()
+}
+
+func withValue(pair *kv.KeyValue, value []byte) *kv.KeyValue {
+	p := *pair
+	p.Value = value
+	return &p
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	testutils "github.com/getamis/sirius/kv/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncrypt(t *testing.T) {
+	provider, err := NewStaticProvider("v1", map[string][]byte{"v1": oldKey})
+	assert.NoError(t, err, "should be no error")
+	s := New(kv.New(), provider)
+
+	testutils.RunAll(t, s)
+}
+
+func TestEncryptEnvelope(t *testing.T) {
+	store := kv.New()
+	provider, err := NewStaticProvider("v1", map[string][]byte{"v1": oldKey})
+	assert.NoError(t, err, "should be no error")
+	s := New(store, provider)
+
+	key := "testEncrypt/key"
+	assert.NoError(t, s.Put(key, []byte("secret")), "should be no error")
+	raw, err := store.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.False(t, bytes.Contains(raw.Value, []byte("secret")), "should be false")
+
+	pair, err := s.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("secret"), pair.Value, "should be equal")
+
+	// the envelope is bound to the key
+	assert.NoError(t, store.Put("testEncrypt/other", raw.Value), "should be no error")
+	_, err = s.Get("testEncrypt/other")
+	assert.Equal(t, ErrInvalidEnvelope, err, "should be equal")
+
+	// the plaintext is not an envelope
+	assert.NoError(t, store.Put("testEncrypt/plain", []byte("secret")), "should be no error")
+	_, err = s.Get("testEncrypt/plain")
+	assert.Equal(t, ErrInvalidEnvelope, err, "should be equal")
+
+	// the key is unknown
+	provider, err = NewStaticProvider("v2", map[string][]byte{"v2": newKey})
+	assert.NoError(t, err, "should be no error")
+	_, err = New(store, provider).Get(key)
+	assert.Equal(t, ErrUnknownKeyID, err, "should be equal")
+}
+
+func TestEncryptRotation(t *testing.T) {
+	store := kv.New()
+	old, err := NewStaticProvider("v1", map[string][]byte{"v1": oldKey})
+	assert.NoError(t, err, "should be no error")
+	key := "testEncryptRotation/key"
+	assert.NoError(t, New(store, old).Put(key, []byte("secret")), "should be no error")
+
+	rotated, err := NewStaticProvider("v2", map[string][]byte{"v1": oldKey, "v2": newKey})
+	assert.NoError(t, err, "should be no error")
+	s := New(store, rotated)
+	pair, err := s.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("secret"), pair.Value, "should be equal")
+
+	// the value is re-encrypted by the current key in the background
+	var id string
+	for i := 0; i < 50; i++ {
+		raw, err := store.Get(key)
+		assert.NoError(t, err, "should be no error")
+		if _, id, err = open(rotated, kv.Normalize(key), raw.Value); err == nil && id == "v2" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, "v2", id, "should be equal")
+
+	pair, err = New(store, rotated, Reencrypt(false)).Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("secret"), pair.Value, "should be equal")
+
+	// the expiring values are not rewritten, so they don't become permanent
+	expiring := "testEncryptRotation/expiring"
+	assert.NoError(t, New(store, old).Put(expiring, []byte("secret"), kv.PutExpiration(time.Minute)), "should be no error")
+	before, err := store.Get(expiring)
+	assert.NoError(t, err, "should be no error")
+	pair, err = s.Get(expiring)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("secret"), pair.Value, "should be equal")
+	s.wg.Wait()
+	after, err := store.Get(expiring)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, before.Revision, after.Revision, "should be equal")
+	ttl, err := kv.TTL(store, expiring)
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, ttl > 0, "should be true")
+}
+
+func TestStaticProvider(t *testing.T) {
+	_, err := NewStaticProvider("v1", map[string][]byte{"v2": oldKey})
+	assert.Equal(t, ErrUnknownKeyID, err, "should be equal")
+	_, err = NewStaticProvider("v1", map[string][]byte{"v1": []byte("short")})
+	assert.Equal(t, ErrInvalidKey, err, "should be equal")
+
+	provider, err := NewStaticProvider("v1", map[string][]byte{"v1": oldKey})
+	assert.NoError(t, err, "should be no error")
+	id, key, err := provider.CurrentKey()
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "v1", id, "should be equal")
+	assert.Equal(t, oldKey, key, "should be equal")
+	_, err = provider.Key("v2")
+	assert.Equal(t, ErrUnknownKeyID, err, "should be equal")
+}
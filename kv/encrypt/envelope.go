@@ -0,0 +1,97 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// ErrInvalidEnvelope is returned when the value is not an envelope or it fails to be authenticated
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// envelope: version (1 byte) | key id length (1 byte) | key id | nonce | AES-GCM ciphertext
+// The normalized key of the value is authenticated as the additional data, so an
+// envelope isn't able to be moved to another key.
+const (
+	envelopeVersion = 1
+	maxKeyIDLength  = 255
+)
+
+// seal encrypts the value of the key by the current key of the provider
+func seal(provider KeyProvider, key string, value []byte) ([]byte, error) {
+	id, k, err := provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) == 0 || len(id) > maxKeyIDLength {
+		return nil, ErrUnknownKeyID
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 2+len(id)+aead.NonceSize()+len(value)+aead.Overhead())
+	header = append(header, envelopeVersion, byte(len(id)))
+	header = append(header, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, value, []byte(key)), nil
+}
+
+// open decrypts the envelope of the key, and returns the ID of the key which encrypts it
+func open(provider KeyProvider, key string, envelope []byte) ([]byte, string, error) {
+	if len(envelope) < 2 || envelope[0] != envelopeVersion {
+		return nil, "", ErrInvalidEnvelope
+	}
+	idLen := int(envelope[1])
+	if len(envelope) < 2+idLen {
+		return nil, "", ErrInvalidEnvelope
+	}
+	id := string(envelope[2 : 2+idLen])
+	k, err := provider.Key(id)
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data := envelope[2+idLen:]
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, "", ErrInvalidEnvelope
+	}
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, "", ErrInvalidEnvelope
+	}
+	return value, id, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,27 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+type Option func(s *Store)
+
+// Reencrypt returns an option to enable or disable re-encrypting the values read
+// with a previous key, which is enabled by default.
+// The value is rewritten without a TTL, so it should be disabled if the values
+// are put with PutExpiration.
+func Reencrypt(enabled bool) Option {
+	return func(s *Store) {
+		s.reencrypt = enabled
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import "errors"
+
+var (
+	// ErrUnknownKeyID is returned when the provider doesn't have the key of the ID
+	ErrUnknownKeyID = errors.New("unknown key id")
+	// ErrInvalidKey is returned when the key is not an AES-128, AES-192 or AES-256 key
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// KeyProvider provides the keys of the envelope.
+// Every value is encrypted by the current key and the ID of the key is kept in
+// the envelope, so the keys are rotated by changing the current one while the
+// previous ones are still provided to decrypt the existing values.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key to encrypt the values
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key of the ID to decrypt the values
+	Key(id string) ([]byte, error)
+}
+
+// NewStaticProvider returns the provider of the given keys indexed by the IDs,
+// and the values are encrypted by the key of current
+func NewStaticProvider(current string, keys map[string][]byte) (KeyProvider, error) {
+	if len(current) == 0 || len(current) > maxKeyIDLength {
+		return nil, ErrUnknownKeyID
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	p := &staticProvider{
+		current: current,
+		keys:    make(map[string][]byte, len(keys)),
+	}
+	for id, key := range keys {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, ErrInvalidKey
+		}
+		p.keys[id] = append([]byte(nil), key...)
+	}
+	return p, nil
+}
+
+type staticProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (p *staticProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *staticProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
@@ -0,0 +1,863 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/log"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	noExpiration          = time.Duration(0)
+	defaultLockTTL        = 60 * time.Second
+	defaultRequestTimeout = 10 * time.Second
+)
+
+// New creates a new etcd v3 client given a list
+// of endpoints and optional config
+func New(endpoints []string, options ...EtcdOption) (*Etcd, error) {
+	cfg := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Etcd{
+		client: client,
+	}, nil
+}
+
+// Etcd implements kv.Store interface with etcd v3 backend.
+// The revision of a key is its etcd mod revision.
+type Etcd struct {
+	client *clientv3.Client
+}
+
+// Put a value at the specified key
+func (e *Etcd) Put(key string, value []byte, options ...store.PutOption) error {
+	pOption := &store.PutOptions{}
+	for _, option := range options {
+		option(pOption)
+	}
+
+	ctx, cancel := e.context()
+	defer cancel()
+
+	lease, opts, err := e.putOptions(ctx, pOption.TTL)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Put(ctx, normalize(key), string(value), opts...)
+	if err != nil {
+		e.revoke(lease)
+	}
+	return err
+}
+
+// putOptions grants a lease for the key if ttl is given, the returned lease is
+// clientv3.NoLease otherwise
+func (e *Etcd) putOptions(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, []clientv3.OpOption, error) {
+	if ttl == noExpiration {
+		return clientv3.NoLease, nil, nil
+	}
+
+	lease, err := e.client.Grant(ctx, formatSec(ttl))
+	if err != nil {
+		return clientv3.NoLease, nil, err
+	}
+	return lease.ID, []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// revoke revokes the leases granted for the writes which are not applied,
+// otherwise they are kept until they expire
+func (e *Etcd) revoke(leases ...clientv3.LeaseID) {
+	for _, lease := range leases {
+		if lease == clientv3.NoLease {
+			continue
+		}
+		// the context of the write may be done already
+		ctx, cancel := e.context()
+		_, err := e.client.Revoke(ctx, lease)
+		cancel()
+		if err != nil {
+			log.Debug("Failed to revoke lease", "lease", lease, "err", err)
+		}
+	}
+}
+
+// Get a value given its key
+func (e *Etcd) Get(key string) (*store.KeyValue, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, normalize(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return toKeyValue(resp.Kvs[0]), nil
+}
+
+// List the content of a given prefix
+func (e *Etcd) List(directory string) ([]*store.KeyValue, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	return e.list(ctx, normalize(directory))
+}
+
+func (e *Etcd) list(ctx context.Context, directory string) ([]*store.KeyValue, error) {
+	resp, err := e.client.Get(ctx, directory, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+
+	pairs := []*store.KeyValue{}
+	for _, pair := range resp.Kvs {
+		if string(pair.Key) != directory {
+			pairs = append(pairs, toKeyValue(pair))
+		}
+	}
+	return pairs, nil
+}
+
+// ListPage lists a page of the content of a given prefix in the order of the keys.
+// The cursor is the last key of the page.
+func (e *Etcd) ListPage(directory string, options ...store.ListOption) ([]*store.KeyValue, string, error) {
+	lOption := store.NewListOptions(options...)
+
+	ctx, cancel := e.context()
+	defer cancel()
+
+	nKey := normalize(directory)
+	from := nKey
+	if lOption.Cursor > nKey {
+		from = lOption.Cursor + "\x00"
+	}
+	resp, err := e.client.Get(ctx, from,
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(nKey)),
+		clientv3.WithLimit(int64(lOption.Limit)),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pairs := []*store.KeyValue{}
+	for _, pair := range resp.Kvs {
+		if string(pair.Key) != nKey {
+			pairs = append(pairs, toKeyValue(pair))
+		}
+	}
+	cursor := ""
+	if resp.More && len(resp.Kvs) > 0 {
+		cursor = string(resp.Kvs[len(resp.Kvs)-1].Key)
+	}
+	return pairs, cursor, nil
+}
+
+// Delete the value at the specified key
+func (e *Etcd) Delete(key string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Delete(ctx, normalize(key))
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// Exists verify if a Key exists in the store
+func (e *Etcd) Exists(key string) (bool, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, normalize(key), clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// TTL returns the remaining TTL of the lease of the key, which is 0 if the key has no lease
+func (e *Etcd) TTL(key string) (time.Duration, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, normalize(key))
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, store.ErrKeyNotFound
+	}
+	lease := clientv3.LeaseID(resp.Kvs[0].Lease)
+	if lease == clientv3.NoLease {
+		return 0, nil
+	}
+
+	ttl, err := e.client.TimeToLive(ctx, lease)
+	if err != nil {
+		return 0, err
+	}
+	if ttl.TTL < 0 {
+		// the lease is expired, and so is the key
+		return 0, store.ErrKeyNotFound
+	}
+	return time.Duration(ttl.TTL) * time.Second, nil
+}
+
+// AtomicPut is an atomic CAS operation on a single value.
+// Pass previous = nil to create a new key.
+func (e *Etcd) AtomicPut(key string, value []byte, previous *store.KeyValue, options ...store.PutOption) (*store.KeyValue, error) {
+	pOption := &store.PutOptions{}
+	for _, option := range options {
+		option(pOption)
+	}
+
+	ctx, cancel := e.context()
+	defer cancel()
+
+	lease, opts, err := e.putOptions(ctx, pOption.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	nKey := normalize(key)
+	var cmp clientv3.Cmp
+	if previous == nil {
+		cmp = clientv3.Compare(clientv3.CreateRevision(nKey), "=", 0)
+	} else {
+		cmp = clientv3.Compare(clientv3.ModRevision(nKey), "=", int64(previous.Revision))
+	}
+
+	resp, err := e.client.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(nKey, string(value), opts...)).
+		Else(clientv3.OpGet(nKey, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		e.revoke(lease)
+		return nil, err
+	}
+	if !resp.Succeeded {
+		e.revoke(lease)
+		if previous == nil {
+			return nil, store.ErrKeyExists
+		}
+		if resp.Responses[0].GetResponseRange().Count == 0 {
+			return nil, store.ErrKeyNotFound
+		}
+		return nil, store.ErrKeyModified
+	}
+
+	return &store.KeyValue{
+		Key:      key,
+		Value:    value,
+		Revision: uint64(resp.Header.Revision),
+	}, nil
+}
+
+// AtomicDelete is an atomic delete operation on a single value
+// the value will be deleted if previous matched the one stored in db
+func (e *Etcd) AtomicDelete(key string, previous *store.KeyValue) (bool, error) {
+	if previous == nil {
+		return false, store.ErrKeyModified
+	}
+
+	ctx, cancel := e.context()
+	defer cancel()
+
+	nKey := normalize(key)
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(nKey), "=", int64(previous.Revision))).
+		Then(clientv3.OpDelete(nKey)).
+		Else(clientv3.OpGet(nKey, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		if resp.Responses[0].GetResponseRange().Count == 0 {
+			return false, store.ErrKeyNotFound
+		}
+		return false, store.ErrKeyModified
+	}
+	return true, nil
+}
+
+// DeleteTree deletes a range of keys under a given directory
+func (e *Etcd) DeleteTree(directory string) error {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	resp, err := e.client.Delete(ctx, normalize(directory), clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// Txn applies the ops in an etcd transaction if all the compares hold
+func (e *Etcd) Txn(compares []store.Compare, ops []store.Op) ([]*store.KeyValue, error) {
+	ctx, cancel := e.context()
+	defer cancel()
+
+	cmps := make([]clientv3.Cmp, len(compares))
+	gets := make([]clientv3.Op, len(compares))
+	for i, cmp := range compares {
+		nKey := normalize(cmp.Key)
+		if cmp.Revision == 0 {
+			cmps[i] = clientv3.Compare(clientv3.CreateRevision(nKey), "=", 0)
+		} else {
+			cmps[i] = clientv3.Compare(clientv3.ModRevision(nKey), "=", int64(cmp.Revision))
+		}
+		gets[i] = clientv3.OpGet(nKey)
+	}
+
+	thens := make([]clientv3.Op, len(ops))
+	var leases []clientv3.LeaseID
+	for i, op := range ops {
+		nKey := normalize(op.Key)
+		switch op.Type {
+		case store.OpPut:
+			lease, opts, err := e.putOptions(ctx, op.TTL)
+			if err != nil {
+				e.revoke(leases...)
+				return nil, err
+			}
+			leases = append(leases, lease)
+			thens[i] = clientv3.OpPut(nKey, string(op.Value), opts...)
+		case store.OpDelete:
+			thens[i] = clientv3.OpDelete(nKey)
+		default:
+			e.revoke(leases...)
+			return nil, store.ErrNotSupported
+		}
+	}
+
+	resp, err := e.client.Txn(ctx).If(cmps...).Then(thens...).Else(gets...).Commit()
+	if err != nil {
+		e.revoke(leases...)
+		return nil, err
+	}
+	if !resp.Succeeded {
+		e.revoke(leases...)
+		return nil, compareError(compares, resp.Responses)
+	}
+
+	results := make([]*store.KeyValue, len(ops))
+	for i, op := range ops {
+		if op.Type == store.OpPut {
+			results[i] = &store.KeyValue{
+				Key:      op.Key,
+				Value:    op.Value,
+				Revision: uint64(resp.Header.Revision),
+			}
+		}
+	}
+	return results, nil
+}
+
+// compareError finds the first failed compare by the current values
+func compareError(compares []store.Compare, responses []*etcdserverpb.ResponseOp) error {
+	for i, cmp := range compares {
+		kvs := responses[i].GetResponseRange().Kvs
+		if cmp.Revision == 0 {
+			if len(kvs) > 0 {
+				return store.ErrKeyExists
+			}
+			continue
+		}
+		if len(kvs) == 0 {
+			return store.ErrKeyNotFound
+		}
+		if uint64(kvs[0].ModRevision) != cmp.Revision {
+			return store.ErrKeyModified
+		}
+	}
+	return store.ErrKeyModified
+}
+
+// Watch for changes on a key.
+// The current value is delivered first if the key exists, deletions are not delivered.
+func (e *Etcd) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KeyValue, error) {
+	nKey := normalize(key)
+	ctx, cancel := e.watchContext(stopCh)
+
+	resp, err := e.client.Get(ctx, nKey)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// start watching right after the revision we read, so no changes are missed
+	events := e.client.Watch(ctx, nKey,
+		clientv3.WithRev(resp.Header.Revision+1),
+		clientv3.WithFilterDelete(),
+	)
+
+	watchCh := make(chan *store.KeyValue)
+	go func() {
+		defer cancel()
+		defer close(watchCh)
+
+		push := func(pair *store.KeyValue) bool {
+			select {
+			case watchCh <- pair:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if len(resp.Kvs) > 0 && !push(toKeyValue(resp.Kvs[0])) {
+			return
+		}
+		for wresp := range events {
+			if err := wresp.Err(); err != nil {
+				log.Info("watch in Watch", "err", err)
+				return
+			}
+			for _, ev := range wresp.Events {
+				if !push(toKeyValue(ev.Kv)) {
+					return
+				}
+			}
+		}
+	}()
+
+	return watchCh, nil
+}
+
+// WatchTree watches for changes on child nodes under
+// a given directory. Each change delivers the whole list of children.
+func (e *Etcd) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KeyValue, error) {
+	nKey := normalize(directory)
+	ctx, cancel := e.watchContext(stopCh)
+
+	resp, err := e.client.Get(ctx, nKey, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	events := e.client.Watch(ctx, nKey,
+		clientv3.WithPrefix(),
+		clientv3.WithRev(resp.Header.Revision+1),
+	)
+
+	watchCh := make(chan []*store.KeyValue)
+	go func() {
+		defer cancel()
+		defer close(watchCh)
+
+		push := func() bool {
+			pairs, err := e.list(ctx, nKey)
+			if err == store.ErrKeyNotFound {
+				pairs = []*store.KeyValue{}
+			} else if err != nil {
+				log.Info("list in WatchTree", "err", err)
+				return false
+			}
+
+			select {
+			case watchCh <- pairs:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !push() {
+			return
+		}
+		for wresp := range events {
+			if err := wresp.Err(); err != nil {
+				log.Info("watch in WatchTree", "err", err)
+				return
+			}
+			if !push() {
+				return
+			}
+		}
+	}()
+
+	return watchCh, nil
+}
+
+// WatchEvents watches the puts and deletes of the keys with given prefix.
+// A key deleted by its lease is not told apart from a delete, so EventExpire
+// is never delivered by etcd.
+func (e *Etcd) WatchEvents(directory string, stopCh <-chan struct{}, options ...store.WatchOption) (<-chan *store.Event, error) {
+	wOption := &store.WatchOptions{}
+	for _, option := range options {
+		option(wOption)
+	}
+
+	nKey := normalize(directory)
+	ctx, cancel := e.watchContext(stopCh)
+
+	rev := int64(wOption.FromRevision)
+	if rev == 0 {
+		resp, err := e.client.Get(ctx, nKey, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		rev = resp.Header.Revision + 1
+	} else {
+		// find out the compaction before watching, since the watch fails asynchronously
+		_, err := e.client.Get(ctx, nKey, clientv3.WithRev(rev), clientv3.WithCountOnly())
+		if err == rpctypes.ErrCompacted {
+			cancel()
+			return nil, store.ErrCompacted
+		} else if err != nil && err != rpctypes.ErrFutureRev {
+			cancel()
+			return nil, err
+		}
+	}
+	events := e.client.Watch(ctx, nKey,
+		clientv3.WithPrefix(),
+		clientv3.WithRev(rev),
+		clientv3.WithPrevKV(),
+	)
+
+	watchCh := make(chan *store.Event)
+	go func() {
+		defer cancel()
+		defer close(watchCh)
+
+		for wresp := range events {
+			if err := wresp.Err(); err != nil {
+				log.Info("watch in WatchEvents", "err", err)
+				return
+			}
+			for _, ev := range wresp.Events {
+				select {
+				case watchCh <- toEvent(ev):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return watchCh, nil
+}
+
+// Lock creates a lock for a given key.
+// The returned Locker is not held and must be acquired
+// with `.Lock`. The Value is optional.
+func (e *Etcd) Lock(key string, options ...store.LockOption) (store.Locker, error) {
+	lOption := &store.LockOptions{}
+	for _, option := range options {
+		option(lOption)
+	}
+
+	ttl := defaultLockTTL
+	if lOption.TTL != noExpiration {
+		ttl = lOption.TTL
+	}
+
+	return &etcdLock{
+		etcd:    e,
+		key:     normalize(key),
+		value:   lOption.Value,
+		ttl:     ttl,
+		renewCh: lOption.RenewLock,
+	}, nil
+}
+
+// Close the store connection
+func (e *Etcd) Close() {
+	e.client.Close()
+}
+
+// context returns the context used by a single request
+func (e *Etcd) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(e.client.Ctx(), defaultRequestTimeout)
+}
+
+// watchContext returns a context which is canceled when stopCh is closed
+// or the client is closed
+func (e *Etcd) watchContext(stopCh <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(e.client.Ctx())
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// ----------------------------------------------------------------------------
+
+// etcdLock holds the key with a lease which is kept alive until
+// the lock is released, the RenewLock channel is closed or the client is closed
+type etcdLock struct {
+	etcd    *Etcd
+	key     string
+	value   []byte
+	ttl     time.Duration
+	renewCh chan struct{}
+
+	mu     sync.Mutex
+	lease  clientv3.LeaseID
+	cancel context.CancelFunc
+	token  uint64
+	err    error
+}
+
+func (l *etcdLock) Lock(stopCh chan struct{}) (<-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(l.etcd.client.Ctx())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		lockHeld, revision, err := l.tryLock(ctx, stopCh)
+		if ctx.Err() != nil {
+			return nil, store.ErrUnableToLock
+		}
+		if err != nil {
+			return nil, err
+		}
+		if lockHeld != nil {
+			return lockHeld, nil
+		}
+
+		if err := l.waitDelete(ctx, revision); err != nil {
+			if ctx.Err() != nil {
+				return nil, store.ErrUnableToLock
+			}
+			return nil, err
+		}
+	}
+}
+
+// waitDelete waits until the key is deleted by the holder or its lease is expired
+func (l *etcdLock) waitDelete(ctx context.Context, revision int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := l.etcd.client.Watch(ctx, l.key,
+		clientv3.WithRev(revision+1),
+		clientv3.WithFilterPut(),
+	)
+	for wresp := range events {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		if len(wresp.Events) > 0 {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// tryLock returns the lockHeld channel if the lock is acquired. Otherwise,
+// it returns the revision at which the lock is held by someone else.
+func (l *etcdLock) tryLock(ctx context.Context, stopCh chan struct{}) (<-chan struct{}, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lease, err := l.etcd.client.Grant(ctx, formatSec(l.ttl))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := l.etcd.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(l.key), "=", 0)).
+		Then(clientv3.OpPut(l.key, string(l.value), clientv3.WithLease(lease.ID))).
+		Commit()
+	if err == nil && !resp.Succeeded {
+		return nil, resp.Header.Revision, l.revoke(lease.ID)
+	}
+	if err != nil {
+		l.revoke(lease.ID)
+		return nil, 0, err
+	}
+
+	keepCtx, cancel := context.WithCancel(l.etcd.client.Ctx())
+	keepAlive, err := l.etcd.client.KeepAlive(keepCtx, lease.ID)
+	if err != nil {
+		cancel()
+		l.revoke(lease.ID)
+		return nil, 0, err
+	}
+
+	l.lease = lease.ID
+	l.cancel = cancel
+	l.token = uint64(resp.Header.Revision)
+	l.err = nil
+	lockHeld := make(chan struct{})
+	go l.holdLock(keepCtx, cancel, keepAlive, lockHeld, stopCh)
+	return lockHeld, resp.Header.Revision, nil
+}
+
+func (l *etcdLock) holdLock(ctx context.Context, cancel context.CancelFunc, keepAlive <-chan *clientv3.LeaseKeepAliveResponse, lockHeld, stopCh chan struct{}) {
+	defer close(lockHeld)
+	defer cancel()
+
+	for {
+		select {
+		case _, ok := <-keepAlive:
+			if !ok {
+				// the lease is lost or the client is closed
+				l.lost(ctx)
+				return
+			}
+		case <-ctx.Done():
+			l.lost(ctx)
+			return
+		case <-stopCh:
+			return
+		case <-l.renewCh:
+			return
+		}
+	}
+}
+
+// lost records why the lock is lost unless it's unlocked
+func (l *etcdLock) lost(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.etcd.client.Ctx().Err() != nil {
+		l.err = store.ErrLockUnreachable
+	} else if ctx.Err() == nil {
+		l.err = store.ErrLockExpired
+	}
+}
+
+func (l *etcdLock) Token() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.token
+}
+
+func (l *etcdLock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+func (l *etcdLock) revoke(lease clientv3.LeaseID) error {
+	ctx, cancel := l.etcd.context()
+	defer cancel()
+
+	_, err := l.etcd.client.Revoke(ctx, lease)
+	return err
+}
+
+// Unlock revokes the lease of the lock, so the key is deleted as well
+func (l *etcdLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cancel != nil {
+		l.cancel()
+		l.cancel = nil
+	}
+	if l.lease == clientv3.NoLease {
+		return store.ErrKeyNotFound
+	}
+
+	err := l.revoke(l.lease)
+	l.lease = clientv3.NoLease
+	l.err = nil
+	if err == rpctypes.ErrLeaseNotFound {
+		return store.ErrKeyNotFound
+	}
+	return err
+}
+
+// ----------------------------------------------------------------------------
+
+func normalize(key string) string {
+	return store.Normalize(key)
+}
+
+// denormalize restores the key which is given by the user
+func denormalize(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func toKeyValue(pair *mvccpb.KeyValue) *store.KeyValue {
+	return &store.KeyValue{
+		Key:      denormalize(string(pair.Key)),
+		Value:    pair.Value,
+		Revision: uint64(pair.ModRevision),
+	}
+}
+
+func toEvent(ev *clientv3.Event) *store.Event {
+	event := &store.Event{
+		Type: store.EventPut,
+		KV:   toKeyValue(ev.Kv),
+	}
+	if ev.Type == clientv3.EventTypeDelete {
+		event.Type = store.EventDelete
+		event.KV.Value = nil
+	}
+	if ev.PrevKv != nil {
+		event.PrevRevision = uint64(ev.PrevKv.ModRevision)
+	}
+	return event
+}
+
+// formatSec returns the lease ttl in seconds, at least one second
+func formatSec(dur time.Duration) int64 {
+	sec := int64((dur + time.Second - 1) / time.Second)
+	if sec < 1 {
+		sec = 1
+	}
+	return sec
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"testing"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+	testutils "github.com/getamis/sirius/kv/test"
+	"github.com/getamis/sirius/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeEtcdClient(t *testing.T, endpoint string) store.Store {
+	kv, err := New([]string{endpoint})
+	assert.NoError(t, err)
+	return kv
+}
+
+func TestEtcdStore(t *testing.T) {
+	container, err := test.NewEtcdContainer()
+	assert.NoError(t, err)
+	assert.NoError(t, container.Start())
+	defer container.Stop()
+	kv := makeEtcdClient(t, container.URL)
+	lockTTL := makeEtcdClient(t, container.URL)
+	kvTTL := makeEtcdClient(t, container.URL)
+
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestListPage(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestRevision(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestWatchEvents(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestContext(t, store.NewContextStore(kv))
+	testutils.RunTestRemainingTTL(t, kv)
+	testutils.RunTestLockTTL(t, kv, lockTTL)
+	testutils.RunTestTTL(t, kv, kvTTL)
+	testLeaseRevoked(t, kv.(*Etcd))
+	testutils.RunCleanup(t, kv)
+}
+
+func testLeaseRevoked(t *testing.T, kv *Etcd) {
+	key := "testLeaseRevoked"
+	assert.NoError(t, kv.Put(key, []byte("foo")), "should be no error")
+	ctx, cancel := kv.context()
+	defer cancel()
+	before, err := kv.client.Leases(ctx)
+	assert.NoError(t, err, "should be no error")
+
+	// the leases of the failed writes are revoked
+	_, err = kv.AtomicPut(key, []byte("bar"), nil, store.PutExpiration(time.Minute))
+	assert.Equal(t, store.ErrKeyExists, err, "should be equal")
+	_, err = kv.Txn(
+		[]store.Compare{store.CompareNotExist(key)},
+		[]store.Op{{Type: store.OpPut, Key: key, Value: []byte("bar"), TTL: time.Minute}},
+	)
+	assert.Equal(t, store.ErrKeyExists, err, "should be equal")
+
+	after, err := kv.client.Leases(ctx)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, len(before.Leases), len(after.Leases), "should be equal")
+	assert.NoError(t, kv.Delete(key), "should be no error")
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/tls"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type EtcdOption func(*clientv3.Config)
+
+func DialTimeout(t time.Duration) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.DialTimeout = t
+	}
+}
+
+func Username(username string) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.Username = username
+	}
+}
+
+func Password(pwd string) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.Password = pwd
+	}
+}
+
+func TLSConfig(t *tls.Config) EtcdOption {
+	return func(c *clientv3.Config) {
+		c.TLS = t
+	}
+}
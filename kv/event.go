@@ -0,0 +1,49 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+// EventType is the type of a change of a key
+type EventType int
+
+const (
+	// EventPut is a creation or an update of a key
+	EventPut EventType = iota
+	// EventDelete is a deletion of a key
+	EventDelete
+	// EventExpire is a deletion of a key because its TTL is reached
+	EventExpire
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	}
+	return "unknown"
+}
+
+// Event is a change of a key.
+// KV holds the new value of a put, and only the key for a delete or an expiration.
+// KV.Revision is the revision of the change, and PrevRevision is the revision of
+// the key before the change, which is 0 if the key is created.
+type Event struct {
+	Type         EventType
+	KV           *KeyValue
+	PrevRevision uint64
+}
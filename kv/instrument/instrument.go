@@ -0,0 +1,359 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrument
+
+import (
+	"sync"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/internal/watch"
+	"github.com/getamis/sirius/log"
+	"github.com/getamis/sirius/metrics"
+)
+
+const (
+	opPut          = "put"
+	opAtomicPut    = "atomic_put"
+	opGet          = "get"
+	opList         = "list"
+	opListPage     = "list_page"
+	opDelete       = "delete"
+	opAtomicDelete = "atomic_delete"
+	opDeleteTree   = "delete_tree"
+	opTxn          = "txn"
+	opExists       = "exists"
+	opWatch        = "watch"
+	opWatchTree    = "watch_tree"
+	opWatchEvents  = "watch_events"
+	opLock         = "lock"
+	opUnlock       = "unlock"
+)
+
+var operations = []string{
+	opPut, opAtomicPut, opGet, opList, opListPage, opDelete, opAtomicDelete, opDeleteTree,
+	opTxn, opExists, opWatch, opWatchTree, opWatchEvents, opLock, opUnlock,
+}
+
+// errorLabels are the labels of the errors counter, the other errors are labeled as "other"
+var errorLabels = map[error]string{
+	kv.ErrNotSupported:    "not_supported",
+	kv.ErrKeyModified:     "key_modified",
+	kv.ErrKeyNotFound:     "key_not_found",
+	kv.ErrKeyExists:       "key_exists",
+	kv.ErrUnableToLock:    "unable_to_lock",
+	kv.ErrCompacted:       "compacted",
+	kv.ErrLockExpired:     "lock_expired",
+	kv.ErrLockStolen:      "lock_stolen",
+	kv.ErrLockUnreachable: "lock_unreachable",
+}
+
+// Store is a kv.Store which records the metrics and the debug logs of the operations
+// of the underlying store:
+//   - kv_operation_seconds: the latency histograms labeled by the operation
+//   - kv_errors: the error counters labeled by the operation and the error
+//   - kv_watchers: the gauges of the running watches of the stores of the registry labeled by the operation
+//   - kv_lock_hold_seconds: the histogram of how long the locks are held
+type Store struct {
+	store kv.Store
+
+	registry    metrics.Registry
+	metricsOpts []metrics.Option
+	logger      log.Logger
+
+	latencies map[string]metrics.Histogram
+	errors    metrics.CounterVec
+	watchers  map[string]metrics.Gauge
+	lockHold  metrics.Histogram
+}
+
+// watcherCounts are the numbers of the running watches of the gauges in the process.
+// A gauge is shared by the stores of the same registry and it's only able to be set,
+// so the watches are counted per gauge instead of per store.
+var (
+	watcherMu     sync.Mutex
+	watcherCounts = make(map[metrics.Gauge]int64)
+)
+
+func addWatchers(gauge metrics.Gauge, delta int64) {
+	watcherMu.Lock()
+	defer watcherMu.Unlock()
+
+	watcherCounts[gauge] += delta
+	gauge.Set(float64(watcherCounts[gauge]))
+}
+
+// New wraps the store with the metrics and the debug logs
+func New(store kv.Store, options ...Option) *Store {
+	s := &Store{
+		store:    store,
+		registry: metrics.DefaultRegistry,
+		logger:   log.New("pkg", "kv"),
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	latencies := s.registry.NewHistogramVec("kv_operation_seconds", []string{"operation"}, s.metricsOpts...)
+	s.latencies = make(map[string]metrics.Histogram, len(operations))
+	for _, op := range operations {
+		s.latencies[op], _ = latencies.GetMetricWithLabelValues(op)
+	}
+	s.errors = s.registry.NewCounterVec("kv_errors", []string{"operation", "error"}, s.metricsOpts...)
+	gauges := s.registry.NewGaugeVec("kv_watchers", []string{"operation"}, s.metricsOpts...)
+	s.watchers = make(map[string]metrics.Gauge)
+	for _, op := range []string{opWatch, opWatchTree, opWatchEvents} {
+		s.watchers[op], _ = gauges.GetMetricWithLabelValues(op)
+	}
+	s.lockHold = s.registry.NewHistogram("kv_lock_hold_seconds", s.metricsOpts...)
+	return s
+}
+
+func (s *Store) Put(key string, value []byte, opts ...kv.PutOption) (err error) {
+	defer s.observe(opPut, key, time.Now(), &err)
+	return s.store.Put(key, value, opts...)
+}
+
+func (s *Store) AtomicPut(key string, value []byte, expected *kv.KeyValue, opts ...kv.PutOption) (pair *kv.KeyValue, err error) {
+	defer s.observe(opAtomicPut, key, time.Now(), &err)
+	return s.store.AtomicPut(key, value, expected, opts...)
+}
+
+func (s *Store) Get(key string) (pair *kv.KeyValue, err error) {
+	defer s.observe(opGet, key, time.Now(), &err)
+	return s.store.Get(key)
+}
+
+func (s *Store) List(prefix string) (pairs []*kv.KeyValue, err error) {
+	defer s.observe(opList, prefix, time.Now(), &err)
+	return s.store.List(prefix)
+}
+
+func (s *Store) ListPage(prefix string, opts ...kv.ListOption) (pairs []*kv.KeyValue, cursor string, err error) {
+	defer s.observe(opListPage, prefix, time.Now(), &err)
+	return s.store.ListPage(prefix, opts...)
+}
+
+func (s *Store) Delete(key string) (err error) {
+	defer s.observe(opDelete, key, time.Now(), &err)
+	return s.store.Delete(key)
+}
+
+func (s *Store) AtomicDelete(key string, expected *kv.KeyValue) (deleted bool, err error) {
+	defer s.observe(opAtomicDelete, key, time.Now(), &err)
+	return s.store.AtomicDelete(key, expected)
+}
+
+func (s *Store) DeleteTree(prefix string) (err error) {
+	defer s.observe(opDeleteTree, prefix, time.Now(), &err)
+	return s.store.DeleteTree(prefix)
+}
+
+func (s *Store) Txn(compares []kv.Compare, ops []kv.Op) (results []*kv.KeyValue, err error) {
+	defer s.observe(opTxn, "", time.Now(), &err)
+	return s.store.Txn(compares, ops)
+}
+
+func (s *Store) Exists(key string) (exist bool, err error) {
+	defer s.observe(opExists, key, time.Now(), &err)
+	return s.store.Exists(key)
+}
+
+// Watch counts the watch as a watcher until the underlying channel is closed
+func (s *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *kv.KeyValue, error) {
+	begin := time.Now()
+	changes, err := s.store.Watch(key, stopCh)
+	s.observe(opWatch, key, begin, &err)
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.watchers[opWatch]
+	addWatchers(w, 1)
+	watchCh := make(chan *kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		defer addWatchers(w, -1)
+		watch.Forward(watchCh, changes, stopCh, func(pair *kv.KeyValue) (*kv.KeyValue, bool) {
+			return pair, true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (s *Store) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*kv.KeyValue, error) {
+	begin := time.Now()
+	changes, err := s.store.WatchTree(prefix, stopCh)
+	s.observe(opWatchTree, prefix, begin, &err)
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.watchers[opWatchTree]
+	addWatchers(w, 1)
+	watchCh := make(chan []*kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		defer addWatchers(w, -1)
+		watch.Forward(watchCh, changes, stopCh, func(pairs []*kv.KeyValue) ([]*kv.KeyValue, bool) {
+			return pairs, true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (s *Store) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	begin := time.Now()
+	events, err := s.store.WatchEvents(prefix, stopCh, opts...)
+	s.observe(opWatchEvents, prefix, begin, &err)
+	if err != nil {
+		return nil, err
+	}
+
+	w := s.watchers[opWatchEvents]
+	addWatchers(w, 1)
+	watchCh := make(chan *kv.Event)
+	go func() {
+		defer close(watchCh)
+		defer addWatchers(w, -1)
+		watch.Forward(watchCh, events, stopCh, func(ev *kv.Event) (*kv.Event, bool) {
+			return ev, true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (s *Store) Lock(key string, opts ...kv.LockOption) (kv.Locker, error) {
+	locker, err := s.store.Lock(key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &lock{
+		store:  s,
+		locker: locker,
+		key:    key,
+	}, nil
+}
+
+// Semaphore creates a semaphore of the key if the backend supports it
+func (s *Store) Semaphore(key string, limit int, opts ...kv.SemaphoreOption) (kv.Semaphore, error) {
+	return kv.NewSemaphore(s.store, key, limit, opts...)
+}
+
+// RateLimiter creates a rate limiter of the key if the backend supports it
+func (s *Store) RateLimiter(key string, rate kv.RateLimit) (kv.RateLimiter, error) {
+	return kv.NewRateLimiter(s.store, key, rate)
+}
+
+// TTL returns the remaining TTL of the key if the backend supports it
+func (s *Store) TTL(key string) (time.Duration, error) {
+	return kv.TTL(s.store, key)
+}
+
+// TTLs returns the remaining TTLs of the keys if the backend supports it
+func (s *Store) TTLs(keys []string) (map[string]time.Duration, error) {
+	return kv.TTLs(s.store, keys)
+}
+
+func (s *Store) Close() {
+	s.store.Close()
+}
+
+// observe records the latency and the error of the operation
+func (s *Store) observe(op string, key string, begin time.Time, errp *error) {
+	elapsed := time.Since(begin)
+	s.latencies[op].Observe(elapsed.Seconds())
+
+	err := *errp
+	if err != nil {
+		label, ok := errorLabels[err]
+		if !ok {
+			label = "other"
+		}
+		if counter, e := s.errors.GetMetricWithLabelValues(op, label); e == nil {
+			counter.Inc()
+		}
+	}
+	s.logger.Debug("kv operation", "op", op, "key", key, "elapsed", elapsed, "err", err)
+}
+
+// lock records the latency of the acquisition and how long the lock is held
+type lock struct {
+	store  *Store
+	locker kv.Locker
+	key    string
+
+	mu       sync.Mutex
+	acquired time.Time
+	unlockCh chan struct{}
+}
+
+func (l *lock) Lock(stopChan chan struct{}) (lockHeld <-chan struct{}, err error) {
+	defer l.store.observe(opLock, l.key, time.Now(), &err)
+	lockHeld, err = l.locker.Lock(stopChan)
+	if err != nil {
+		return nil, err
+	}
+
+	unlockCh := make(chan struct{})
+	l.mu.Lock()
+	l.acquired = time.Now()
+	l.unlockCh = unlockCh
+	l.mu.Unlock()
+
+	// the hold time is recorded once the lock is lost or unlocked
+	go func() {
+		select {
+		case <-lockHeld:
+			l.released(unlockCh, "lost")
+		case <-unlockCh:
+		}
+	}()
+	return lockHeld, nil
+}
+
+func (l *lock) Unlock() (err error) {
+	defer l.store.observe(opUnlock, l.key, time.Now(), &err)
+	l.mu.Lock()
+	unlockCh := l.unlockCh
+	l.mu.Unlock()
+	if unlockCh != nil {
+		l.released(unlockCh, "unlocked")
+	}
+	return l.locker.Unlock()
+}
+
+// released records the hold time of the acquisition of unlockCh
+func (l *lock) released(unlockCh chan struct{}, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unlockCh != unlockCh {
+		return
+	}
+	l.unlockCh = nil
+	close(unlockCh)
+
+	held := time.Since(l.acquired)
+	l.store.lockHold.Observe(held.Seconds())
+	l.store.logger.Debug("kv lock released", "key", l.key, "held", held, "reason", reason, "err", l.locker.Err())
+}
+
+func (l *lock) Token() uint64 {
+	return l.locker.Token()
+}
+
+func (l *lock) Err() error {
+	return l.locker.Err()
+}
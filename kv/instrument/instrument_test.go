@@ -0,0 +1,151 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrument
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	testutils "github.com/getamis/sirius/kv/test"
+	"github.com/getamis/sirius/log"
+	"github.com/getamis/sirius/metrics"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	s := New(kv.New(), Registry(metrics.NewPrometheusRegistry()), Logger(log.Discard()))
+
+	testutils.RunAll(t, s)
+}
+
+func TestInstrumentMetrics(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	var (
+		mu      sync.Mutex
+		records []*log.Record
+	)
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, r)
+		return nil
+	}))
+	s := New(kv.New(), Registry(registry), Logger(logger))
+
+	assert.NoError(t, s.Put("testInstrument/key", []byte("foo")), "should be no error")
+	_, err := s.Get("testInstrument/key")
+	assert.NoError(t, err, "should be no error")
+	_, err = s.Get("testInstrument/not_exist")
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	m := findMetric(t, registry, "kv_operation_seconds", "get")
+	if assert.NotNil(t, m, "should not be nil") {
+		assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount(), "should be equal")
+	}
+	m = findMetric(t, registry, "kv_errors", "key_not_found")
+	if assert.NotNil(t, m, "should not be nil") {
+		assert.Equal(t, 1.0, m.GetCounter().GetValue(), "should be equal")
+	}
+
+	// the watchers are counted until the watches are stopped
+	stopCh := make(chan struct{})
+	_, err = s.WatchTree("testInstrument", stopCh)
+	assert.NoError(t, err, "should be no error")
+	m = findMetric(t, registry, "kv_watchers", "watch_tree")
+	if assert.NotNil(t, m, "should not be nil") {
+		assert.Equal(t, 1.0, m.GetGauge().GetValue(), "should be equal")
+	}
+	close(stopCh)
+	assert.True(t, eventually(func() bool {
+		return findMetric(t, registry, "kv_watchers", "watch_tree").GetGauge().GetValue() == 0
+	}), "should be true")
+
+	locker, err := s.Lock("testInstrument/lock")
+	assert.NoError(t, err, "should be no error")
+	_, err = locker.Lock(nil)
+	assert.NoError(t, err, "should be no error")
+	assert.NoError(t, locker.Unlock(), "should be no error")
+	m = findMetric(t, registry, "kv_lock_hold_seconds", "")
+	if assert.NotNil(t, m, "should not be nil") {
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount(), "should be equal")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.NotEmpty(t, records, "should not be empty") {
+		assert.Equal(t, log.LvlDebug, records[0].Lvl, "should be equal")
+		assert.Equal(t, "kv operation", records[0].Msg, "should be equal")
+	}
+}
+
+// findMetric finds the metric with the label value, or the first one if the value is empty
+func TestInstrumentSharedWatchers(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	first := New(kv.New(), Registry(registry), Logger(log.Discard()))
+	second := New(kv.New(), Registry(registry), Logger(log.Discard()))
+
+	// the stores of the same registry add up their watchers
+	firstCh := make(chan struct{})
+	_, err := first.Watch("testInstrumentSharedWatchers", firstCh)
+	assert.NoError(t, err, "should be no error")
+	secondCh := make(chan struct{})
+	_, err = second.Watch("testInstrumentSharedWatchers", secondCh)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 2.0, findMetric(t, registry, "kv_watchers", "watch").GetGauge().GetValue(), "should be equal")
+
+	close(firstCh)
+	assert.True(t, eventually(func() bool {
+		return findMetric(t, registry, "kv_watchers", "watch").GetGauge().GetValue() == 1
+	}), "should be true")
+	close(secondCh)
+	assert.True(t, eventually(func() bool {
+		return findMetric(t, registry, "kv_watchers", "watch").GetGauge().GetValue() == 0
+	}), "should be true")
+}
+
+func findMetric(t *testing.T, registry *metrics.PrometheusRegistry, name string, value string) *dto.Metric {
+	families, err := registry.Gather()
+	assert.NoError(t, err, "should be no error")
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if value == "" {
+				return m
+			}
+			for _, label := range m.GetLabel() {
+				if label.GetValue() == value {
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func eventually(cond func() bool) bool {
+	for i := 0; i < 50; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
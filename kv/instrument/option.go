@@ -0,0 +1,44 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrument
+
+import (
+	"github.com/getamis/sirius/log"
+	"github.com/getamis/sirius/metrics"
+)
+
+type Option func(s *Store)
+
+// Registry returns an option to set the registry of the metrics
+func Registry(registry metrics.Registry) Option {
+	return func(s *Store) {
+		s.registry = registry
+	}
+}
+
+// MetricsOptions returns an option to set the options of the metrics, e.g. the labels
+// to tell the stores apart
+func MetricsOptions(opts ...metrics.Option) Option {
+	return func(s *Store) {
+		s.metricsOpts = append(s.metricsOpts, opts...)
+	}
+}
+
+// Logger returns an option to set the logger of the debug logs
+func Logger(logger log.Logger) Option {
+	return func(s *Store) {
+		s.logger = logger
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watch shares the watch forwarding of the kv.Store decorators
+package watch
+
+// Forward sends the values of a watch converted by fn to out until stopCh is closed,
+// and the values are dropped if fn returns false. It keeps receiving after it's
+// stopped until the backend closes in, so the backend is never blocked.
+// The caller closes out after it returns.
+func Forward[In, Out any](out chan<- Out, in <-chan In, stopCh <-chan struct{}, fn func(In) (Out, bool)) {
+	stopped := false
+	for v := range in {
+		if stopped {
+			continue
+		}
+		o, ok := fn(v)
+		if !ok {
+			continue
+		}
+		select {
+		case out <- o:
+		case <-stopCh:
+			stopped = true
+		}
+	}
+}
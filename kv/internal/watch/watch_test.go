@@ -0,0 +1,49 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForward(t *testing.T) {
+	in := make(chan int)
+	out := make(chan string)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(out)
+		Forward(out, in, stopCh, func(v int) (string, bool) {
+			return fmt.Sprint(v), v%2 == 0
+		})
+	}()
+
+	in <- 1
+	in <- 2
+	assert.Equal(t, "2", <-out, "should be equal")
+
+	// the values are drained after it's stopped
+	close(stopCh)
+	in <- 4
+	in <- 6
+	close(in)
+	<-done
+	_, ok := <-out
+	assert.False(t, ok, "should be closed")
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUnableToAcquire is returned when a semaphore or a rate limiter is stopped before it's acquired
+	ErrUnableToAcquire = errors.New("failed to acquire")
+	// ErrInvalidLimit is returned when the limit of a semaphore or a rate limiter is not positive
+	ErrInvalidLimit = errors.New("invalid limit")
+)
+
+// Semaphore limits the number of the concurrent holders of a key.
+// A slot expires after the TTL, so it's freed even if the holder is gone.
+type Semaphore interface {
+	// Acquire blocks until a slot is acquired or stopCh is closed.
+	// Acquiring a held slot renews it.
+	Acquire(stopCh <-chan struct{}) error
+
+	// TryAcquire acquires a slot if there is a free one
+	TryAcquire() (bool, error)
+
+	// Release frees the slot
+	Release() error
+}
+
+// RateLimiter limits the rate of the calls sharing a key
+type RateLimiter interface {
+	// Allow takes a token if there is one, otherwise it returns how long to wait for the next one
+	Allow() (bool, time.Duration, error)
+
+	// Wait blocks until a token is taken or stopCh is closed
+	Wait(stopCh <-chan struct{}) error
+}
+
+// RateAlgorithm is how a RateLimit is enforced
+type RateAlgorithm int
+
+const (
+	// TokenBucket allows bursts up to Limit calls, and refills Limit tokens per Window
+	TokenBucket RateAlgorithm = iota
+	// SlidingWindow allows at most Limit calls in any Window
+	SlidingWindow
+)
+
+// RateLimit is Limit calls per Window
+type RateLimit struct {
+	Algorithm RateAlgorithm
+	Limit     int
+	Window    time.Duration
+}
+
+// limiter is implemented by the backends which support semaphores and rate limiters
+type limiter interface {
+	Semaphore(key string, limit int, opts ...SemaphoreOption) (Semaphore, error)
+	RateLimiter(key string, rate RateLimit) (RateLimiter, error)
+}
+
+// NewSemaphore creates a semaphore of the key with limit slots.
+// ErrNotSupported is returned if the backend doesn't support semaphores.
+func NewSemaphore(s Store, key string, limit int, opts ...SemaphoreOption) (Semaphore, error) {
+	l, ok := s.(limiter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return l.Semaphore(key, limit, opts...)
+}
+
+// NewRateLimiter creates a rate limiter of the key.
+// ErrNotSupported is returned if the backend doesn't support rate limiters.
+func NewRateLimiter(s Store, key string, rate RateLimit) (RateLimiter, error) {
+	l, ok := s.(limiter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return l.RateLimiter(key, rate)
+}
+
+// acquireRetryInterval is how often a semaphore tries again while it's waiting for a free slot
+const acquireRetryInterval = 100 * time.Millisecond
+
+// WaitFor calls try until it succeeds or stopCh is closed. try returns how long to wait before
+// the next try, and acquireRetryInterval is used if it's 0. It's used by the backends to
+// implement Semaphore.Acquire and RateLimiter.Wait.
+func WaitFor(stopCh <-chan struct{}, try func() (bool, time.Duration, error)) error {
+	for {
+		ok, wait, err := try()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if wait <= 0 {
+			wait = acquireRetryInterval
+		}
+
+		select {
+		case <-stopCh:
+			return ErrUnableToAcquire
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv_test
+
+import (
+	"testing"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterNotSupported(t *testing.T) {
+	kv := &mocks.Store{}
+
+	_, err := store.NewSemaphore(kv, "test-key", 1)
+	assert.Equal(t, store.ErrNotSupported, err, "should be equal")
+	_, err = store.NewRateLimiter(kv, "test-key", store.RateLimit{Limit: 1, Window: time.Second})
+	assert.Equal(t, store.ErrNotSupported, err, "should be equal")
+}
+
+func TestWaitFor(t *testing.T) {
+	tries := 0
+	err := store.WaitFor(nil, func() (bool, time.Duration, error) {
+		tries++
+		return tries == 3, time.Millisecond, nil
+	})
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 3, tries, "should be equal")
+}
@@ -160,6 +160,43 @@ func (_m *Store) List(prefix string) ([]*kv.KeyValue, error) {
 	return r0, r1
 }
 
+// ListPage provides a mock function with given fields: prefix, opts
+func (_m *Store) ListPage(prefix string, opts ...kv.ListOption) ([]*kv.KeyValue, string, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, prefix)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 []*kv.KeyValue
+	if rf, ok := ret.Get(0).(func(string, ...kv.ListOption) []*kv.KeyValue); ok {
+		r0 = rf(prefix, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*kv.KeyValue)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, ...kv.ListOption) string); ok {
+		r1 = rf(prefix, opts...)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, ...kv.ListOption) error); ok {
+		r2 = rf(prefix, opts...)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // Lock provides a mock function with given fields: key, opts
 func (_m *Store) Lock(key string, opts ...kv.LockOption) (kv.Locker, error) {
 	_va := make([]interface{}, len(opts))
@@ -211,6 +248,29 @@ func (_m *Store) Put(key string, value []byte, opts ...kv.PutOption) error {
 	return r0
 }
 
+// Txn provides a mock function with given fields: compares, ops
+func (_m *Store) Txn(compares []kv.Compare, ops []kv.Op) ([]*kv.KeyValue, error) {
+	ret := _m.Called(compares, ops)
+
+	var r0 []*kv.KeyValue
+	if rf, ok := ret.Get(0).(func([]kv.Compare, []kv.Op) []*kv.KeyValue); ok {
+		r0 = rf(compares, ops)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*kv.KeyValue)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]kv.Compare, []kv.Op) error); ok {
+		r1 = rf(compares, ops)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Watch provides a mock function with given fields: key, stopCh
 func (_m *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *kv.KeyValue, error) {
 	ret := _m.Called(key, stopCh)
@@ -234,6 +294,36 @@ func (_m *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *kv.KeyValue,
 	return r0, r1
 }
 
+// WatchEvents provides a mock function with given fields: prefix, stopCh, opts
+func (_m *Store) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, prefix, stopCh)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 <-chan *kv.Event
+	if rf, ok := ret.Get(0).(func(string, <-chan struct{}, ...kv.WatchOption) <-chan *kv.Event); ok {
+		r0 = rf(prefix, stopCh, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan *kv.Event)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, <-chan struct{}, ...kv.WatchOption) error); ok {
+		r1 = rf(prefix, stopCh, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WatchTree provides a mock function with given fields: prefix, stopCh
 func (_m *Store) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*kv.KeyValue, error) {
 	ret := _m.Called(prefix, stopCh)
@@ -0,0 +1,363 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/internal/watch"
+)
+
+var (
+	// ErrInvalidNamespace is returned when the namespace is empty
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrOutOfNamespace is returned when a key escapes the namespace
+	ErrOutOfNamespace = errors.New("key is out of the namespace")
+)
+
+// Namespace is a view of a kv.Store which prefixes every key with the namespace.
+// The prefix is stripped from the keys of the results, and the keys with ".."
+// are refused, so the services sharing a backend don't see each other's keys.
+type Namespace struct {
+	store  kv.Store
+	prefix string
+}
+
+// New returns the view of the store under the namespace, e.g. "service/tenant"
+func New(store kv.Store, namespace string) (*Namespace, error) {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return nil, ErrInvalidNamespace
+	}
+	if err := validate(namespace); err != nil {
+		return nil, err
+	}
+	return &Namespace{
+		store:  store,
+		prefix: namespace + "/",
+	}, nil
+}
+
+// Put a value with the specified key
+func (n *Namespace) Put(key string, value []byte, opts ...kv.PutOption) error {
+	k, err := n.key(key)
+	if err != nil {
+		return err
+	}
+	return n.store.Put(k, value, opts...)
+}
+
+func (n *Namespace) AtomicPut(key string, value []byte, expected *kv.KeyValue, opts ...kv.PutOption) (*kv.KeyValue, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	if expected != nil {
+		prev := *expected
+		prev.Key = k
+		expected = &prev
+	}
+	pair, err := n.store.AtomicPut(k, value, expected, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return n.strip(pair), nil
+}
+
+func (n *Namespace) Get(key string) (*kv.KeyValue, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := n.store.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	return n.strip(pair), nil
+}
+
+// List the content with given prefix, the whole namespace is listed if the prefix is empty
+func (n *Namespace) List(prefix string) ([]*kv.KeyValue, error) {
+	p, err := n.key(prefix)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := n.store.List(p)
+	if err != nil {
+		return nil, err
+	}
+	return n.stripAll(pairs), nil
+}
+
+// ListPage lists a page of the content with given prefix. The cursor is passed
+// to the backend as it is, since it's only bounded by the prefix.
+func (n *Namespace) ListPage(prefix string, opts ...kv.ListOption) ([]*kv.KeyValue, string, error) {
+	p, err := n.key(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	pairs, cursor, err := n.store.ListPage(p, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	return n.stripAll(pairs), cursor, nil
+}
+
+func (n *Namespace) Delete(key string) error {
+	k, err := n.key(key)
+	if err != nil {
+		return err
+	}
+	return n.store.Delete(k)
+}
+
+func (n *Namespace) AtomicDelete(key string, expected *kv.KeyValue) (bool, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return false, err
+	}
+	if expected != nil {
+		prev := *expected
+		prev.Key = k
+		expected = &prev
+	}
+	return n.store.AtomicDelete(k, expected)
+}
+
+// DeleteTree deletes the keys with given prefix, the whole namespace is deleted if the prefix is empty
+func (n *Namespace) DeleteTree(prefix string) error {
+	p, err := n.key(prefix)
+	if err != nil {
+		return err
+	}
+	return n.store.DeleteTree(p)
+}
+
+func (n *Namespace) Txn(compares []kv.Compare, ops []kv.Op) ([]*kv.KeyValue, error) {
+	nCompares := make([]kv.Compare, len(compares))
+	for i, c := range compares {
+		k, err := n.key(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Key = k
+		nCompares[i] = c
+	}
+	nOps := make([]kv.Op, len(ops))
+	for i, op := range ops {
+		k, err := n.key(op.Key)
+		if err != nil {
+			return nil, err
+		}
+		op.Key = k
+		nOps[i] = op
+	}
+
+	results, err := n.store.Txn(nCompares, nOps)
+	if err != nil {
+		return nil, err
+	}
+	for i, pair := range results {
+		if pair != nil {
+			results[i] = n.strip(pair)
+		}
+	}
+	return results, nil
+}
+
+func (n *Namespace) Exists(key string) (bool, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return false, err
+	}
+	return n.store.Exists(k)
+}
+
+func (n *Namespace) Watch(key string, stopCh <-chan struct{}) (<-chan *kv.KeyValue, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := n.store.Watch(k, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, changes, stopCh, func(pair *kv.KeyValue) (*kv.KeyValue, bool) {
+			return n.strip(pair), true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (n *Namespace) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*kv.KeyValue, error) {
+	p, err := n.key(prefix)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := n.store.WatchTree(p, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*kv.KeyValue)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, changes, stopCh, func(pairs []*kv.KeyValue) ([]*kv.KeyValue, bool) {
+			return n.stripAll(pairs), true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (n *Namespace) WatchEvents(prefix string, stopCh <-chan struct{}, opts ...kv.WatchOption) (<-chan *kv.Event, error) {
+	p, err := n.key(prefix)
+	if err != nil {
+		return nil, err
+	}
+	events, err := n.store.WatchEvents(p, stopCh, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *kv.Event)
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, events, stopCh, func(ev *kv.Event) (*kv.Event, bool) {
+			if !n.contains(ev.KV.Key) {
+				return nil, false
+			}
+			e := *ev
+			e.KV = n.strip(ev.KV)
+			return &e, true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (n *Namespace) Lock(key string, opts ...kv.LockOption) (kv.Locker, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return n.store.Lock(k, opts...)
+}
+
+// Semaphore creates a semaphore of the key in the namespace if the backend supports it
+func (n *Namespace) Semaphore(key string, limit int, opts ...kv.SemaphoreOption) (kv.Semaphore, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return kv.NewSemaphore(n.store, k, limit, opts...)
+}
+
+// RateLimiter creates a rate limiter of the key in the namespace if the backend supports it
+func (n *Namespace) RateLimiter(key string, rate kv.RateLimit) (kv.RateLimiter, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return kv.NewRateLimiter(n.store, k, rate)
+}
+
+// TTL returns the remaining TTL of the key in the namespace if the backend supports it
+func (n *Namespace) TTL(key string) (time.Duration, error) {
+	k, err := n.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return kv.TTL(n.store, k)
+}
+
+// TTLs returns the remaining TTLs of the keys in the namespace if the backend supports it
+func (n *Namespace) TTLs(keys []string) (map[string]time.Duration, error) {
+	ks := make([]string, len(keys))
+	for i, key := range keys {
+		k, err := n.key(key)
+		if err != nil {
+			return nil, err
+		}
+		ks[i] = k
+	}
+	ttls, err := kv.TTLs(n.store, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]time.Duration, len(ttls))
+	for i, k := range ks {
+		if ttl, ok := ttls[k]; ok {
+			result[keys[i]] = ttl
+		}
+	}
+	return result, nil
+}
+
+// Close closes the underlying store
+func (n *Namespace) Close() {
+	n.store.Close()
+}
+
+// key returns the key in the namespace
+func (n *Namespace) key(key string) (string, error) {
+	if err := validate(key); err != nil {
+		return "", err
+	}
+	return n.prefix + key, nil
+}
+
+// contains checks if the key returned by the backend is in the namespace.
+// Some backends return the normalized key, so the leading slash is ignored.
+func (n *Namespace) contains(key string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(key, "/"), n.prefix)
+}
+
+// strip returns a copy of the pair without the namespace in the key
+func (n *Namespace) strip(pair *kv.KeyValue) *kv.KeyValue {
+	if pair == nil {
+		return nil
+	}
+	p := *pair
+	p.Key = strings.TrimPrefix(strings.TrimPrefix(pair.Key, "/"), n.prefix)
+	return &p
+}
+
+// stripAll strips the pairs and drops the ones out of the namespace
+func (n *Namespace) stripAll(pairs []*kv.KeyValue) []*kv.KeyValue {
+	stripped := make([]*kv.KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		if n.contains(pair.Key) {
+			stripped = append(stripped, n.strip(pair))
+		}
+	}
+	return stripped
+}
+
+// validate refuses the keys which may be resolved out of the namespace
+func validate(key string) error {
+	for _, part := range kv.SplitKey(key) {
+		if part == ".." {
+			return ErrOutOfNamespace
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package namespace
+
+import (
+	"testing"
+
+	"github.com/getamis/sirius/kv"
+	testutils "github.com/getamis/sirius/kv/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	ns, err := New(kv.New(), "service/tenant")
+	assert.NoError(t, err, "should be no error")
+
+	testutils.RunAll(t, ns)
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	store := kv.New()
+	first, err := New(store, "first")
+	assert.NoError(t, err, "should be no error")
+	second, err := New(store, "/second/")
+	assert.NoError(t, err, "should be no error")
+
+	assert.NoError(t, first.Put("dir/key", []byte("first")), "should be no error")
+	assert.NoError(t, second.Put("dir/key", []byte("second")), "should be no error")
+
+	pair, err := first.Get("dir/key")
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "dir/key", pair.Key, "should be equal")
+	assert.Equal(t, []byte("first"), pair.Value, "should be equal")
+
+	pair, err = store.Get("second/dir/key")
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("second"), pair.Value, "should be equal")
+
+	// deleting the whole namespace leaves the other one
+	assert.NoError(t, first.DeleteTree(""), "should be no error")
+	_, err = first.Get("dir/key")
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+	pairs, err := second.List("dir")
+	assert.NoError(t, err, "should be no error")
+	assert.Len(t, pairs, 1, "should be 1")
+	assert.Equal(t, "dir/key", pairs[0].Key, "should be equal")
+
+	// the keys escaping the namespace are refused
+	_, err = first.Get("../second/dir/key")
+	assert.Equal(t, ErrOutOfNamespace, err, "should be equal")
+	_, err = first.List("dir/../..")
+	assert.Equal(t, ErrOutOfNamespace, err, "should be equal")
+	_, err = first.Txn(nil, []kv.Op{kv.DeleteOp("../second/dir/key")})
+	assert.Equal(t, ErrOutOfNamespace, err, "should be equal")
+
+	_, err = New(store, "/")
+	assert.Equal(t, ErrInvalidNamespace, err, "should be equal")
+	_, err = New(store, "a/../b")
+	assert.Equal(t, ErrOutOfNamespace, err, "should be equal")
+}
@@ -39,10 +39,49 @@ func PutExpiration(t time.Duration) PutOption {
 
 // ----------------------------------------------------------------------------
 
+// DefaultListLimit is the page size of ListPage if the limit is not given
+const DefaultListLimit = 100
+
+type ListOptions struct {
+	Cursor string // Optional, the cursor returned by the previous page
+	Limit  int    // Optional, the max number of entries in a page, some backends take it as a hint
+}
+
+type ListOption func(*ListOptions)
+
+func ListCursor(cursor string) ListOption {
+	return func(o *ListOptions) {
+		o.Cursor = cursor
+	}
+}
+
+func ListLimit(limit int) ListOption {
+	return func(o *ListOptions) {
+		o.Limit = limit
+	}
+}
+
+// NewListOptions returns the list options with the default limit
+func NewListOptions(opts ...ListOption) *ListOptions {
+	o := &ListOptions{
+		Limit: DefaultListLimit,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultListLimit
+	}
+	return o
+}
+
+// ----------------------------------------------------------------------------
+
 type LockOptions struct {
 	Value     []byte        // Optional, value to associate with the lock
 	TTL       time.Duration // Optional, expiration time associated with the lock
 	RenewLock chan struct{} // Optional, chan used to control and stop the session ttl renewal for the lock
+	Owner     string        // Optional, the locks of the same key and owner are reentrant, only supported by some backends
 }
 
 type LockOption func(*LockOptions)
@@ -64,3 +103,41 @@ func RenewLock(r chan struct{}) LockOption {
 		o.RenewLock = r
 	}
 }
+
+// LockOwner makes the lock reentrant for the owner, so the lock of the key is
+// acquired at once if it's held by the same owner
+func LockOwner(owner string) LockOption {
+	return func(o *LockOptions) {
+		o.Owner = owner
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type WatchOptions struct {
+	FromRevision uint64 // Optional, deliver the events since the revision first
+}
+
+type WatchOption func(*WatchOptions)
+
+// WatchFromRevision resumes a watch from the revision, which is usually
+// the revision of the last received event plus one
+func WatchFromRevision(revision uint64) WatchOption {
+	return func(o *WatchOptions) {
+		o.FromRevision = revision
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+type SemaphoreOptions struct {
+	TTL time.Duration // Optional, expiration time of the acquired slot
+}
+
+type SemaphoreOption func(*SemaphoreOptions)
+
+func SemaphoreExpiration(t time.Duration) SemaphoreOption {
+	return func(o *SemaphoreOptions) {
+		o.TTL = t
+	}
+}
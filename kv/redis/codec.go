@@ -0,0 +1,162 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	store "github.com/getamis/sirius/kv"
+	"gopkg.in/redis.v5"
+)
+
+const (
+	jsonFormat   = "json"
+	binaryFormat = "binary"
+	hashFormat   = "hash"
+)
+
+var (
+	// JSONCodec encodes the whole KeyValue in JSON, which is the default and
+	// compatible with the values written by the previous versions
+	JSONCodec Codec = jsonCodec{}
+
+	// BinaryCodec encodes the KeyValue in a compact binary envelope, so the
+	// value isn't inflated by base64
+	BinaryCodec Codec = binaryCodec{}
+
+	// HashCodec stores the KeyValue as a redis hash with the key, value and
+	// revision fields, so the value is readable with redis-cli
+	HashCodec Codec = hashCodec{}
+
+	// ErrInvalidEnvelope is thrown when the binary envelope is malformed
+	ErrInvalidEnvelope = errors.New("redis: invalid binary envelope")
+)
+
+// Codec encodes the KeyValue stored in redis, which is one of JSONCodec,
+// BinaryCodec and HashCodec. The lua script reads and bumps the revision in
+// place for each of the formats, so it's not implemented outside the package.
+type Codec interface {
+	// format is the format of the encoded value known by the lua script
+	format() string
+	encode(kv *store.KeyValue) (string, error)
+	decode(data string, kv *store.KeyValue) error
+}
+
+type jsonCodec struct{}
+
+func (c jsonCodec) format() string {
+	return jsonFormat
+}
+
+func (c jsonCodec) encode(kv *store.KeyValue) (string, error) {
+	b, err := json.Marshal(kv)
+	return string(b), err
+}
+
+func (c jsonCodec) decode(data string, kv *store.KeyValue) error {
+	return json.Unmarshal([]byte(data), kv)
+}
+
+// binary envelope: version (1 byte) | revision (8 bytes) | key length (4 bytes) | key | value
+// The integers are big-endian, and the lua script overwrites the revision.
+const (
+	envelopeVersion    = 1
+	envelopeHeaderSize = 1 + 8 + 4
+)
+
+type binaryCodec struct{}
+
+func (c binaryCodec) format() string {
+	return binaryFormat
+}
+
+func (c binaryCodec) encode(kv *store.KeyValue) (string, error) {
+	b := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(kv.Key)+len(kv.Value))
+	b[0] = envelopeVersion
+	binary.BigEndian.PutUint64(b[1:9], kv.Revision)
+	binary.BigEndian.PutUint32(b[9:13], uint32(len(kv.Key)))
+	b = append(b, kv.Key...)
+	b = append(b, kv.Value...)
+	return string(b), nil
+}
+
+func (c binaryCodec) decode(data string, kv *store.KeyValue) error {
+	if len(data) < envelopeHeaderSize || data[0] != envelopeVersion {
+		return ErrInvalidEnvelope
+	}
+	keyLen := int(binary.BigEndian.Uint32([]byte(data[9:13])))
+	if len(data) < envelopeHeaderSize+keyLen {
+		return ErrInvalidEnvelope
+	}
+
+	kv.Revision = binary.BigEndian.Uint64([]byte(data[1:9]))
+	kv.Key = data[envelopeHeaderSize : envelopeHeaderSize+keyLen]
+	kv.Value = nil
+	if value := data[envelopeHeaderSize+keyLen:]; len(value) > 0 {
+		kv.Value = []byte(value)
+	}
+	return nil
+}
+
+// hashCodec passes the binary envelope to the lua script, which unpacks
+// it into the fields of the hash
+type hashCodec struct {
+	binaryCodec
+}
+
+func (c hashCodec) format() string {
+	return hashFormat
+}
+
+// reader queues the read of the encoded value of a key on a client or a pipeline
+type reader interface {
+	Get(key string) *redis.StringCmd
+	HMGet(key string, fields ...string) *redis.SliceCmd
+}
+
+// read queues the read of the encoded value, which is returned by the returned
+// func after the command is executed. redis.Nil is returned if the key is not found.
+func (r *Redis) read(c reader, key string) func() (string, error) {
+	if r.codec.format() != hashFormat {
+		cmd := c.Get(key)
+		return cmd.Result
+	}
+
+	cmd := c.HMGet(key, "key", "value", "revision")
+	return func() (string, error) {
+		fields, err := cmd.Result()
+		if err != nil {
+			return "", err
+		}
+		if len(fields) != 3 || fields[2] == nil {
+			return "", redis.Nil
+		}
+
+		kv := &store.KeyValue{}
+		kv.Key, _ = fields[0].(string)
+		if value, _ := fields[1].(string); len(value) > 0 {
+			kv.Value = []byte(value)
+		}
+		revision, _ := fields[2].(string)
+		kv.Revision, err = strconv.ParseUint(revision, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return r.codec.encode(kv)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"testing"
+
+	store "github.com/getamis/sirius/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs(t *testing.T) {
+	kv := &store.KeyValue{
+		Key:      "test-key",
+		Value:    []byte{0x00, 0xff, 0x10},
+		Revision: 12345,
+	}
+
+	for _, codec := range []Codec{JSONCodec, BinaryCodec, HashCodec} {
+		data, err := codec.encode(kv)
+		assert.NoError(t, err)
+
+		decoded := &store.KeyValue{}
+		assert.NoError(t, codec.decode(data, decoded))
+		assert.Equal(t, kv, decoded, codec.format())
+	}
+}
+
+func TestBinaryCodecInvalidEnvelope(t *testing.T) {
+	data, err := BinaryCodec.encode(&store.KeyValue{Key: "test-key"})
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{"", "\x02" + data[1:], data[:len(data)-1]} {
+		assert.Equal(t, ErrInvalidEnvelope, BinaryCodec.decode(invalid, &store.KeyValue{}))
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+)
+
+// minTimeout is the shortest timeout of the views, and a deadline sooner than
+// it is exceeded before any command is sent
+const minTimeout = time.Millisecond
+
+// ContextStore returns the context-first variant of the store.
+// redis.v5 doesn't take a context, so the context is checked before every command,
+// and the commands of a context with a deadline run on a view of the store whose
+// timeouts end before it. A command in flight is never abandoned: it returns its own
+// result, e.g., a timeout error if it may have been applied, instead of ctx.Err().
+func (r *Redis) ContextStore() store.ContextStore {
+	return &contextRedis{redis: r}
+}
+
+// withContext returns the store, or the view of it whose commands end before the
+// deadline of ctx. It returns ctx.Err() if ctx is done, so nothing is sent.
+func (r *Redis) withContext(ctx context.Context) (*Redis, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return r, nil
+	}
+	left := time.Until(deadline)
+	if left >= r.maxTimeout {
+		return r, nil
+	}
+	timeout := viewTimeout(left)
+	if timeout == 0 {
+		return nil, context.DeadlineExceeded
+	}
+	return r.view(timeout), nil
+}
+
+// viewTimeout returns the longest timeout of the views within a quarter of the time
+// left, since a command may wait for the pool, dial, write and read, or 0 if there
+// is none. The timeouts of the views are minTimeout doubled, so there are a few.
+func viewTimeout(left time.Duration) time.Duration {
+	if left/4 < minTimeout {
+		return 0
+	}
+	timeout := minTimeout
+	for timeout*2 <= left/4 {
+		timeout *= 2
+	}
+	return timeout
+}
+
+// view returns the store connected by the client of the timeout
+func (r *Redis) view(timeout time.Duration) *Redis {
+	r.viewsMu.Lock()
+	defer r.viewsMu.Unlock()
+
+	if view, ok := r.views[timeout]; ok {
+		return view
+	}
+	select {
+	case <-r.closeCh:
+		// the closed client fails the commands
+		return r
+	default:
+	}
+	view := &Redis{
+		client:     r.newTimeoutClient(timeout),
+		redisState: r.redisState,
+	}
+	r.views[timeout] = view
+	return view
+}
+
+type contextRedis struct {
+	redis *Redis
+}
+
+func (c *contextRedis) Put(ctx context.Context, key string, value []byte, options ...store.PutOption) error {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Put(key, value, options...)
+}
+
+func (c *contextRedis) AtomicPut(ctx context.Context, key string, value []byte, previous *store.KeyValue, options ...store.PutOption) (*store.KeyValue, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.AtomicPut(key, value, previous, options...)
+}
+
+func (c *contextRedis) Get(ctx context.Context, key string) (*store.KeyValue, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(key)
+}
+
+// List lists the values batch by batch, and stops between the batches once
+// the context is done
+func (c *contextRedis) List(ctx context.Context, directory string) ([]*store.KeyValue, error) {
+	nKey := normalize(directory)
+	l := newListing(nKey)
+	err := c.scanAll(ctx, scanRegex(nKey), l.add)
+	if err != nil {
+		return nil, err
+	}
+	return l.result()
+}
+
+func (c *contextRedis) ListPage(ctx context.Context, directory string, options ...store.ListOption) ([]*store.KeyValue, string, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.ListPage(directory, options...)
+}
+
+func (c *contextRedis) Delete(ctx context.Context, key string) error {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Delete(key)
+}
+
+func (c *contextRedis) AtomicDelete(ctx context.Context, key string, previous *store.KeyValue) (bool, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	return r.AtomicDelete(key, previous)
+}
+
+// DeleteTree deletes the keys batch by batch, and stops between the batches once
+// the context is done, so the keys deleted before are not restored
+func (c *contextRedis) DeleteTree(ctx context.Context, directory string) error {
+	found := false
+	err := c.scanAll(ctx, scanRegex(normalize(directory)), func(r *Redis, keys []string) error {
+		found = true
+		return r.del(keys...)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// scanAll scans the keys matching the regex and calls fn batch by batch. The view
+// of the context is chosen again for every scan and every batch.
+func (c *contextRedis) scanAll(ctx context.Context, regex string, fn func(r *Redis, keys []string) error) error {
+	cursor := ""
+	for {
+		r, err := c.redis.withContext(ctx)
+		if err != nil {
+			return err
+		}
+		keys, next, err := r.scanPage(regex, cursor, defaultScanCount)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if r, err = c.redis.withContext(ctx); err != nil {
+				return err
+			}
+			if err := fn(r, keys); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (c *contextRedis) Txn(ctx context.Context, compares []store.Compare, ops []store.Op) ([]*store.KeyValue, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Txn(compares, ops)
+}
+
+func (c *contextRedis) Exists(ctx context.Context, key string) (bool, error) {
+	r, err := c.redis.withContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	return r.Exists(key)
+}
+
+func (c *contextRedis) Watch(ctx context.Context, key string) (<-chan *store.KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.redis.Watch(key, ctx.Done())
+}
+
+func (c *contextRedis) WatchTree(ctx context.Context, directory string) (<-chan []*store.KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.redis.WatchTree(directory, ctx.Done())
+}
+
+func (c *contextRedis) WatchEvents(ctx context.Context, directory string, options ...store.WatchOption) (<-chan *store.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.redis.WatchEvents(directory, ctx.Done(), options...)
+}
+
+func (c *contextRedis) Lock(ctx context.Context, key string, options ...store.LockOption) (store.ContextLocker, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	locker, err := c.redis.Lock(key, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &contextLock{lock: locker.(*redisLock)}, nil
+}
+
+func (c *contextRedis) Close() {
+	c.redis.Close()
+}
+
+// contextLock holds the lock on the store instead of a view, so the renewals
+// are not bounded by the context of Lock
+type contextLock struct {
+	lock *redisLock
+}
+
+// Lock checks the context before every try, and the lock is held until Unlock
+// even if the context is done after it's acquired
+func (l *contextLock) Lock(ctx context.Context) (<-chan struct{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lockHeld, err := l.lock.lock(ctx.Done(), nil)
+	if err == ErrAbortTryLock {
+		return nil, ctx.Err()
+	}
+	return lockHeld, err
+}
+
+// Unlock is not aborted once it starts, so it returns ctx.Err() only if the
+// context is done before
+func (l *contextLock) Unlock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.lock.Unlock()
+}
+
+func (l *contextLock) Token() uint64 {
+	return l.lock.Token()
+}
+
+func (l *contextLock) Err() error {
+	return l.lock.Err()
+}
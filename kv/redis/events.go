@@ -0,0 +1,536 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/log"
+	"gopkg.in/redis.v5"
+)
+
+// ErrEventStreamDisabled is thrown when the events are watched without the EventStream option
+var ErrEventStreamDisabled = errors.New("redis: event stream is disabled")
+
+const (
+	defaultReapInterval = time.Second
+
+	// reapBatch is the max number of the keys checked by a reap command
+	reapBatch = 100
+	// eventBatch is the max number of the events read at once
+	eventBatch = 100
+	// eventBlock is how long XREAD blocks, which has to be shorter than the read timeout
+	eventBlock = time.Second
+)
+
+// streamEvent is an event read from the stream with its normalized key
+type streamEvent struct {
+	key   string
+	event *store.Event
+}
+
+// WatchEvents watches the puts, deletes and expirations of the keys with the given prefix.
+// The events are read from the event stream, so the EventStream option is required.
+// In cluster mode, the events are kept per hash tag, so only the keys sharing the
+// hash tag of the prefix are watched, and ErrHashTagRequired is returned if the
+// prefix has none.
+// A watch can be resumed as long as the events are not trimmed from the stream,
+// otherwise ErrCompacted is returned.
+func (r *Redis) WatchEvents(prefix string, stopCh <-chan struct{}, options ...store.WatchOption) (<-chan *store.Event, error) {
+	if r.eventStreamLen <= 0 {
+		return nil, ErrEventStreamDisabled
+	}
+
+	wOption := &store.WatchOptions{}
+	for _, option := range options {
+		option(wOption)
+	}
+
+	nPrefix := normalize(prefix)
+	stream, err := r.eventStream(nPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var from string
+	if wOption.FromRevision > 0 {
+		from, err = r.resumeEventID(stream, wOption.FromRevision)
+	} else {
+		from, err = r.lastEventID(stream)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *store.Event)
+	go func() {
+		defer close(watchCh)
+		r.eventLoop(stream, from, stopCh, func(e *streamEvent) bool {
+			if !strings.HasPrefix(e.key, nPrefix) {
+				return true
+			}
+			select {
+			case watchCh <- e.event:
+				return true
+			case <-stopCh:
+				return false
+			}
+		})
+	}()
+	return watchCh, nil
+}
+
+// watchKey is Watch built on the event stream, so the values are carried by the
+// events instead of being retrieved after the notifications.
+func (r *Redis) watchKey(nKey string, stopCh <-chan struct{}) (<-chan *store.KeyValue, error) {
+	stream, err := r.eventStream(nKey)
+	if err != nil {
+		return nil, err
+	}
+	from, err := r.lastEventID(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *store.KeyValue)
+	push := func(pair *store.KeyValue) bool {
+		select {
+		case watchCh <- pair:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+
+		// deliver the original data before the events
+		var last uint64
+		pair, err := r.get(nKey)
+		if err == nil {
+			last = pair.Revision
+			if !push(pair) {
+				return
+			}
+		} else if err != store.ErrKeyNotFound {
+			log.Info("get in Watch", "err", err)
+			return
+		}
+
+		r.eventLoop(stream, from, stopCh, func(e *streamEvent) bool {
+			if e.key != nKey || e.event.Type != store.EventPut || e.event.KV.Revision <= last {
+				return true
+			}
+			last = e.event.KV.Revision
+			return push(e.event.KV)
+		})
+	}()
+	return watchCh, nil
+}
+
+// watchTree is WatchTree built on the event stream, the snapshots are kept
+// up to date by the events instead of being listed after the notifications.
+func (r *Redis) watchTree(nPrefix string, stopCh <-chan struct{}) (<-chan []*store.KeyValue, error) {
+	stream, err := r.eventStream(nPrefix)
+	if err != nil {
+		return nil, err
+	}
+	from, err := r.lastEventID(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*store.KeyValue)
+	snapshot := make(map[string]*store.KeyValue)
+	push := func() bool {
+		pairs := make([]*store.KeyValue, 0, len(snapshot))
+		for _, pair := range snapshot {
+			pairs = append(pairs, pair)
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key < pairs[j].Key
+		})
+
+		select {
+		case watchCh <- pairs:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+
+		// deliver the original data before the events
+		pairs, err := r.list(nPrefix)
+		if err != nil && err != store.ErrKeyNotFound {
+			log.Info("list in WatchTree", "err", err)
+			return
+		}
+		for _, pair := range pairs {
+			snapshot[normalize(pair.Key)] = pair
+		}
+		if !push() {
+			return
+		}
+
+		r.eventLoop(stream, from, stopCh, func(e *streamEvent) bool {
+			if e.key == nPrefix || !strings.HasPrefix(e.key, nPrefix) {
+				return true
+			}
+			// skip the events which are older than the snapshot
+			old, ok := snapshot[e.key]
+			if ok && e.event.KV.Revision <= old.Revision {
+				return true
+			}
+			if e.event.Type == store.EventPut {
+				snapshot[e.key] = e.event.KV
+			} else if ok {
+				delete(snapshot, e.key)
+			} else {
+				return true
+			}
+			return push()
+		})
+	}()
+	return watchCh, nil
+}
+
+// lockByEvents acquires the lock and waits for the changes on the event stream.
+// The stream is read from the event before trying, so no change is missed.
+func (l *redisLock) lockByEvents(abortCh <-chan struct{}, stopCh chan struct{}) (<-chan struct{}, error) {
+	nKey := normalize(l.key)
+	stream, err := l.redis.eventStream(nKey)
+	if err != nil {
+		return nil, err
+	}
+	from, err := l.redis.lastEventID(stream)
+	if err != nil {
+		return nil, err
+	}
+	reader := l.redis.newEventReader(stream)
+	defer reader.Close()
+
+	for {
+		if aborted(abortCh) {
+			return nil, ErrAbortTryLock
+		}
+		lockHeld, err := l.tryLock(stopCh)
+		if err != nil {
+			return nil, err
+		}
+		if lockHeld != nil {
+			return lockHeld, nil
+		}
+
+		changed := false
+		for !changed {
+			if aborted(abortCh) {
+				return nil, ErrAbortTryLock
+			}
+
+			var events []*streamEvent
+			events, from, err = reader.read(from)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range events {
+				if e.key == nKey {
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// eventLoop reads the events after the event id from, and calls fn for each
+// until stopCh is closed, the store is closed or fn returns false.
+func (r *Redis) eventLoop(stream, from string, stopCh <-chan struct{}, fn func(*streamEvent) bool) {
+	reader := r.newEventReader(stream)
+	defer reader.Close()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-r.closeCh:
+			return
+		default:
+		}
+
+		events, next, err := reader.read(from)
+		if err != nil {
+			log.Warn("Failed to read events", "stream", stream, "err", err)
+			select {
+			case <-stopCh:
+				return
+			case <-r.closeCh:
+				return
+			case <-time.After(eventBlock):
+			}
+			continue
+		}
+		from = next
+
+		for _, e := range events {
+			if !fn(e) {
+				return
+			}
+		}
+	}
+}
+
+// eventReader reads an event stream on a dedicated connection, so the blocking
+// reads don't hold the connections of the commands.
+type eventReader struct {
+	redis  *Redis
+	client redisClient
+	stream string
+}
+
+// newEventReader connects a reader of the stream, and the expiry set of the stream
+// is reaped until the reader is closed.
+func (r *Redis) newEventReader(stream string) *eventReader {
+	r.streamsMu.Lock()
+	r.streams[stream]++
+	r.streamsMu.Unlock()
+
+	return &eventReader{
+		redis:  r,
+		client: r.newReader(),
+		stream: stream,
+	}
+}
+
+func (e *eventReader) Close() {
+	e.client.Close()
+
+	e.redis.streamsMu.Lock()
+	defer e.redis.streamsMu.Unlock()
+	e.redis.streams[e.stream]--
+	if e.redis.streams[e.stream] <= 0 {
+		delete(e.redis.streams, e.stream)
+	}
+}
+
+// read blocks until there are events after the event id from, or eventBlock
+// is reached. It returns the events and the id of the last event.
+// redis.v5 doesn't support streams, so the reply of XREAD is parsed here.
+func (e *eventReader) read(from string) ([]*streamEvent, string, error) {
+	stream := e.stream
+	cmd := redis.NewSliceCmd(
+		"xread",
+		"count", eventBatch,
+		"block", int64(eventBlock/time.Millisecond),
+		"streams", stream, from,
+	)
+	if err := e.client.Process(cmd); err != nil {
+		if err == redis.Nil {
+			return nil, from, nil
+		}
+		return nil, "", err
+	}
+
+	var events []*streamEvent
+	for _, s := range cmd.Val() {
+		reply, ok := s.([]interface{})
+		if !ok || len(reply) != 2 {
+			continue
+		}
+		entries, _ := reply[1].([]interface{})
+		for _, entry := range entries {
+			id, fields, ok := parseEntry(entry)
+			if !ok {
+				continue
+			}
+			from = id
+
+			event, err := e.redis.toEvent(fields)
+			if err != nil {
+				log.Warn("Failed to decode event", "stream", stream, "id", id, "err", err)
+				continue
+			}
+			events = append(events, event)
+		}
+	}
+	return events, from, nil
+}
+
+// lastEventID returns the id of the last event of the stream, or "0-0" if it's empty
+func (r *Redis) lastEventID(stream string) (string, error) {
+	cmd := redis.NewSliceCmd("xrevrange", stream, "+", "-", "count", 1)
+	if err := r.client.Process(cmd); err != nil {
+		return "", err
+	}
+	for _, entry := range cmd.Val() {
+		if id, _, ok := parseEntry(entry); ok {
+			return id, nil
+		}
+	}
+	return "0-0", nil
+}
+
+// resumeEventID returns the id to read the events since the revision, which is
+// the id of the event, or ErrCompacted if the event has been trimmed.
+// Every write bumps the revision and adds an event, so the revision has been
+// trimmed if it's older than the first event.
+func (r *Redis) resumeEventID(stream string, revision uint64) (string, error) {
+	cmd := redis.NewSliceCmd("xrange", stream, "-", "+", "count", 1)
+	if err := r.client.Process(cmd); err != nil {
+		return "", err
+	}
+	for _, entry := range cmd.Val() {
+		id, _, ok := parseEntry(entry)
+		if !ok {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.SplitN(id, "-", 2)[0], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		if revision < first {
+			return "", store.ErrCompacted
+		}
+	}
+	// XREAD returns the events after the id
+	return strconv.FormatUint(revision-1, 10) + "-0", nil
+}
+
+func parseEntry(entry interface{}) (string, map[string]string, bool) {
+	reply, ok := entry.([]interface{})
+	if !ok || len(reply) != 2 {
+		return "", nil, false
+	}
+	id, ok := reply[0].(string)
+	if !ok {
+		return "", nil, false
+	}
+	values, _ := reply[1].([]interface{})
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		field, _ := values[i].(string)
+		value, _ := values[i+1].(string)
+		fields[field] = value
+	}
+	return id, fields, true
+}
+
+func (r *Redis) toEvent(fields map[string]string) (*streamEvent, error) {
+	revision, err := strconv.ParseUint(fields["revision"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := strconv.ParseUint(fields["prev"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &store.Event{
+		PrevRevision: prev,
+	}
+	switch fields["type"] {
+	case "put":
+		event.Type = store.EventPut
+		event.KV = &store.KeyValue{}
+		if err := r.codec.decode(fields["data"], event.KV); err != nil {
+			return nil, err
+		}
+	case "delete", "expire":
+		event.Type = store.EventDelete
+		if fields["type"] == "expire" {
+			event.Type = store.EventExpire
+		}
+		// only the normalized key is known after the key is deleted
+		event.KV = &store.KeyValue{
+			Key: strings.TrimPrefix(fields["key"], "/"),
+		}
+	default:
+		return nil, errors.New("redis: unknown event type " + fields["type"])
+	}
+	event.KV.Revision = revision
+
+	return &streamEvent{
+		key:   fields["key"],
+		event: event,
+	}, nil
+}
+
+// eventStream returns the stream of the events of the key or prefix.
+// In cluster mode, the streams are per hash tag, so a watch without a hash tag
+// would never see the writes of the other keys.
+func (r *Redis) eventStream(nKey string) (string, error) {
+	tag, ok := parseHashTag(nKey)
+	if !ok && r.isCluster() {
+		return "", ErrHashTagRequired
+	}
+	return r.metaKeys(tag)[1], nil
+}
+
+// reapLoop reports the expirations every interval until the store is closed
+func (r *Redis) reapLoop(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultReapInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+			if err := r.reap(); err != nil {
+				log.Warn("Failed to reap expirations", "err", err)
+			}
+		}
+	}
+}
+
+// reap reports the expirations of the keys in the expiry sets.
+// The expiry set is global unless it's connected to a cluster, where the expiry
+// sets of the hash tags being read are reaped, and the expirations of the other
+// hash tags are reported once they're read.
+func (r *Redis) reap() error {
+	tags := []string{""}
+	if r.isCluster() {
+		tags = nil
+		r.streamsMu.Lock()
+		for stream := range r.streams {
+			tags = append(tags, hashTag(stream))
+		}
+		r.streamsMu.Unlock()
+	}
+
+	for _, tag := range tags {
+		meta := r.metaKeys(tag)
+		for {
+			reply, err := r.runScriptWith(cmdReap, meta, nil)
+			if err != nil {
+				return err
+			}
+			if n, _ := reply.(int64); n < reapBatch {
+				break
+			}
+		}
+	}
+	return nil
+}
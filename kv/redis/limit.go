@@ -0,0 +1,156 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	store "github.com/getamis/sirius/kv"
+	"github.com/satori/go.uuid"
+)
+
+// The semaphores and the rate limiters are kept out of the normalized keys,
+// so they are never listed or watched.
+const (
+	semaphoreKey = "__kv_semaphore"
+	rateKey      = "__kv_rate"
+)
+
+// Semaphore creates a semaphore of the key with limit slots.
+// Each semaphore is a holder, and its slot expires after the TTL unless it's acquired again.
+func (r *Redis) Semaphore(key string, limit int, options ...store.SemaphoreOption) (store.Semaphore, error) {
+	sOption := &store.SemaphoreOptions{}
+	for _, option := range options {
+		option(sOption)
+	}
+
+	if limit <= 0 {
+		return nil, store.ErrInvalidLimit
+	}
+	ttl := defaultLockTTL
+	if sOption.TTL != noExpiration {
+		ttl = sOption.TTL
+	}
+
+	return &redisSemaphore{
+		redis: r,
+		key:   limitKey(semaphoreKey, normalize(key)),
+		id:    uuid.NewV4().String(),
+		limit: limit,
+		ttl:   ttl,
+	}, nil
+}
+
+// RateLimiter creates a rate limiter of the key shared by all the clients
+func (r *Redis) RateLimiter(key string, rate store.RateLimit) (store.RateLimiter, error) {
+	if rate.Limit <= 0 || rate.Window < time.Millisecond {
+		return nil, store.ErrInvalidLimit
+	}
+	return &redisRateLimiter{
+		redis: r,
+		key:   limitKey(rateKey, normalize(key)),
+		rate:  rate,
+	}, nil
+}
+
+type redisSemaphore struct {
+	redis *Redis
+	key   string
+	id    string
+	limit int
+	ttl   time.Duration
+}
+
+func (s *redisSemaphore) Acquire(stopCh <-chan struct{}) error {
+	return store.WaitFor(stopCh, func() (bool, time.Duration, error) {
+		ok, err := s.TryAcquire()
+		return ok, 0, err
+	})
+}
+
+func (s *redisSemaphore) TryAcquire() (bool, error) {
+	reply, err := s.redis.runScript(
+		cmdAcquire,
+		[]string{s.key},
+		s.id,
+		s.limit,
+		formatMs(s.ttl),
+	)
+	if err != nil {
+		return false, err
+	}
+	return toRevision(reply) == 1, nil
+}
+
+// Release frees the slot, ErrKeyNotFound is returned if it's not held or expired
+func (s *redisSemaphore) Release() error {
+	reply, err := s.redis.runScript(cmdRelease, []string{s.key}, s.id)
+	if err != nil {
+		return err
+	}
+	if toRevision(reply) == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+type redisRateLimiter struct {
+	redis *Redis
+	key   string
+	rate  store.RateLimit
+}
+
+func (l *redisRateLimiter) Wait(stopCh <-chan struct{}) error {
+	return store.WaitFor(stopCh, l.Allow)
+}
+
+func (l *redisRateLimiter) Allow() (bool, time.Duration, error) {
+	var (
+		reply interface{}
+		err   error
+	)
+	switch l.rate.Algorithm {
+	case store.TokenBucket:
+		reply, err = l.redis.runScript(
+			cmdTokenBucket,
+			[]string{l.key},
+			l.rate.Limit,
+			formatMs(l.rate.Window),
+		)
+	case store.SlidingWindow:
+		reply, err = l.redis.runScript(
+			cmdSlidingWindow,
+			[]string{l.key},
+			uuid.NewV4().String(),
+			l.rate.Limit,
+			formatMs(l.rate.Window),
+		)
+	default:
+		return false, 0, store.ErrNotSupported
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	wait := time.Duration(toRevision(reply)) * time.Millisecond
+	return wait == 0, wait, nil
+}
+
+// limitKey returns the key of a semaphore or a rate limiter, which is in the
+// same cluster slot as its meta keys
+func limitKey(prefix, nKey string) string {
+	return fmt.Sprintf("%s{%s}%s", prefix, hashTag(nKey), nKey)
+}
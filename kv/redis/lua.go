@@ -15,8 +15,21 @@
 package redis
 
 const (
-	cmdCAS = "cas"
-	cmdCAD = "cad"
+	cmdPut   = "put"
+	cmdPutNX = "putnx"
+	cmdCAS   = "cas"
+	cmdCAD   = "cad"
+	cmdDel   = "del"
+	cmdTxn   = "txn"
+	cmdReap  = "reap"
+
+	cmdAcquire       = "acquire"
+	cmdRelease       = "release"
+	cmdTokenBucket   = "bucket"
+	cmdSlidingWindow = "window"
+
+	txnPut    = "put"
+	txnDelete = "del"
 )
 
 func luaScript() string {
@@ -26,18 +39,22 @@ func luaScript() string {
 const luaScriptStr = `
 -- This lua script implements CAS based commands using lua and redis commands.
 
-if #KEYS > 0 then error('No Keys should be provided') end
-if #ARGV <= 0 then error('ARGV should be provided') end
+-- The keys are given in KEYS, so the script can be routed to the node owning the keys in cluster mode.
+-- KEYS starts with the revision counter, the event stream and the expiry set, which are followed
+-- by the keys of the command. The revision of a change is always bumped from the counter here.
+-- ARGV starts with the command, the format of the values and the max length of the event stream,
+-- where 0 means the events are disabled.
 
-local command_name = assert(table.remove(ARGV, 1), 'Must provide a command')
+if #KEYS < 3 then error('The counter, the event stream and the expiry set should be provided') end
+if #ARGV < 3 then error('ARGV should be provided') end
 
-local decode = function(val)
-    return cjson.decode(val)
-end
+local counter = table.remove(KEYS, 1)
+local events = table.remove(KEYS, 1)
+local expiry = table.remove(KEYS, 1)
 
-local encode = function(val)
-    return cjson.encode(val)
-end
+local command_name = assert(table.remove(ARGV, 1), 'Must provide a command')
+local format_name = assert(table.remove(ARGV, 1), 'Must provide a format')
+local maxlen = tonumber(table.remove(ARGV, 1))
 
 local exists = function(key)
     return redis.call('exists', key) == 1
@@ -47,60 +64,372 @@ local get = function(key)
     return redis.call('get', key)
 end
 
-local setex = function(key, val, ex)
-    if ex == "0" then
+-- setpx sets the value with the ttl in milliseconds, 0 means no expiration
+local setpx = function(key, val, px)
+    if px == "0" then
         return redis.call('set', key, val)
     end
-    return redis.call('set', key, val, 'ex', ex)
+    return redis.call('set', key, val, 'px', px)
 end
 
 local del = function(key)
     return redis.call('del', key)
 end
 
--- cas is compare-and-swap function which compare the old value's signature
--- if they are the same, then swap with new val
--- noted that $old and $new are json formatted strings
--- and key is keyed with 'revision'
+-- big-endian unsigned integers of the binary envelope
+local pack = function(n, size)
+    local bytes = {}
+    for i = size, 1, -1 do
+        bytes[i] = string.char(n % 256)
+        n = math.floor(n / 256)
+    end
+    return table.concat(bytes)
+end
+
+local unpack_int = function(s)
+    local n = 0
+    for i = 1, #s do
+        n = n * 256 + string.byte(s, i)
+    end
+    return n
+end
+
+-- binary envelope: version (1 byte) | revision (8 bytes) | key length (4 bytes) | key | value
+local stamp_envelope = function(val, rev)
+    return string.sub(val, 1, 1) .. pack(rev, 8) .. string.sub(val, 10)
+end
+
+local envelope_key_value = function(val)
+    local key_len = unpack_int(string.sub(val, 10, 13))
+    return string.sub(val, 14, 13 + key_len), string.sub(val, 14 + key_len)
+end
+
+-- Formats read the revision of the stored value, and put the encoded value
+-- with the given revision. put returns the encoded value with the revision.
 local revision = "Revision"
+local Formats = {
+    json = {
+        revision = function(key)
+            return cjson.decode(get(key))[revision]
+        end,
+        put = function(key, val, rev, px)
+            local decoded = cjson.decode(val)
+            decoded[revision] = rev
+            local stamped = cjson.encode(decoded)
+            setpx(key, stamped, px)
+            return stamped
+        end
+    },
+    binary = {
+        revision = function(key)
+            return unpack_int(redis.call('getrange', key, 1, 8))
+        end,
+        put = function(key, val, rev, px)
+            local stamped = stamp_envelope(val, rev)
+            setpx(key, stamped, px)
+            return stamped
+        end
+    },
+    hash = {
+        revision = function(key)
+            return tonumber(redis.call('hget', key, 'revision'))
+        end,
+        put = function(key, val, rev, px)
+            local k, v = envelope_key_value(val)
+            del(key)
+            redis.call('hmset', key, 'key', k, 'value', v, 'revision', rev)
+            if px ~= "0" then
+                redis.call('pexpire', key, px)
+            end
+            return stamp_envelope(val, rev)
+        end
+    }
+}
+
+local format = assert(Formats[format_name], 'Unknown format ' .. format_name)
+
+-- now_ms returns the time of the redis server in milliseconds, so the clients
+-- don't depend on their own clocks. The script is replicated by its effects
+-- instead of itself after the time is read, which has to be before any write.
+local now_ms = function()
+    redis.replicate_commands()
+    local t = redis.call('time')
+    return tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+end
+
+-- current returns the revision of the key, or 0 if the key doesn't exist
+local current = function(key)
+    if exists(key) then
+        return format.revision(key)
+    end
+    return 0
+end
+
+-- emit appends the change to the event stream, the id of the event is its revision
+local emit = function(event, key, data, rev, prev)
+    redis.call('xadd', events, 'maxlen', '~', maxlen, rev .. '-0',
+        'type', event, 'key', key, 'data', data, 'revision', rev, 'prev', prev)
+end
+
+-- track keeps the keys with ttl in the expiry set as "<revision>:<key>",
+-- so the expirations can be reported by reap
+local track = function(key, prev, rev, ttl)
+    if prev ~= 0 then
+        redis.call('zrem', expiry, prev .. ':' .. key)
+    end
+    if rev ~= 0 and ttl ~= "0" then
+        redis.call('zadd', expiry, 0, rev .. ':' .. key)
+    end
+end
+
+-- bump returns the next revision of the counter. It's seeded above $prev, so the
+-- revisions of a key never go backwards, including the ones written before the counter.
+local bump = function(prev)
+    local rev = redis.call('incr', counter)
+    if rev <= prev then
+        rev = prev + 1
+        redis.call('set', counter, rev)
+    end
+    return rev
+end
+
+-- put sets the value with the next revision and returns the revision
+local put = function(key, val, ttl)
+    local prev = current(key)
+    local rev = bump(prev)
+    if maxlen <= 0 then
+        format.put(key, val, rev, ttl)
+        return rev
+    end
+
+    local data = format.put(key, val, rev, ttl)
+    track(key, prev, rev, ttl)
+    emit('put', key, data, rev, prev)
+    return rev
+end
+
+-- remove deletes the key of the revision $prev
+local remove = function(key, prev)
+    del(key)
+    if maxlen <= 0 then
+        return
+    end
+
+    local rev = bump(prev)
+    track(key, prev, 0, "0")
+    emit('delete', key, '', rev, prev)
+end
+
+-- putnx is put but only if the key doesn't exist
+local putnx = function(key, val, ttl)
+    if exists(key) then
+        error("redis: key exists")
+    end
+    return put(key, val, ttl)
+end
+
+-- cas is compare-and-swap function which compare the revision of the stored value
+-- with $old, if they are the same, then swap with new val
 local cas = function(key, old, new, ttl)
     if not exists(key) then
         error("redis: key is not found")
     end
-    local decodedOrig = decode(get(key))
-    local decodedOld = decode(old)
-    if decodedOrig[revision] == decodedOld[revision] then
-        setex(key, new, ttl)
-        return "OK"
+    if format.revision(key) == tonumber(old) then
+        return put(key, new, ttl)
     else
         error("redis: value has been changed")
     end
 end
 
--- cad is compare-and-del function which compare the old value's signature
--- if they are the same, then the key will be deleted
--- noted that $old is a json formatted string
--- and key is keyed with 'revision'
+-- cad is compare-and-del function which compare the revision of the stored value
+-- with $old, if they are the same, then the key will be deleted
 local cad = function(key, old)
     if not exists(key) then
         error("redis: key is not found")
     end
-    local decodedOrig = decode(get(key))
-    local decodedOld = decode(old)
-    if decodedOrig[revision] == decodedOld[revision] then
-        del(key)
+    if format.revision(key) == tonumber(old) then
+        remove(key, tonumber(old))
         return "OK"
     else
         error("redis: value has been changed")
     end
 end
 
+-- delete deletes the key if it exists, and returns the number of deleted keys
+local delete = function(key)
+    if not exists(key) then
+        return 0
+    end
+    remove(key, current(key))
+    return 1
+end
+
+-- txn checks the revisions of the first $ncmp keys, where revision 0 means
+-- the key should not exist, and then applies the ops on the rest keys in order.
+-- Each op takes three arguments: the type ('put' or 'del'), the encoded
+-- value and the ttl, which are empty for 'del'.
+-- It returns the revisions of the ops, which are 0 for 'del'.
+local txn = function(keys, ncmp, ...)
+    local args = {...}
+    ncmp = tonumber(ncmp)
+    for i = 1, ncmp do
+        local expected = tonumber(args[i])
+        if expected == 0 then
+            if exists(keys[i]) then
+                error("redis: key exists")
+            end
+        else
+            if not exists(keys[i]) then
+                error("redis: key is not found")
+            end
+            if format.revision(keys[i]) ~= expected then
+                error("redis: value has been changed")
+            end
+        end
+    end
+
+    local revs = {}
+    local pos = ncmp + 1
+    for i = ncmp + 1, #keys do
+        local op = args[pos]
+        if op == 'put' then
+            table.insert(revs, put(keys[i], args[pos + 1], args[pos + 2]))
+        elseif op == 'del' then
+            delete(keys[i])
+            table.insert(revs, 0)
+        else
+            error('Unknown op ' .. tostring(op))
+        end
+        pos = pos + 3
+    end
+    return revs
+end
+
+-- reap reports the expirations of the keys in the expiry set which are due now
+-- in milliseconds. The new keys are due at 0, so they are rescheduled by their ttl here.
+-- It returns the number of the checked keys.
+local reap = function(keys)
+    if maxlen <= 0 then
+        return 0
+    end
+    local now = now_ms()
+
+    local members = redis.call('zrangebyscore', expiry, '-inf', now, 'limit', 0, 100)
+    for _, member in ipairs(members) do
+        local sep = string.find(member, ':', 1, true)
+        local prev = tonumber(string.sub(member, 1, sep - 1))
+        local key = string.sub(member, sep + 1)
+        if current(key) == prev then
+            local pttl = redis.call('pttl', key)
+            if pttl < 0 then
+                redis.call('zrem', expiry, member)
+            else
+                redis.call('zadd', expiry, now + pttl, member)
+            end
+        else
+            redis.call('zrem', expiry, member)
+            if not exists(key) then
+                emit('expire', key, '', bump(prev), prev)
+            end
+        end
+    end
+    return #members
+end
+
+-- acquire takes a slot of the semaphore, which is a sorted set of the holders
+-- scored by the expirations of their slots in milliseconds. The expired slots
+-- are dropped first, and a held slot is renewed.
+-- It returns 1 if the slot is acquired, otherwise 0.
+local acquire = function(key, id, limit, ttl)
+    local now = now_ms()
+    redis.call('zremrangebyscore', key, '-inf', now)
+    if not redis.call('zscore', key, id) and redis.call('zcard', key) >= tonumber(limit) then
+        return 0
+    end
+    redis.call('zadd', key, now + tonumber(ttl), id)
+    -- the set is kept until the last slot expires
+    local last = redis.call('zrange', key, -1, -1, 'withscores')
+    redis.call('pexpireat', key, last[2])
+    return 1
+end
+
+-- release frees the slot of the holder, and returns 0 if it's not held
+local release = function(key, id)
+    local now = now_ms()
+    redis.call('zremrangebyscore', key, '-inf', now)
+    return redis.call('zrem', key, id)
+end
+
+-- bucket takes a token from the token bucket, which is a hash of the tokens and
+-- the time they are counted at. The bucket holds up to $limit tokens and is refilled
+-- by $limit tokens per $window milliseconds.
+-- It returns 0 if a token is taken, otherwise the milliseconds to wait for the next token.
+local bucket = function(key, limit, window)
+    limit = tonumber(limit)
+    window = tonumber(window)
+    local now = now_ms()
+
+    local state = redis.call('hmget', key, 'tokens', 'time')
+    local tokens = tonumber(state[1]) or limit
+    local last = tonumber(state[2]) or now
+    if now > last then
+        tokens = math.min(limit, tokens + (now - last) * limit / window)
+        last = now
+    end
+
+    local wait = 0
+    if tokens >= 1 then
+        tokens = tokens - 1
+    else
+        wait = math.ceil((1 - tokens) * window / limit)
+    end
+    redis.call('hmset', key, 'tokens', tokens, 'time', last)
+    -- the bucket is full again after the window
+    redis.call('pexpire', key, window)
+    return wait
+end
+
+-- window records the call in the sliding window, which is a sorted set of the calls
+-- scored by their times in milliseconds.
+-- It returns 0 if the call is allowed, otherwise the milliseconds to wait until
+-- the oldest call leaves the window.
+local window = function(key, id, limit, window)
+    local now = now_ms()
+    window = tonumber(window)
+
+    redis.call('zremrangebyscore', key, '-inf', now - window)
+    if redis.call('zcard', key) < tonumber(limit) then
+        redis.call('zadd', key, now, id)
+        redis.call('pexpire', key, window)
+        return 0
+    end
+    local oldest = redis.call('zrange', key, 0, 0, 'withscores')
+    return math.max(1, tonumber(oldest[2]) + window - now)
+end
+
+-- single wraps the commands which work on exactly one key
+local single = function(fn)
+    return function(keys, ...)
+        if #keys ~= 1 then error('Exactly one key should be provided') end
+        return fn(keys[1], ...)
+    end
+end
+
 -- Launcher exposes interfaces which be called by passing the arguments.
 local Launcher = {
-    cas = cas,
-    cad = cad
+    put = single(put),
+    putnx = single(putnx),
+    cas = single(cas),
+    cad = single(cad),
+    del = single(delete),
+    txn = txn,
+    reap = reap,
+    acquire = single(acquire),
+    release = single(release),
+    bucket = single(bucket),
+    window = single(window)
 }
 
 local command = assert(Launcher[command_name], 'Unknown command ' .. command_name)
-return command(unpack(ARGV))
+return command(KEYS, unpack(ARGV))
 `
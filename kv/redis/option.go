@@ -20,40 +20,175 @@ import (
 	"gopkg.in/redis.v5"
 )
 
-type RedisOption func(*redis.Options)
+type mode int
+
+const (
+	standaloneMode mode = iota
+	clusterMode
+	sentinelMode
+)
+
+// redisOptions holds the client options and how the client connects to the deployment
+type redisOptions struct {
+	redis.Options
+
+	mode       mode
+	masterName string
+	codec      Codec
+
+	eventStreamLen int64
+	reapInterval   time.Duration
+}
+
+// newClient connects to the deployment of the endpoints
+func (o *redisOptions) newClient(endpoints []string) redisClient {
+	switch o.mode {
+	case clusterMode:
+		return redis.NewClusterClient(o.clusterOptions(endpoints))
+	case sentinelMode:
+		return redis.NewFailoverClient(o.failoverOptions(endpoints))
+	default:
+		opt := o.Options
+		opt.Addr = endpoints[0]
+		return redis.NewClient(&opt)
+	}
+}
+
+// readerOptions returns the options of the dedicated connection of a blocking read,
+// which has to wait longer than the command blocks.
+func (o *redisOptions) readerOptions(block time.Duration) *redisOptions {
+	reader := *o
+	reader.PoolSize = 1
+	reader.ReadTimeout = o.ReadTimeout + block
+	return &reader
+}
+
+// timeoutOptions returns the options of a client whose commands, including the
+// waits for the pool and the dials, are bounded by the timeout
+func (o *redisOptions) timeoutOptions(timeout time.Duration) *redisOptions {
+	opt := *o
+	opt.DialTimeout = timeout
+	opt.ReadTimeout = timeout
+	opt.WriteTimeout = timeout
+	opt.PoolTimeout = timeout
+	return &opt
+}
+
+// maxTimeout returns how long a command may take at most
+func (o *redisOptions) maxTimeout() time.Duration {
+	poolTimeout := o.PoolTimeout
+	if poolTimeout == 0 {
+		// the default of redis.v5
+		poolTimeout = o.ReadTimeout + time.Second
+	}
+	return o.DialTimeout + poolTimeout + o.ReadTimeout + o.WriteTimeout
+}
+
+func (o *redisOptions) clusterOptions(endpoints []string) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Addrs:        endpoints,
+		Password:     o.Password,
+		DialTimeout:  o.DialTimeout,
+		ReadTimeout:  o.ReadTimeout,
+		WriteTimeout: o.WriteTimeout,
+		PoolSize:     o.PoolSize,
+		PoolTimeout:  o.PoolTimeout,
+	}
+}
+
+func (o *redisOptions) failoverOptions(endpoints []string) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:    o.masterName,
+		SentinelAddrs: endpoints,
+		Password:      o.Password,
+		DialTimeout:   o.DialTimeout,
+		ReadTimeout:   o.ReadTimeout,
+		WriteTimeout:  o.WriteTimeout,
+		PoolSize:      o.PoolSize,
+		PoolTimeout:   o.PoolTimeout,
+	}
+}
+
+// RedisOption configures the redis store. It was a func(*redis.Options) before
+// the Cluster and Sentinel options, which can be wrapped by ClientOptions.
+type RedisOption func(*redisOptions)
+
+// ClientOptions applies fn to the options of the redis.v5 client
+func ClientOptions(fn func(*redis.Options)) RedisOption {
+	return func(o *redisOptions) {
+		fn(&o.Options)
+	}
+}
+
+// Cluster connects to a Redis Cluster, the endpoints are the seed nodes of the cluster
+func Cluster() RedisOption {
+	return func(o *redisOptions) {
+		o.mode = clusterMode
+	}
+}
+
+// Sentinel connects to the master of a Sentinel-managed failover group,
+// the endpoints are the addresses of the sentinels
+func Sentinel(masterName string) RedisOption {
+	return func(o *redisOptions) {
+		o.mode = sentinelMode
+		o.masterName = masterName
+	}
+}
+
+// ValueCodec selects how the values are encoded in redis, the default is JSONCodec
+func ValueCodec(codec Codec) RedisOption {
+	return func(o *redisOptions) {
+		o.codec = codec
+	}
+}
+
+// EventStream makes the lua script append every change to a redis stream, which
+// keeps about maxLen events. The watches read the changes from the stream instead
+// of the keyspace notifications, and the expirations are checked every reapInterval.
+// In cluster mode, the events are kept per hash tag, so the watched keys and
+// prefixes have to contain one, and only the expirations of the hash tags being
+// watched are checked.
+// All the clients of the same redis should enable it.
+func EventStream(maxLen int64, reapInterval time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.eventStreamLen = maxLen
+		o.reapInterval = reapInterval
+	}
+}
 
 func DialTimeout(t time.Duration) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.DialTimeout = t
 	}
 }
 
 func ReadTimeout(t time.Duration) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.ReadTimeout = t
 	}
 }
 
 func WriteTimeout(t time.Duration) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.WriteTimeout = t
 	}
 }
 
 func PoolSize(size int) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.PoolSize = size
 	}
 }
 
 func PoolTimeout(t time.Duration) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.PoolTimeout = t
 	}
 }
 
 func Password(pwd string) RedisOption {
-	return func(r *redis.Options) {
+	return func(r *redisOptions) {
 		r.Password = pwd
 	}
 }
@@ -18,7 +18,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	store "github.com/getamis/sirius/kv"
@@ -28,67 +31,135 @@ import (
 
 var (
 	// ErrMultipleEndpointsUnsupported is thrown when there are
-	// multiple endpoints specified for Redis
+	// multiple endpoints specified for a standalone Redis
 	ErrMultipleEndpointsUnsupported = errors.New("redis does not support multiple endpoints")
 
 	// ErrAbortTryLock is thrown when a user stops trying to seek the lock
 	// by sending a signal to the stop chan, this is used to verify if the
 	// operation succeeded
 	ErrAbortTryLock = errors.New("redis: lock operation aborted")
+
+	// ErrInvalidCursor is thrown when the cursor of ListPage is malformed
+	ErrInvalidCursor = errors.New("redis: invalid list cursor")
+
+	// ErrHashTagRequired is thrown when a key without a hash tag is written in cluster mode
+	ErrHashTagRequired = errors.New("redis: hash tag is required in cluster mode")
 )
 
 // New creates a new Redis client given a list
-// of endpoints and optional config
+// of endpoints and optional config.
+// Multiple endpoints are only allowed with the Cluster or Sentinel option.
+// In cluster mode, the keys written have to contain a hash tag, e.g. "{tenant}/key",
+// which shares the revision counter among the keys of the tag, or
+// ErrHashTagRequired is returned.
+// The commands are bounded by the ReadTimeout and WriteTimeout options, or by
+// the deadline of the context of the kv.NewContextStore variant if it's sooner.
 func New(endpoints []string, notification bool, options ...RedisOption) (*Redis, error) {
-	if len(endpoints) > 1 {
+	if len(endpoints) > 1 && newOptions(options...).mode == standaloneMode {
 		return nil, ErrMultipleEndpointsUnsupported
 	}
 
 	return new(endpoints, notification, options...), nil
 }
 
-func new(endpoints []string, notification bool, options ...RedisOption) *Redis {
-	rOption := &redis.Options{
-		Addr:         endpoints[0],
-		Password:     "", //TODO: make sure that the password can be supported in *redis.ClusterClient
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+func newOptions(options ...RedisOption) *redisOptions {
+	rOption := &redisOptions{
+		Options: redis.Options{
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		},
+		codec: JSONCodec,
 	}
 	for _, option := range options {
 		option(rOption)
 	}
+	return rOption
+}
 
-	client := redis.NewClient(rOption)
+func new(endpoints []string, notification bool, options ...RedisOption) *Redis {
+	rOption := newOptions(options...)
+
+	readerOption := rOption.readerOptions(eventBlock)
+	r := &Redis{
+		client: rOption.newClient(endpoints),
+		redisState: &redisState{
+			newReader: func() redisClient {
+				return readerOption.newClient(endpoints)
+			},
+			newTimeoutClient: func(timeout time.Duration) redisClient {
+				return rOption.timeoutOptions(timeout).newClient(endpoints)
+			},
+			maxTimeout: rOption.maxTimeout(),
+			views:      make(map[time.Duration]*Redis),
+
+			script: redis.NewScript(luaScript()),
+			codec:  rOption.codec,
+
+			eventStreamLen: rOption.eventStreamLen,
+			closeCh:        make(chan struct{}),
+			streams:        make(map[string]int),
+		},
+	}
 	if notification {
 		// NOTE: please turn on redis's notification
-		// before you using watch/watchtree/lock related features
-		client.ConfigSet("notify-keyspace-events", "KA")
+		// before you using watch/watchtree/lock related features.
+		// The notifications are node-local, so every master has to be configured.
+		r.forEachMaster(func(c *redis.Client) error {
+			return c.ConfigSet("notify-keyspace-events", "KA").Err()
+		})
 	}
-
-	return &Redis{
-		client: client,
-		script: redis.NewScript(luaScript()),
-		codec:  defaultCodec{},
+	if r.eventStreamLen > 0 {
+		go r.reapLoop(rOption.reapInterval)
 	}
+	return r
 }
 
-type defaultCodec struct{}
-
-func (c defaultCodec) encode(kv *store.KeyValue) (string, error) {
-	b, err := json.Marshal(kv)
-	return string(b), err
-}
-
-func (c defaultCodec) decode(b string, kv *store.KeyValue) error {
-	return json.Unmarshal([]byte(b), kv)
+// redisClient is implemented by *redis.Client, the sentinel failover client
+// and *redis.ClusterClient
+type redisClient interface {
+	redis.Cmdable
+	Process(cmd redis.Cmder) error
+	Close() error
 }
 
 // Redis implements libkv.Store interface with redis backend
 type Redis struct {
-	client *redis.Client
+	client redisClient
+	*redisState
+}
+
+// redisState is shared by the store and its views of the shorter timeouts
+type redisState struct {
 	script *redis.Script
-	codec  defaultCodec
+	codec  Codec
+
+	// newReader connects a dedicated client for the blocking reads of the events
+	newReader func() redisClient
+
+	// newTimeoutClient connects a client of the given timeouts for the views,
+	// which are used by the contexts with a deadline sooner than maxTimeout
+	newTimeoutClient func(time.Duration) redisClient
+	maxTimeout       time.Duration
+	viewsMu          sync.Mutex
+	views            map[time.Duration]*Redis
+
+	eventStreamLen int64
+	closeCh        chan struct{}
+	closeOnce      sync.Once
+
+	// streams are the event streams being read with the number of their readers
+	streamsMu sync.Mutex
+	streams   map[string]int
+}
+
+// forEachMaster calls fn on every master node, which is the client itself
+// unless it's connected to a cluster. fn may be called concurrently.
+func (r *Redis) forEachMaster(fn func(*redis.Client) error) error {
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(fn)
+	}
+	return fn(r.client.(*redis.Client))
 }
 
 const (
@@ -107,20 +178,22 @@ func (r *Redis) Put(key string, value []byte, options ...store.PutOption) error
 		expirationAfter = pOption.TTL
 	}
 
-	return r.setTTL(normalize(key), &store.KeyValue{
-		Key:      key,
-		Value:    value,
-		Revision: sequenceNum(),
-	}, expirationAfter)
-}
-
-func (r *Redis) setTTL(key string, val *store.KeyValue, ttl time.Duration) error {
-	valStr, err := r.codec.encode(val)
+	nKey := normalize(key)
+	valStr, err := r.codec.encode(&store.KeyValue{
+		Key:   key,
+		Value: value,
+	})
 	if err != nil {
 		return err
 	}
 
-	return r.client.Set(key, valStr, ttl).Err()
+	_, err = r.runScript(
+		cmdPut,
+		[]string{nKey},
+		valStr,
+		formatMs(expirationAfter),
+	)
+	return err
 }
 
 // Get a value given its key
@@ -129,7 +202,7 @@ func (r *Redis) Get(key string) (*store.KeyValue, error) {
 }
 
 func (r *Redis) get(key string) (*store.KeyValue, error) {
-	reply, err := r.client.Get(key).Bytes()
+	reply, err := r.read(r.client, key)()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, store.ErrKeyNotFound
@@ -137,7 +210,7 @@ func (r *Redis) get(key string) (*store.KeyValue, error) {
 		return nil, err
 	}
 	val := store.KeyValue{}
-	if err := r.codec.decode(string(reply), &val); err != nil {
+	if err := r.codec.decode(reply, &val); err != nil {
 		return nil, err
 	}
 	return &val, nil
@@ -145,7 +218,7 @@ func (r *Redis) get(key string) (*store.KeyValue, error) {
 
 // Delete the value at the specified key
 func (r *Redis) Delete(key string) error {
-	return r.client.Del(normalize(key)).Err()
+	return r.del(normalize(key))
 }
 
 // Exists verify if a Key exists in the store
@@ -153,12 +226,59 @@ func (r *Redis) Exists(key string) (bool, error) {
 	return r.client.Exists(normalize(key)).Result()
 }
 
+// TTL returns the remaining TTL of the key, which is 0 if the key doesn't expire
+func (r *Redis) TTL(key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(normalize(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2ms if the key doesn't exist, and -1ms if it has no expiration
+	switch {
+	case ttl == -2*time.Millisecond:
+		return 0, store.ErrKeyNotFound
+	case ttl < 0:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// TTLs returns the remaining TTLs of the keys in a pipeline, and the keys which
+// don't exist are left out
+func (r *Redis) TTLs(keys []string) (map[string]time.Duration, error) {
+	cmds := make([]*redis.DurationCmd, len(keys))
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		for i, key := range keys {
+			cmds[i] = pipe.PTTL(normalize(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ttls := make(map[string]time.Duration, len(keys))
+	for i, cmd := range cmds {
+		switch ttl := cmd.Val(); {
+		case ttl == -2*time.Millisecond:
+		case ttl < 0:
+			ttls[keys[i]] = 0
+		default:
+			ttls[keys[i]] = ttl
+		}
+	}
+	return ttls, nil
+}
+
 // Watch for changes on a key
 // glitch: we use notified-then-retrieve to retrieve *store.KeyValue.
-// so the responses may sometimes inaccurate
+// so the responses may sometimes inaccurate, unless the EventStream option is set.
 func (r *Redis) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KeyValue, error) {
-	watchCh := make(chan *store.KeyValue)
 	nKey := normalize(key)
+	if r.eventStreamLen > 0 {
+		return r.watchKey(nKey, stopCh)
+	}
+
+	watchCh := make(chan *store.KeyValue)
 
 	get := getter(func() (interface{}, error) {
 		pair, err := r.get(nKey)
@@ -174,7 +294,7 @@ func (r *Redis) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KeyValu
 		}
 	})
 
-	sub, err := newSubscribe(r.client, regexWatch(nKey, false))
+	sub, err := newSubscribe(r, regexWatch(nKey, false))
 	if err != nil {
 		return nil, err
 	}
@@ -230,36 +350,65 @@ func watchLoop(msgCh chan *redis.Message, stopCh <-chan struct{}, get getter, pu
 	return nil
 }
 
+// subscribe receives the keyspace notifications from every master
 type subscribe struct {
-	pubsub  *redis.PubSub
+	pubsubs []*redis.PubSub
 	closeCh chan struct{}
 }
 
-func newSubscribe(client *redis.Client, regex string) (*subscribe, error) {
-	ch, err := client.PSubscribe(regex)
+func newSubscribe(r *Redis, regex string) (*subscribe, error) {
+	var mu sync.Mutex
+	s := &subscribe{
+		closeCh: make(chan struct{}),
+	}
+	err := r.forEachMaster(func(client *redis.Client) error {
+		ch, err := client.PSubscribe(regex)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		s.pubsubs = append(s.pubsubs, ch)
+		mu.Unlock()
+		return nil
+	})
 	if err != nil {
+		s.Close()
 		return nil, err
 	}
-	return &subscribe{
-		pubsub:  ch,
-		closeCh: make(chan struct{}),
-	}, nil
+	return s, nil
 }
 
 func (s *subscribe) Close() error {
 	close(s.closeCh)
-	return s.pubsub.Close()
+
+	var err error
+	for _, pubsub := range s.pubsubs {
+		if e := pubsub.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (s *subscribe) Receive(stopCh <-chan struct{}) chan *redis.Message {
 	msgCh := make(chan *redis.Message)
-	go s.receiveLoop(msgCh, stopCh)
+
+	var wg sync.WaitGroup
+	for _, pubsub := range s.pubsubs {
+		wg.Add(1)
+		go func(pubsub *redis.PubSub) {
+			defer wg.Done()
+			s.receiveLoop(pubsub, msgCh, stopCh)
+		}(pubsub)
+	}
+	go func() {
+		wg.Wait()
+		close(msgCh)
+	}()
 	return msgCh
 }
 
-func (s *subscribe) receiveLoop(msgCh chan *redis.Message, stopCh <-chan struct{}) {
-	defer close(msgCh)
-
+func (s *subscribe) receiveLoop(pubsub *redis.PubSub, msgCh chan *redis.Message, stopCh <-chan struct{}) {
 	for {
 		select {
 		case <-s.closeCh:
@@ -267,7 +416,7 @@ func (s *subscribe) receiveLoop(msgCh chan *redis.Message, stopCh <-chan struct{
 		case <-stopCh:
 			return
 		default:
-			msg, err := s.pubsub.ReceiveMessage()
+			msg, err := pubsub.ReceiveMessage()
 			if err != nil {
 				return
 			}
@@ -281,8 +430,12 @@ func (s *subscribe) receiveLoop(msgCh chan *redis.Message, stopCh <-chan struct{
 // WatchTree watches for changes on child nodes under
 // a given directory
 func (r *Redis) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KeyValue, error) {
-	watchCh := make(chan []*store.KeyValue)
 	nKey := normalize(directory)
+	if r.eventStreamLen > 0 {
+		return r.watchTree(nKey, stopCh)
+	}
+
+	watchCh := make(chan []*store.KeyValue)
 
 	get := getter(func() (interface{}, error) {
 		pair, err := r.list(nKey)
@@ -299,7 +452,7 @@ func (r *Redis) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*s
 		watchCh <- v.([]*store.KeyValue)
 	})
 
-	sub, err := newSubscribe(r.client, regexWatch(nKey, true))
+	sub, err := newSubscribe(r, regexWatch(nKey, true))
 	if err != nil {
 		return nil, err
 	}
@@ -319,6 +472,9 @@ func (r *Redis) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*s
 // NewLock creates a lock for a given key.
 // The returned Locker is not held and must be acquired
 // with `.Lock`. The Value is optional.
+// The locks of the same key and Owner share the acquisition across the clients,
+// so they are reentrant and the key is released after all of them are unlocked.
+// The owner and the number of the holders are kept with the Value in the key.
 func (r *Redis) Lock(key string, options ...store.LockOption) (store.Locker, error) {
 	var (
 		value []byte
@@ -338,123 +494,380 @@ func (r *Redis) Lock(key string, options ...store.LockOption) (store.Locker, err
 	}
 
 	return &redisLock{
-		redis:    r,
-		last:     nil,
-		key:      key,
-		value:    value,
-		ttl:      ttl,
-		unlockCh: make(chan struct{}),
+		redis:   r,
+		key:     key,
+		value:   value,
+		ttl:     ttl,
+		renewCh: lOption.RenewLock,
+		owner:   lOption.Owner,
 	}, nil
 }
 
+// redisLock creates the key by putNX and renews it by CAS every ttl/3.
+// The revision of the creation is the fencing token.
+// The value of a lock with an owner is a lockValue, which keeps the owner and the
+// number of the holders in redis, so the locks of the owner reenter it by CAS.
 type redisLock struct {
-	redis    *Redis
+	redis   *Redis
+	key     string
+	value   []byte
+	ttl     time.Duration
+	renewCh chan struct{}
+	owner   string
+
+	mu    sync.Mutex
+	hold  *lockHold
+	depth int
+	token uint64
+}
+
+// lockHold is an acquisition of the key by a lock
+type lockHold struct {
+	token uint64
+
+	// last is only accessed by the renewal until lockHeld is closed,
+	// and err is set before lockHeld is closed
 	last     *store.KeyValue
+	err      error
+	lockHeld chan struct{}
 	unlockCh chan struct{}
+}
+
+// lockValue is the value of a lock with an owner
+type lockValue struct {
+	Owner   string `json:"owner"`
+	Token   uint64 `json:"token"`
+	Holders int    `json:"holders"`
+	Value   []byte `json:"value"`
+}
+
+// decodeLockValue returns the lockValue of the pair. The token is written by the
+// first change after the creation, so it's the revision of the pair until then.
+func decodeLockValue(pair *store.KeyValue) (*lockValue, bool) {
+	v := &lockValue{}
+	if err := json.Unmarshal(pair.Value, v); err != nil || v.Owner == "" {
+		return nil, false
+	}
+	if v.Token == 0 {
+		v.Token = pair.Revision
+	}
+	return v, true
+}
 
-	key   string
-	value []byte
-	ttl   time.Duration
+func (v *lockValue) encode() []byte {
+	b, _ := json.Marshal(v)
+	return b
 }
 
 func (l *redisLock) Lock(stopCh chan struct{}) (<-chan struct{}, error) {
-	lockHeld := make(chan struct{})
+	return l.lock(stopCh, stopCh)
+}
+
+// lock stops trying on abortCh, and stops holding the lock on stopCh
+func (l *redisLock) lock(abortCh <-chan struct{}, stopCh chan struct{}) (<-chan struct{}, error) {
+	if l.redis.eventStreamLen > 0 {
+		return l.lockByEvents(abortCh, stopCh)
+	}
 
-	success, err := l.tryLock(lockHeld, stopCh)
+	if aborted(abortCh) {
+		return nil, ErrAbortTryLock
+	}
+	lockHeld, err := l.tryLock(stopCh)
 	if err != nil {
 		return nil, err
 	}
-	if success {
+	if lockHeld != nil {
 		return lockHeld, nil
 	}
 
 	// wait for changes on the key
-	watch, err := l.redis.Watch(l.key, stopCh)
+	watch, err := l.redis.Watch(l.key, abortCh)
 	if err != nil {
 		return nil, err
 	}
 
 	for {
 		select {
-		case <-stopCh:
+		case <-abortCh:
 			return nil, ErrAbortTryLock
 		case <-watch:
-			success, err := l.tryLock(lockHeld, stopCh)
+			// the abort wins over a change which comes at the same time
+			if aborted(abortCh) {
+				return nil, ErrAbortTryLock
+			}
+			lockHeld, err := l.tryLock(stopCh)
 			if err != nil {
 				return nil, err
 			}
-			if success {
+			if lockHeld != nil {
 				return lockHeld, nil
 			}
 		}
 	}
 }
 
-// tryLock return true, nil when it acquired and hold the lock
-// and return false, nil when it can't lock now,
-// and return false, err if any unespected error happened underlying
-func (l *redisLock) tryLock(lockHeld, stopChan chan struct{}) (bool, error) {
-	success, new, err := l.redis.atomicPut(
+// aborted returns whether abortCh is closed
+func aborted(abortCh <-chan struct{}) bool {
+	select {
+	case <-abortCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// tryLock returns the lockHeld channel if the lock is acquired or reentered,
+// or nil if the lock is held by someone else
+func (l *redisLock) tryLock(stopCh chan struct{}) (<-chan struct{}, error) {
+	value := l.value
+	if l.owner != "" {
+		lockHeld, err := l.reenter(stopCh)
+		if lockHeld != nil || err != nil {
+			return lockHeld, err
+		}
+		value = (&lockValue{Owner: l.owner, Holders: 1, Value: l.value}).encode()
+	}
+
+	_, new, err := l.redis.atomicPut(
 		l.key,
-		l.value,
-		l.last,
+		value,
+		nil,
 		store.PutExpiration(l.ttl),
 	)
-	if success {
-		l.last = new
-		// keep holding
-		go l.holdLock(lockHeld, stopChan)
-		return true, nil
+	if err == store.ErrKeyNotFound || err == store.ErrKeyModified || err == store.ErrKeyExists {
+		return nil, nil
 	}
-	if err != nil && (err == store.ErrKeyNotFound || err == store.ErrKeyModified || err == store.ErrKeyExists) {
-		return false, nil
+	if err != nil {
+		return nil, err
 	}
-	return false, err
+	return l.acquire(new.Revision, new, stopCh), nil
 }
 
-func (l *redisLock) holdLock(lockHeld, stopChan chan struct{}) {
-	defer close(lockHeld)
+// reenter joins the acquisition of the same owner, which is held by this lock or is
+// found in the value of the key. It returns nil if the key isn't held by the owner.
+func (l *redisLock) reenter(stopCh chan struct{}) (<-chan struct{}, error) {
+	l.mu.Lock()
+	if h := l.hold; h != nil {
+		select {
+		case <-h.lockHeld:
+		default:
+			l.depth++
+			l.mu.Unlock()
+			return h.lockHeld, nil
+		}
+	}
+	l.mu.Unlock()
+
+	for {
+		pair, err := l.redis.Get(l.key)
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, ok := decodeLockValue(pair)
+		if !ok || v.Owner != l.owner {
+			return nil, nil
+		}
 
-	hold := func() error {
+		v.Holders++
 		_, new, err := l.redis.atomicPut(
 			l.key,
-			l.value,
-			l.last,
+			v.encode(),
+			pair,
 			store.PutExpiration(l.ttl),
 		)
-		if err == nil {
-			l.last = new
+		// retry if the key is changed by the other holders
+		if err == store.ErrKeyNotFound || err == store.ErrKeyModified {
+			continue
 		}
-		return err
+		if err != nil {
+			return nil, err
+		}
+		return l.acquire(v.Token, new, stopCh), nil
 	}
+}
+
+// acquire keeps holding the acquisition of the token
+func (l *redisLock) acquire(token uint64, last *store.KeyValue, stopCh chan struct{}) <-chan struct{} {
+	h := &lockHold{
+		token:    token,
+		last:     last,
+		lockHeld: make(chan struct{}),
+		unlockCh: make(chan struct{}),
+	}
+
+	l.mu.Lock()
+	l.hold = h
+	l.depth = 1
+	l.token = token
+	l.mu.Unlock()
 
+	// keep holding
+	go l.holdLock(h, stopCh)
+	return h.lockHeld
+}
+
+// holdLock renews the lock until it's unlocked or lost. A failed renewal is
+// retried unless the key is changed, until the ttl is reached.
+func (l *redisLock) holdLock(h *lockHold, stopCh chan struct{}) {
+	defer close(h.lockHeld)
+
+	renewed := time.Now()
 	heartbeat := time.NewTicker(l.ttl / 3)
 	defer heartbeat.Stop()
 
 	for {
 		select {
 		case <-heartbeat.C:
-			if err := hold(); err != nil {
+			err := l.renew(h)
+			if err == nil {
+				renewed = time.Now()
+				continue
+			}
+
+			lost := lockLost(err)
+			if lost != store.ErrLockUnreachable || time.Since(renewed) >= l.ttl {
+				h.err = lost
 				return
 			}
-		case <-l.unlockCh:
+			log.Warn("Failed to renew the lock", "key", l.key, "err", err)
+		case <-h.unlockCh:
 			return
-		case <-stopChan:
+		case <-stopCh:
+			return
+		case <-l.renewCh:
+			return
+		case <-l.redis.closeCh:
+			h.err = store.ErrLockUnreachable
 			return
 		}
 	}
 }
 
+// renew puts the value again with the ttl
+func (l *redisLock) renew(h *lockHold) error {
+	if l.owner == "" {
+		_, new, err := l.redis.atomicPut(
+			l.key,
+			l.value,
+			h.last,
+			store.PutExpiration(l.ttl),
+		)
+		if err == nil {
+			h.last = new
+		}
+		return err
+	}
+	return l.change(h, func(v *lockValue) bool {
+		return false
+	})
+}
+
+// change updates the lockValue of the acquisition by CAS, or deletes the key if
+// fn returns true. It's retried while the key is changed by the other holders of
+// the acquisition, and returns ErrKeyModified if the key is acquired by someone else.
+func (l *redisLock) change(h *lockHold, fn func(v *lockValue) bool) error {
+	pair := h.last
+	for {
+		v, ok := decodeLockValue(pair)
+		if !ok || v.Owner != l.owner || v.Token != h.token {
+			return store.ErrKeyModified
+		}
+
+		var err error
+		if fn(v) {
+			_, err = l.redis.AtomicDelete(l.key, pair)
+		} else {
+			var new *store.KeyValue
+			_, new, err = l.redis.atomicPut(
+				l.key,
+				v.encode(),
+				pair,
+				store.PutExpiration(l.ttl),
+			)
+			if err == nil {
+				h.last = new
+			}
+		}
+		if err != store.ErrKeyModified {
+			return err
+		}
+
+		pair, err = l.redis.Get(l.key)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Unlock releases the key after all the reentrant locks are unlocked
 func (l *redisLock) Unlock() error {
-	l.unlockCh <- struct{}{}
+	l.mu.Lock()
+	h := l.hold
+	if h != nil {
+		l.depth--
+		if l.depth > 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		l.hold = nil
+	}
+	l.mu.Unlock()
+	if h == nil {
+		return store.ErrKeyNotFound
+	}
 
-	_, err := l.redis.AtomicDelete(l.key, l.last)
-	if err != nil {
+	close(h.unlockCh)
+	// the last revision is settled after the renewal is stopped
+	<-h.lockHeld
+
+	if l.owner == "" {
+		_, err := l.redis.AtomicDelete(l.key, h.last)
 		return err
 	}
-	l.last = nil
+	// the key is released by the last holder of the acquisition
+	return l.change(h, func(v *lockValue) bool {
+		v.Holders--
+		return v.Holders <= 0
+	})
+}
+
+// Token returns the revision at which the key is created by the last acquisition
+func (l *redisLock) Token() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.token
+}
+
+// Err returns why the lock is lost, or nil if it's still held
+func (l *redisLock) Err() error {
+	l.mu.Lock()
+	h := l.hold
+	l.mu.Unlock()
+	if h == nil {
+		return nil
+	}
 
-	return err
+	select {
+	case <-h.lockHeld:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// lockLost returns why the lock is lost after its renewal fails
+func lockLost(err error) error {
+	switch err {
+	case store.ErrKeyNotFound:
+		return store.ErrLockExpired
+	case store.ErrKeyModified, store.ErrKeyExists:
+		return store.ErrLockStolen
+	}
+	return store.ErrLockUnreachable
 }
 
 // List the content of a given prefix
@@ -462,63 +875,200 @@ func (r *Redis) List(directory string) ([]*store.KeyValue, error) {
 	return r.list(normalize(directory))
 }
 
+// list fetches the values batch by batch while scanning the keys,
+// so a large directory doesn't block redis.
 func (r *Redis) list(directory string) ([]*store.KeyValue, error) {
-
-	var allKeys []string
+	l := newListing(directory)
 	regex := scanRegex(directory) // for all keyed with $directory
-	allKeys, err := r.keys(regex)
+	err := r.scanAll(regex, func(keys []string) error {
+		return l.add(r, keys)
+	})
 	if err != nil {
 		return nil, err
 	}
-	// TODO: need to handle when #key is too large
-	return r.mget(directory, allKeys...)
+	return l.result()
 }
 
-func (r *Redis) keys(regex string) ([]string, error) {
-	const (
-		startCursor  = 0
-		endCursor    = 0
-		defaultCount = 10
-	)
+// listing collects the values of a directory batch by batch
+type listing struct {
+	directory string
+	found     bool
+	seen      map[string]struct{}
+	pairs     []*store.KeyValue
+}
 
-	var allKeys []string
+func newListing(directory string) *listing {
+	return &listing{
+		directory: directory,
+		seen:      make(map[string]struct{}),
+		pairs:     []*store.KeyValue{},
+	}
+}
+
+// add gets the values of the keys by r
+func (l *listing) add(r *Redis, keys []string) error {
+	l.found = true
+
+	// SCAN may return a key more than once
+	var unseen []string
+	for _, key := range keys {
+		if _, ok := l.seen[key]; !ok {
+			l.seen[key] = struct{}{}
+			unseen = append(unseen, key)
+		}
+	}
 
-	keys, nextCursor, err := r.client.Scan(startCursor, regex, defaultCount).Result()
+	batch, err := r.mget(l.directory, unseen...)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	l.pairs = append(l.pairs, batch...)
+	return nil
+}
+
+func (l *listing) result() ([]*store.KeyValue, error) {
+	if !l.found {
+		return nil, store.ErrKeyNotFound
 	}
-	allKeys = append(allKeys, keys...)
-	for nextCursor != endCursor {
-		keys, nextCursor, err = r.client.Scan(nextCursor, regex, defaultCount).Result()
+	return l.pairs, nil
+}
+
+// ListPage lists a page of the content of a given prefix.
+// The limit is passed to SCAN as COUNT, so it's only a hint of the page size,
+// and a key may show up in more than one page.
+func (r *Redis) ListPage(directory string, options ...store.ListOption) ([]*store.KeyValue, string, error) {
+	lOption := store.NewListOptions(options...)
+
+	nKey := normalize(directory)
+	keys, cursor, err := r.scanPage(scanRegex(nKey), lOption.Cursor, int64(lOption.Limit))
+	if err != nil {
+		return nil, "", err
+	}
+	pairs, err := r.mget(nKey, keys...)
+	if err != nil {
+		return nil, "", err
+	}
+	return pairs, cursor, nil
+}
+
+const defaultScanCount = 100
+
+// scanAll scans all the keys matching the regex and calls fn batch by batch
+func (r *Redis) scanAll(regex string, fn func(keys []string) error) error {
+	cursor := ""
+	for {
+		keys, next, err := r.scanPage(regex, cursor, defaultScanCount)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
 		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
 
-		allKeys = append(allKeys, keys...)
+// scanPage scans the masters one after another until some keys are found.
+// The cursor is formatted as "<index of the master>:<cursor of SCAN>",
+// and it's empty after all the masters are scanned.
+func (r *Redis) scanPage(regex string, cursor string, count int64) ([]string, string, error) {
+	index, next, err := parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
 	}
-	if len(allKeys) == 0 {
-		return nil, store.ErrKeyNotFound
+	masters, err := r.masters()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keys []string
+	for index < len(masters) {
+		keys, next, err = masters[index].Scan(next, regex, count).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if next == 0 {
+			index++
+		}
+		if len(keys) > 0 {
+			break
+		}
+	}
+	if index >= len(masters) {
+		return keys, "", nil
 	}
-	return allKeys, nil
+	return keys, fmt.Sprintf("%d:%d", index, next), nil
 }
 
-// mget values given their keys
-func (r *Redis) mget(directory string, keys ...string) ([]*store.KeyValue, error) {
-	replies, err := r.client.MGet(keys...).Result()
+func parseCursor(cursor string) (int, uint64, error) {
+	if cursor == "" {
+		return 0, 0, nil
+	}
+
+	var (
+		index int
+		next  uint64
+	)
+	if _, err := fmt.Sscanf(cursor, "%d:%d", &index, &next); err != nil || index < 0 {
+		return 0, 0, ErrInvalidCursor
+	}
+	return index, next, nil
+}
+
+// masters returns the master nodes in a stable order
+func (r *Redis) masters() ([]*redis.Client, error) {
+	var (
+		mu      sync.Mutex
+		clients []*redis.Client
+	)
+	err := r.forEachMaster(func(client *redis.Client) error {
+		mu.Lock()
+		clients = append(clients, client)
+		mu.Unlock()
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].String() < clients[j].String()
+	})
+	return clients, nil
+}
 
+// mget values given their keys.
+// The keys may belong to different cluster slots, so they are pipelined as GETs
+// instead of a single MGET.
+func (r *Redis) mget(directory string, keys ...string) ([]*store.KeyValue, error) {
 	pairs := []*store.KeyValue{}
-	for _, reply := range replies {
-		var sreply string
-		if _, ok := reply.(string); ok {
-			sreply = reply.(string)
-		}
-		if sreply == "" {
+	if len(keys) == 0 {
+		return pairs, nil
+	}
+
+	pipe := r.client.Pipeline()
+	defer pipe.Close()
+
+	results := make([]func() (string, error), len(keys))
+	for i, key := range keys {
+		results[i] = r.read(pipe, key)
+	}
+	// errors are checked for each command, since a missing key fails with redis.Nil
+	pipe.Exec()
+
+	for _, result := range results {
+		sreply, err := result()
+		if err == redis.Nil || sreply == "" {
 			// empty reply
 			continue
 		}
+		if err != nil {
+			return nil, err
+		}
 
 		newkv := &store.KeyValue{}
 		if err := r.codec.decode(sreply, newkv); err != nil {
@@ -532,16 +1082,40 @@ func (r *Redis) mget(directory string, keys ...string) ([]*store.KeyValue, error
 }
 
 // DeleteTree deletes a range of keys under a given directory
-// glitch: we list all available keys first and then delete them all
-// it costs two operations on redis, so is not atomicity.
+// glitch: we delete the keys batch by batch while scanning them,
+// so is not atomicity.
 func (r *Redis) DeleteTree(directory string) error {
-	var allKeys []string
+	found := false
 	regex := scanRegex(normalize(directory)) // for all keyed with $directory
-	allKeys, err := r.keys(regex)
+	err := r.scanAll(regex, func(keys []string) error {
+		found = true
+		return r.del(keys...)
+	})
 	if err != nil {
 		return err
 	}
-	return r.client.Del(allKeys...).Err()
+	if !found {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// del deletes the keys one by one in a pipeline,
+// since the keys may belong to different cluster slots
+func (r *Redis) del(keys ...string) error {
+	// the deletions are reported to the event stream by the lua script
+	if r.eventStreamLen > 0 {
+		return r.pipeScript(cmdDel, keys)
+	}
+
+	pipe := r.client.Pipeline()
+	defer pipe.Close()
+
+	for _, key := range keys {
+		pipe.Del(key)
+	}
+	_, err := pipe.Exec()
+	return err
 }
 
 // AtomicPut is an atomic CAS operation on a single value.
@@ -563,61 +1137,63 @@ func (r *Redis) atomicPut(key string, value []byte, previous *store.KeyValue, op
 	}
 
 	newKV := &store.KeyValue{
-		Key:      key,
-		Value:    value,
-		Revision: sequenceNum(),
+		Key:   key,
+		Value: value,
 	}
 	nKey := normalize(key)
 
-	// if previous == nil, set directly
+	var (
+		revision uint64
+		err      error
+	)
+	// if previous == nil, set only if the key doesn't exist
 	if previous == nil {
-		if err := r.setNX(nKey, newKV, expirationAfter); err != nil {
-			return false, nil, err
-		}
-		return true, newKV, nil
+		revision, err = r.setNX(nKey, newKV, formatMs(expirationAfter))
+	} else {
+		revision, err = r.cas(nKey, previous, newKV, formatMs(expirationAfter))
 	}
-
-	if err := r.cas(
-		nKey,
-		previous,
-		newKV,
-		formatSec(expirationAfter),
-	); err != nil {
+	if err != nil {
 		return false, nil, err
 	}
+	newKV.Revision = revision
 	return true, newKV, nil
 }
 
-func (r *Redis) setNX(key string, val *store.KeyValue, expirationAfter time.Duration) error {
+func (r *Redis) setNX(key string, val *store.KeyValue, msInStr string) (uint64, error) {
 	valBlob, err := r.codec.encode(val)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if !r.client.SetNX(key, valBlob, expirationAfter).Val() {
-		return store.ErrKeyExists
+	reply, err := r.runScript(
+		cmdPutNX,
+		[]string{key},
+		valBlob,
+		msInStr,
+	)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return toRevision(reply), nil
 }
 
-func (r *Redis) cas(key string, old, new *store.KeyValue, secInStr string) error {
+func (r *Redis) cas(key string, old, new *store.KeyValue, msInStr string) (uint64, error) {
 	newVal, err := r.codec.encode(new)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	oldVal, err := r.codec.encode(old)
-	if err != nil {
-		return err
-	}
-
-	return r.runScript(
+	reply, err := r.runScript(
 		cmdCAS,
-		key,
-		oldVal,
+		[]string{key},
+		strconv.FormatUint(old.Revision, 10),
 		newVal,
-		secInStr,
+		msInStr,
 	)
+	if err != nil {
+		return 0, err
+	}
+	return toRevision(reply), nil
 }
 
 // AtomicDelete is an atomic delete operation on a single value
@@ -630,21 +1206,78 @@ func (r *Redis) AtomicDelete(key string, previous *store.KeyValue) (bool, error)
 }
 
 func (r *Redis) cad(key string, old *store.KeyValue) error {
-	oldVal, err := r.codec.encode(old)
-	if err != nil {
-		return err
+	if old == nil {
+		return store.ErrKeyModified
 	}
 
-	return r.runScript(
+	_, err := r.runScript(
 		cmdCAD,
-		key,
-		oldVal,
+		[]string{key},
+		strconv.FormatUint(old.Revision, 10),
 	)
+	return err
+}
+
+// Txn runs the compares and the ops in the lua script, so they are applied atomically.
+// In cluster mode, all the keys have to be in the same hash slot, e.g. by sharing a hash tag.
+func (r *Redis) Txn(compares []store.Compare, ops []store.Op) ([]*store.KeyValue, error) {
+	keys := make([]string, 0, 1+len(compares)+len(ops))
+	args := []interface{}{strconv.Itoa(len(compares))}
+	for _, cmp := range compares {
+		keys = append(keys, normalize(cmp.Key))
+		args = append(args, strconv.FormatUint(cmp.Revision, 10))
+	}
+
+	results := make([]*store.KeyValue, len(ops))
+	for i, op := range ops {
+		keys = append(keys, normalize(op.Key))
+		switch op.Type {
+		case store.OpPut:
+			newKV := &store.KeyValue{
+				Key:   op.Key,
+				Value: op.Value,
+			}
+			val, err := r.codec.encode(newKV)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, txnPut, val, formatMs(op.TTL))
+			results[i] = newKV
+		case store.OpDelete:
+			args = append(args, txnDelete, "", "")
+		default:
+			return nil, store.ErrNotSupported
+		}
+	}
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	reply, err := r.runScript(cmdTxn, keys, args...)
+	if err != nil {
+		return nil, err
+	}
+	revisions, _ := reply.([]interface{})
+	for i, result := range results {
+		if result != nil && i < len(revisions) {
+			result.Revision = toRevision(revisions[i])
+		}
+	}
+	return results, nil
 }
 
 // Close the store connection
 func (r *Redis) Close() {
-	r.client.Close()
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+		r.client.Close()
+
+		r.viewsMu.Lock()
+		defer r.viewsMu.Unlock()
+		for _, view := range r.views {
+			view.client.Close()
+		}
+	})
 }
 
 // Ping is used to check if a connection is still alive
@@ -656,30 +1289,143 @@ func scanRegex(directory string) string {
 	return fmt.Sprintf("%s*", directory)
 }
 
-func (r *Redis) runScript(args ...interface{}) error {
-	err := r.script.Run(
+// runScript runs the command of the lua script on the given keys.
+// The keys are passed in KEYS so the script is routed to the right cluster node.
+// In cluster mode, the meta keys are per hash tag, so the keys have to be tagged.
+func (r *Redis) runScript(cmd string, keys []string, args ...interface{}) (interface{}, error) {
+	tag, ok := parseHashTag(keys[0])
+	if !ok && r.isCluster() {
+		return nil, ErrHashTagRequired
+	}
+	return r.runScriptWith(cmd, r.metaKeys(tag), keys, args...)
+}
+
+// runScriptWith runs the command with the given meta keys, which are the
+// revision counter, the event stream and the expiry set.
+func (r *Redis) runScriptWith(cmd string, meta []string, keys []string, args ...interface{}) (interface{}, error) {
+	reply, err := r.script.Run(
 		r.client,
-		nil,
-		args...,
-	).Err()
-	if err != nil && strings.Contains(err.Error(), "redis: key is not found") {
-		return store.ErrKeyNotFound
+		append(meta, keys...),
+		append([]interface{}{cmd, r.codec.format(), r.eventStreamLen}, args...)...,
+	).Result()
+	if err != nil {
+		return nil, scriptError(err)
 	}
-	if err != nil && strings.Contains(err.Error(), "redis: value has been changed") {
+	return reply, nil
+}
+
+// pipeScript runs the command on each of the keys in a pipeline.
+// The calls which find the script missing are sent again with the script.
+func (r *Redis) pipeScript(cmd string, keys []string) error {
+	scriptKeys := make([][]string, len(keys))
+	for i, key := range keys {
+		tag, ok := parseHashTag(key)
+		if !ok && r.isCluster() {
+			return ErrHashTagRequired
+		}
+		scriptKeys[i] = append(r.metaKeys(tag), key)
+	}
+	args := []interface{}{cmd, r.codec.format(), r.eventStreamLen}
+
+	pending := scriptKeys
+	for eval := false; len(pending) > 0; eval = true {
+		pipe := r.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(pending))
+		for i, ks := range pending {
+			if eval {
+				cmds[i] = r.script.Eval(pipe, ks, args...)
+			} else {
+				cmds[i] = r.script.EvalSha(pipe, ks, args...)
+			}
+		}
+		_, _ = pipe.Exec()
+		pipe.Close()
+
+		var missing [][]string
+		for i, c := range cmds {
+			err := c.Err()
+			if err == nil {
+				continue
+			}
+			if !eval && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+				missing = append(missing, pending[i])
+				continue
+			}
+			return scriptError(err)
+		}
+		pending = missing
+	}
+	return nil
+}
+
+// scriptError maps the errors raised by the lua script to the store errors
+func scriptError(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "redis: key is not found"):
+		return store.ErrKeyNotFound
+	case strings.Contains(err.Error(), "redis: key exists"):
+		return store.ErrKeyExists
+	case strings.Contains(err.Error(), "redis: value has been changed"):
 		return store.ErrKeyModified
 	}
 	return err
 }
 
-func normalize(key string) string {
-	return store.Normalize(key)
+// The keys of the values are normalized with a leading "/", so the meta keys
+// are never listed or watched.
+const (
+	revisionKey = "__kv_revision"
+	eventsKey   = "__kv_events"
+	expiryKey   = "__kv_expiry"
+)
+
+// metaKeys returns the revision counter, the event stream and the expiry set
+// of the given hash tag. They are global unless it's connected to a cluster,
+// where they have to be in the same slot as the keys, so they are per hash tag.
+func (r *Redis) metaKeys(tag string) []string {
+	if !r.isCluster() {
+		return []string{revisionKey, eventsKey, expiryKey}
+	}
+	return []string{
+		fmt.Sprintf("%s{%s}", revisionKey, tag),
+		fmt.Sprintf("%s{%s}", eventsKey, tag),
+		fmt.Sprintf("%s{%s}", expiryKey, tag),
+	}
+}
+
+func (r *Redis) isCluster() bool {
+	_, ok := r.client.(*redis.ClusterClient)
+	return ok
+}
+
+// hashTag returns the part of the key which is hashed by redis cluster
+func hashTag(key string) string {
+	tag, _ := parseHashTag(key)
+	return tag
+}
+
+// parseHashTag returns the hash tag of the key, or the key itself and false
+// if it has no hash tag
+func parseHashTag(key string) (string, bool) {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e], true
+		}
+	}
+	return key, false
 }
 
-func formatSec(dur time.Duration) string {
-	return fmt.Sprintf("%d", int(dur/time.Second))
+func toRevision(reply interface{}) uint64 {
+	if rev, ok := reply.(int64); ok {
+		return uint64(rev)
+	}
+	return 0
+}
+
+func normalize(key string) string {
+	return store.Normalize(key)
 }
 
-func sequenceNum() uint64 {
-	// TODO: use uuid if we concerns collision probability of this number
-	return uint64(time.Now().Nanosecond())
+func formatMs(dur time.Duration) string {
+	return strconv.FormatInt(int64(dur/time.Millisecond), 10)
 }
@@ -15,9 +15,12 @@
 package redis
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	store "github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/namespace"
 	testutils "github.com/getamis/sirius/kv/test"
 	"github.com/getamis/sirius/test"
 	"github.com/stretchr/testify/assert"
@@ -27,8 +30,8 @@ var (
 	client = "localhost:6379"
 )
 
-func makeRedisClient() store.Store {
-	kv := new([]string{client}, true)
+func makeRedisClient(options ...RedisOption) store.Store {
+	kv := new([]string{client}, true, options...)
 	return kv
 }
 
@@ -41,10 +44,345 @@ func TestRedisStore(t *testing.T) {
 	kvTTL := makeRedisClient()
 
 	testutils.RunTestCommon(t, kv)
+	testutils.RunTestListPage(t, kv)
 	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestRevision(t, kv)
+	testutils.RunTestTxn(t, kv)
 	testutils.RunTestWatch(t, kv)
 	testutils.RunTestLock(t, kv)
+	testutils.RunTestContext(t, store.NewContextStore(kv))
+	testutils.RunTestRemainingTTL(t, kv)
+	testutils.RunTestSemaphore(t, kv)
+	testutils.RunTestRateLimiter(t, kv)
 	testutils.RunTestLockTTL(t, kv, lockTTL)
 	testutils.RunTestTTL(t, kv, kvTTL)
+	testReentrantLock(t, kv.(*Redis), lockTTL.(*Redis))
+	testLockLost(t, kv.(*Redis))
+	testLegacyRevision(t, kv.(*Redis))
+	testContextDeadline(t, kv.(*Redis))
 	testutils.RunCleanup(t, kv)
+
+	kv.Close()
+	assert.NotPanics(t, kv.Close, "closing twice should not panic")
+}
+
+func testReentrantLock(t *testing.T, kv *Redis, peer *Redis) {
+	key := "testLockUnlock"
+	ttl := store.LockExpiration(600 * time.Millisecond)
+
+	outer, err := kv.Lock(key, store.LockOwner("owner"), ttl)
+	assert.NoError(t, err)
+	outerHeld, err := outer.Lock(nil)
+	assert.NoError(t, err)
+
+	// the same owner acquires the lock at once with the same token, even in another client
+	inner, err := peer.Lock(key, store.LockOwner("owner"), ttl)
+	assert.NoError(t, err)
+	innerHeld, err := inner.Lock(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, outer.Token(), inner.Token())
+
+	// another owner has to wait
+	other, err := kv.Lock(key, store.LockOwner("other"))
+	assert.NoError(t, err)
+	stopCh := make(chan struct{})
+	close(stopCh)
+	_, err = other.Lock(stopCh)
+	assert.Equal(t, ErrAbortTryLock, err)
+
+	// both of the holders keep renewing the lock
+	time.Sleep(time.Second)
+	assert.NoError(t, outer.Err())
+	assert.NoError(t, inner.Err())
+
+	// the key is held until all the locks are unlocked
+	assert.NoError(t, inner.Unlock())
+	<-innerHeld
+	exists, err := kv.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	select {
+	case <-outerHeld:
+		t.Fatal("lock should be held")
+	default:
+	}
+
+	assert.NoError(t, outer.Unlock())
+	<-outerHeld
+	assert.NoError(t, outer.Err())
+	exists, err = kv.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// testContextDeadline checks nothing is sent after the context is done, and the
+// commands of a short deadline run on a view of the store
+func testContextDeadline(t *testing.T, kv *Redis) {
+	key := "testContextDeadline/key"
+	cs := store.NewContextStore(kv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, cs.Put(ctx, key, []byte("cancelled")))
+	_, err := kv.Get(key)
+	assert.Equal(t, store.ErrKeyNotFound, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, cs.Put(ctx, key, []byte("value")))
+	pair, err := cs.Get(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), pair.Value)
+	assert.NotEmpty(t, kv.views)
+
+	// the keys are kept if the context is done before the deletion
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, cs.DeleteTree(ctx, "testContextDeadline"))
+	exists, err := kv.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.NoError(t, cs.DeleteTree(context.Background(), "testContextDeadline"))
+}
+
+func TestViewTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), viewTimeout(3*time.Millisecond))
+	assert.Equal(t, time.Millisecond, viewTimeout(4*time.Millisecond))
+	assert.Equal(t, 256*time.Millisecond, viewTimeout(1024*time.Millisecond))
+	assert.Equal(t, 128*time.Millisecond, viewTimeout(1023*time.Millisecond))
+}
+
+// testLegacyRevision checks the revisions of the json values written before the
+// revision counter keep going up
+func testLegacyRevision(t *testing.T, kv *Redis) {
+	key := "testLegacyRevision"
+
+	assert.NoError(t, kv.Put(key, []byte("value")))
+	pair, err := kv.Get(key)
+	assert.NoError(t, err)
+	legacy := pair.Revision + 1000000
+
+	_, err = kv.client.Eval(
+		"local v = cjson.decode(redis.call('get', KEYS[1])); v.Revision = tonumber(ARGV[1]); return redis.call('set', KEYS[1], cjson.encode(v))",
+		[]string{normalize(key)}, legacy,
+	).Result()
+	assert.NoError(t, err)
+
+	assert.NoError(t, kv.Put(key, []byte("upgraded")))
+	pair, err = kv.Get(key)
+	assert.NoError(t, err)
+	assert.True(t, pair.Revision > legacy, "revision should not go backwards")
+
+	assert.NoError(t, kv.Delete(key))
+}
+
+func testLockLost(t *testing.T, kv *Redis) {
+	key := "testLockUnlock"
+
+	for _, c := range []struct {
+		change func() error
+		err    error
+	}{
+		{func() error { return kv.Put(key, []byte("stolen")) }, store.ErrLockStolen},
+		{func() error { return kv.Delete(key) }, store.ErrLockExpired},
+	} {
+		lock, err := kv.Lock(key, store.LockExpiration(300*time.Millisecond))
+		assert.NoError(t, err)
+		lockHeld, err := lock.Lock(nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, c.change())
+		select {
+		case <-lockHeld:
+			assert.Equal(t, c.err, lock.Err())
+		case <-time.After(time.Second):
+			t.Fatal("Timeout reached")
+		}
+		kv.Delete(key)
+	}
+}
+
+func TestRedisStoreWithCodecs(t *testing.T) {
+	container := test.NewRedisContainer(test.LoadRedisOptions())
+	assert.NoError(t, container.Start())
+	defer container.Stop()
+
+	for _, codec := range []Codec{BinaryCodec, HashCodec} {
+		kv := makeRedisClient(ValueCodec(codec))
+		lockTTL := makeRedisClient(ValueCodec(codec))
+
+		testutils.RunTestCommon(t, kv)
+		testutils.RunTestListPage(t, kv)
+		testutils.RunTestAtomic(t, kv)
+		testutils.RunTestRevision(t, kv)
+		testutils.RunTestTxn(t, kv)
+		testutils.RunTestWatch(t, kv)
+		testutils.RunTestLock(t, kv)
+		testutils.RunTestLockTTL(t, kv, lockTTL)
+		testutils.RunCleanup(t, kv)
+	}
+}
+
+func TestRedisStoreWithEventStream(t *testing.T) {
+	container := test.NewRedisContainer(test.LoadRedisOptions())
+	assert.NoError(t, container.Start())
+	defer container.Stop()
+
+	options := []RedisOption{EventStream(1000, 100*time.Millisecond)}
+	kv := new([]string{client}, false, options...)
+	lockTTL := new([]string{client}, false, options...)
+	kvTTL := new([]string{client}, false, options...)
+
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestLockTTL(t, kv, lockTTL)
+	testutils.RunTestTTL(t, kv, kvTTL)
+	testutils.RunTestWatchEvents(t, kv)
+	testWatchEvents(t, kv, "testWatchEvents")
+	testutils.RunCleanup(t, kv)
+}
+
+func TestRedisCluster(t *testing.T) {
+	container := test.NewRedisClusterContainer()
+	assert.NoError(t, container.Start())
+	defer container.Stop()
+
+	// the keys have to be tagged in cluster mode
+	options := []RedisOption{Cluster()}
+	kv := tagged(t, new(container.Endpoints, true, options...))
+	lockTTL := tagged(t, new(container.Endpoints, true, options...))
+	kvTTL := tagged(t, new(container.Endpoints, true, options...))
+
+	err := new(container.Endpoints, true, options...).Put("untagged", []byte("value"))
+	assert.Equal(t, ErrHashTagRequired, err)
+
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestListPage(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestRevision(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestRemainingTTL(t, kv)
+	testutils.RunTestSemaphore(t, kv)
+	testutils.RunTestRateLimiter(t, kv)
+	testutils.RunTestLockTTL(t, kv, lockTTL)
+	testutils.RunTestTTL(t, kv, kvTTL)
+	testutils.RunCleanup(t, kv)
+
+	// the events are kept per hash tag in cluster mode
+	options = append(options, EventStream(1000, 100*time.Millisecond))
+	events := new(container.Endpoints, false, options...)
+	kv = tagged(t, events)
+
+	_, err = events.WatchTree("untagged", nil)
+	assert.Equal(t, ErrHashTagRequired, err)
+	lockTTL = tagged(t, new(container.Endpoints, false, options...))
+	kvTTL = tagged(t, new(container.Endpoints, false, options...))
+
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestLockTTL(t, kv, lockTTL)
+	testutils.RunTestTTL(t, kv, kvTTL)
+	testWatchEvents(t, events, "testWatchEvents/{tag}")
+	testutils.RunCleanup(t, kv)
+}
+
+// tagged puts the keys of the tests under a hash tag
+func tagged(t *testing.T, kv store.Store) store.Store {
+	n, err := namespace.New(kv, "{test}")
+	assert.NoError(t, err)
+	return n
+}
+
+func TestRedisSentinel(t *testing.T) {
+	container := test.NewRedisSentinelContainer()
+	assert.NoError(t, container.Start())
+	defer container.Stop()
+
+	options := []RedisOption{Sentinel(container.MasterName)}
+	kv := new(container.Endpoints, true, options...)
+	lockTTL := new(container.Endpoints, true, options...)
+	kvTTL := new(container.Endpoints, true, options...)
+
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestListPage(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestRevision(t, kv)
+	testutils.RunTestTxn(t, kv)
+	testutils.RunTestWatch(t, kv)
+	testutils.RunTestLock(t, kv)
+	testutils.RunTestRemainingTTL(t, kv)
+	testutils.RunTestSemaphore(t, kv)
+	testutils.RunTestRateLimiter(t, kv)
+	testutils.RunTestLockTTL(t, kv, lockTTL)
+	testutils.RunTestTTL(t, kv, kvTTL)
+	testutils.RunCleanup(t, kv)
+}
+
+func testWatchEvents(t *testing.T, kv *Redis, prefix string) {
+	key := prefix + "/key"
+	value := []byte("value")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := kv.WatchEvents(prefix, stopCh)
+	assert.NoError(t, err)
+
+	nextEvent := func() *store.Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout reached")
+			return nil
+		}
+	}
+
+	assert.NoError(t, kv.Put(key, value))
+	created := nextEvent()
+	assert.Equal(t, store.EventPut, created.Type)
+	assert.Equal(t, key, created.KV.Key)
+	assert.Equal(t, value, created.KV.Value)
+	assert.Equal(t, uint64(0), created.PrevRevision)
+
+	assert.NoError(t, kv.Delete(key))
+	deleted := nextEvent()
+	assert.Equal(t, store.EventDelete, deleted.Type)
+	assert.Equal(t, key, deleted.KV.Key)
+	assert.True(t, deleted.KV.Revision > created.KV.Revision)
+	assert.Equal(t, created.KV.Revision, deleted.PrevRevision)
+
+	assert.NoError(t, kv.Put(key, value, store.PutExpiration(time.Second)))
+	created = nextEvent()
+	assert.Equal(t, store.EventPut, created.Type)
+
+	expired := nextEvent()
+	assert.Equal(t, store.EventExpire, expired.Type)
+	assert.Equal(t, key, expired.KV.Key)
+	assert.Equal(t, created.KV.Revision, expired.PrevRevision)
+}
+
+func TestWatchEventsWithoutEventStream(t *testing.T) {
+	kv := new([]string{client}, false)
+	_, err := kv.WatchEvents("testWatchEvents", nil)
+	assert.Equal(t, ErrEventStreamDisabled, err)
+}
+
+func TestNewWithMultipleEndpoints(t *testing.T) {
+	_, err := New([]string{"localhost:6379", "localhost:6380"}, false)
+	assert.Equal(t, ErrMultipleEndpointsUnsupported, err)
+}
+
+func TestHashTag(t *testing.T) {
+	assert.Equal(t, "user", hashTag("/{user}/name"))
+	assert.Equal(t, "/{}/name", hashTag("/{}/name"))
+	assert.Equal(t, "/{user/name", hashTag("/{user/name"))
+	assert.Equal(t, "/user/name", hashTag("/user/name"))
 }
@@ -0,0 +1,141 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"io"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+)
+
+// Result is the summary of an import
+type Result struct {
+	Processed int // the records processed including the resumed ones
+	Imported  int // the records written, or which would be written in a dry run
+	Skipped   int // the records of the existing keys skipped by the Skip policy
+	Conflicts int // the records of the changed keys refused by the CAS policy
+	Expired   int // the records whose TTLs have passed since the export
+}
+
+// Import loads the snapshot into the store.
+// The TTLs of the records are reduced by the time since the export.
+// The result is returned with the error, so the import is able to be resumed by ResumeFrom(result.Processed).
+// ErrUnknownTTLs is returned if the TTLs of the snapshot are unknown, unless IgnoreUnknownTTLs is set.
+func Import(store kv.Store, r io.Reader, opts ...ImportOption) (*Result, error) {
+	o := &ImportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Header().TTLsUnknown && !o.IgnoreUnknownTTLs {
+		return nil, ErrUnknownTTLs
+	}
+	exported := reader.Header().Time
+
+	result := &Result{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if result.Processed < o.Resume {
+			result.Processed++
+			continue
+		}
+
+		var putOpts []kv.PutOption
+		if record.TTL > 0 {
+			ttl := time.Duration(record.TTL)*time.Millisecond - time.Since(exported)
+			if ttl <= 0 {
+				result.Expired++
+				result.Processed++
+				continue
+			}
+			putOpts = append(putOpts, kv.PutExpiration(ttl))
+		}
+
+		if err := importRecord(store, record, o, result, putOpts); err != nil {
+			return result, err
+		}
+		result.Processed++
+	}
+}
+
+func importRecord(store kv.Store, record *Record, o *ImportOptions, result *Result, putOpts []kv.PutOption) error {
+	switch o.Policy {
+	case Overwrite:
+		if !o.DryRun {
+			if err := store.Put(record.Key, record.Value, putOpts...); err != nil {
+				return err
+			}
+		}
+		result.Imported++
+		return nil
+
+	case CAS:
+		current, err := store.Get(record.Key)
+		if err != nil && err != kv.ErrKeyNotFound {
+			return err
+		}
+		if current != nil && current.Revision != record.Revision {
+			result.Conflicts++
+			return nil
+		}
+		if !o.DryRun {
+			_, err = store.AtomicPut(record.Key, record.Value, current, putOpts...)
+			if err == kv.ErrKeyExists || err == kv.ErrKeyModified || err == kv.ErrKeyNotFound {
+				result.Conflicts++
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		result.Imported++
+		return nil
+
+	default:
+		if o.DryRun {
+			exist, err := store.Exists(record.Key)
+			if err != nil {
+				return err
+			}
+			if exist {
+				result.Skipped++
+			} else {
+				result.Imported++
+			}
+			return nil
+		}
+		_, err := store.AtomicPut(record.Key, record.Value, nil, putOpts...)
+		if err == kv.ErrKeyExists {
+			result.Skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.Imported++
+		return nil
+	}
+}
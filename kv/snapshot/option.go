@@ -0,0 +1,68 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+// Policy decides how a record is imported if the key exists in the target store
+type Policy int
+
+const (
+	// Skip keeps the existing keys
+	Skip Policy = iota
+	// Overwrite puts the records regardless of the existing keys
+	Overwrite
+	// CAS only overwrites the existing keys which are still at the revisions of the records,
+	// and the others are reported as conflicts. The revisions are local to a store, so it
+	// only works when the snapshot is restored into the store it's exported from.
+	CAS
+)
+
+type ImportOptions struct {
+	Policy            Policy
+	DryRun            bool
+	Resume            int
+	IgnoreUnknownTTLs bool
+}
+
+type ImportOption func(*ImportOptions)
+
+// ConflictPolicy returns an option to set the policy of the existing keys, which is Skip by default
+func ConflictPolicy(policy Policy) ImportOption {
+	return func(o *ImportOptions) {
+		o.Policy = policy
+	}
+}
+
+// DryRun returns an option to report what would be imported without writing the target store
+func DryRun() ImportOption {
+	return func(o *ImportOptions) {
+		o.DryRun = true
+	}
+}
+
+// ResumeFrom returns an option to skip the first n records, which are processed by
+// a previous import, e.g. the Processed of the result of a failed import
+func ResumeFrom(n int) ImportOption {
+	return func(o *ImportOptions) {
+		o.Resume = n
+	}
+}
+
+// IgnoreUnknownTTLs returns an option to import a snapshot whose TTLs are unknown,
+// so all its keys are imported without a TTL
+func IgnoreUnknownTTLs() ImportOption {
+	return func(o *ImportOptions) {
+		o.IgnoreUnknownTTLs = true
+	}
+}
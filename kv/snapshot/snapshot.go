@@ -0,0 +1,180 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+)
+
+var (
+	// ErrInvalidSnapshot is returned when the snapshot is malformed or of an unknown version
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	// ErrUnknownTTLs is returned when the snapshot is imported but its TTLs are unknown
+	ErrUnknownTTLs = errors.New("the TTLs of the snapshot are unknown")
+)
+
+// The snapshot is a JSON document per line: a header followed by a record per key.
+// The values are base64 encoded by encoding/json, so it's portable across the backends.
+const version = 1
+
+// Header is the first line of a snapshot.
+// TTLsUnknown is true if the store doesn't report the TTLs, so the records
+// carry no TTL even if the keys expire.
+type Header struct {
+	Version     int       `json:"version"`
+	Prefix      string    `json:"prefix"`
+	Time        time.Time `json:"time"`
+	TTLsUnknown bool      `json:"ttls_unknown,omitempty"`
+}
+
+// Record is a key of a snapshot.
+// TTL is the remaining TTL in milliseconds when it's exported, and 0 if the key doesn't expire.
+type Record struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value"`
+	Revision uint64 `json:"revision"`
+	TTL      int64  `json:"ttl_ms,omitempty"`
+}
+
+// Export writes the keys with given prefix to w page by page, and returns the number of the records.
+// The remaining TTLs are exported if the backend reports them, otherwise the header
+// records that they are unknown.
+func Export(store kv.Store, prefix string, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	header := &Header{
+		Version: version,
+		Prefix:  prefix,
+		Time:    time.Now(),
+	}
+
+	count := 0
+	cursor := ""
+	for {
+		pairs, next, err := store.ListPage(prefix, kv.ListCursor(cursor))
+		if err != nil && err != kv.ErrKeyNotFound {
+			return count, err
+		}
+
+		ttls, err := pageTTLs(store, pairs)
+		if err != nil {
+			return count, err
+		}
+		// the header is written once the first page tells if the TTLs are reported
+		if cursor == "" {
+			header.TTLsUnknown = ttls == nil
+			if err := enc.Encode(header); err != nil {
+				return count, err
+			}
+		}
+		for _, pair := range pairs {
+			var ttl time.Duration
+			if ttls != nil {
+				var ok bool
+				// expired or deleted after it's listed
+				if ttl, ok = ttls[pair.Key]; !ok {
+					continue
+				}
+			}
+
+			err = enc.Encode(&Record{
+				Key:      pair.Key,
+				Value:    pair.Value,
+				Revision: pair.Revision,
+				TTL:      int64(ttl / time.Millisecond),
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if next == "" {
+			return count, nil
+		}
+		cursor = next
+	}
+}
+
+// pageTTLs returns the remaining TTLs of the page at once, or nil if the backend doesn't report them
+func pageTTLs(store kv.Store, pairs []*kv.KeyValue) (map[string]time.Duration, error) {
+	if len(pairs) == 0 {
+		return map[string]time.Duration{}, nil
+	}
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	ttls, err := kv.TTLs(store, keys)
+	if err == kv.ErrNotSupported {
+		return nil, nil
+	}
+	return ttls, err
+}
+
+// Reader reads the records of a snapshot
+type Reader struct {
+	scanner *bufio.Scanner
+	header  *Header
+}
+
+// NewReader reads the header of the snapshot
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	// a line holds a whole value
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	header := &Header{}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidSnapshot
+	}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil || header.Version != version {
+		return nil, ErrInvalidSnapshot
+	}
+	return &Reader{
+		scanner: scanner,
+		header:  header,
+	}, nil
+}
+
+const maxLineSize = 64 * 1024 * 1024
+
+// Header returns the header of the snapshot
+func (r *Reader) Header() *Header {
+	return r.header
+}
+
+// Next returns the next record, and io.EOF after the last one
+func (r *Reader) Next() (*Record, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	record := &Record{}
+	if err := json.Unmarshal(r.scanner.Bytes(), record); err != nil {
+		return nil, ErrInvalidSnapshot
+	}
+	return record, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportSnapshot(t *testing.T) (*bytes.Buffer, kv.Store) {
+	source := kv.New()
+	for i := 0; i < 150; i++ {
+		key := "testSnapshot/" + strings.Repeat("k", i%3+1) + "/" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		assert.NoError(t, source.Put(key, []byte(key)), "should be no error")
+	}
+	assert.NoError(t, source.Put("testSnapshot/ttl", []byte("ttl"), kv.PutExpiration(time.Minute)), "should be no error")
+	assert.NoError(t, source.Put("other", []byte("other")), "should be no error")
+
+	buf := &bytes.Buffer{}
+	count, err := Export(source, "testSnapshot", buf)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 151, count, "should be equal")
+	return buf, source
+}
+
+func TestExportImport(t *testing.T) {
+	buf, source := exportSnapshot(t)
+
+	target := kv.New()
+	result, err := Import(target, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 151}, result, "should be equal")
+
+	pairs, err := source.List("testSnapshot")
+	assert.NoError(t, err, "should be no error")
+	for _, pair := range pairs {
+		imported, err := target.Get(pair.Key)
+		assert.NoError(t, err, "should be no error")
+		assert.Equal(t, pair.Value, imported.Value, "should be equal")
+	}
+	_, err = target.Get("other")
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	ttl, err := kv.TTL(target, "testSnapshot/ttl")
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, ttl > 0 && ttl <= time.Minute, "should be true")
+	ttl, err = kv.TTL(target, pairs[0].Key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, time.Duration(0), ttl, "should be equal")
+
+	// the existing keys are skipped by default
+	result, err = Import(target, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Skipped: 151}, result, "should be equal")
+}
+
+func TestImportPolicies(t *testing.T) {
+	buf, _ := exportSnapshot(t)
+	record := firstRecord(t, buf.Bytes())
+
+	// the revision of the key in the target is different from the record
+	target := kv.New()
+	assert.NoError(t, target.Put(record.Key, []byte("foo")), "should be no error")
+	assert.NoError(t, target.Put(record.Key, []byte("changed")), "should be no error")
+
+	// a dry run writes nothing
+	result, err := Import(target, bytes.NewReader(buf.Bytes()), DryRun())
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 150, Skipped: 1}, result, "should be equal")
+	_, err = target.Get("testSnapshot/ttl")
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	// the changed key is a conflict
+	result, err = Import(target, bytes.NewReader(buf.Bytes()), ConflictPolicy(CAS), DryRun())
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 150, Conflicts: 1}, result, "should be equal")
+	result, err = Import(target, bytes.NewReader(buf.Bytes()), ConflictPolicy(CAS))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 150, Conflicts: 1}, result, "should be equal")
+	pair, err := target.Get(record.Key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("changed"), pair.Value, "should be equal")
+
+	result, err = Import(target, bytes.NewReader(buf.Bytes()), ConflictPolicy(Overwrite))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 151}, result, "should be equal")
+	pair, err = target.Get(record.Key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, record.Value, pair.Value, "should be equal")
+}
+
+func TestImportResume(t *testing.T) {
+	buf, _ := exportSnapshot(t)
+	record := firstRecord(t, buf.Bytes())
+
+	target := kv.New()
+	result, err := Import(target, bytes.NewReader(buf.Bytes()), ResumeFrom(1))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 151, Imported: 150}, result, "should be equal")
+	_, err = target.Get(record.Key)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	// a truncated snapshot returns the progress to resume from
+	lines := strings.SplitAfter(buf.String(), "\n")
+	truncated := strings.Join(lines[:11], "") + "{"
+	result, err = Import(kv.New(), strings.NewReader(truncated))
+	assert.Equal(t, ErrInvalidSnapshot, err, "should be equal")
+	assert.Equal(t, 10, result.Processed, "should be equal")
+}
+
+func TestImportExpired(t *testing.T) {
+	snapshot := `{"version":1,"prefix":"","time":"2017-01-01T00:00:00Z"}
+{"key":"expired","value":"Zm9v","revision":1,"ttl_ms":1000}
+{"key":"persistent","value":"Zm9v","revision":2}
+`
+	target := kv.New()
+	result, err := Import(target, strings.NewReader(snapshot))
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 2, Imported: 1, Expired: 1}, result, "should be equal")
+	pair, err := target.Get("persistent")
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, []byte("foo"), pair.Value, "should be equal")
+
+	_, err = Import(target, strings.NewReader(`{"version":2}`))
+	assert.Equal(t, ErrInvalidSnapshot, err, "should be equal")
+	_, err = Import(target, strings.NewReader(""))
+	assert.Equal(t, ErrInvalidSnapshot, err, "should be equal")
+}
+
+// noTTLStore hides the TTLs of the store
+type noTTLStore struct {
+	kv.Store
+}
+
+func TestExportUnknownTTLs(t *testing.T) {
+	source := kv.New()
+	assert.NoError(t, source.Put("testSnapshot/ttl", []byte("ttl"), kv.PutExpiration(time.Minute)), "should be no error")
+
+	buf := &bytes.Buffer{}
+	count, err := Export(noTTLStore{source}, "testSnapshot", buf)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 1, count, "should be equal")
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err, "should be no error")
+	assert.True(t, reader.Header().TTLsUnknown, "should be true")
+
+	// the keys are not made permanent unless it's asked
+	target := kv.New()
+	_, err = Import(target, bytes.NewReader(buf.Bytes()))
+	assert.Equal(t, ErrUnknownTTLs, err, "should be equal")
+	_, err = target.Get("testSnapshot/ttl")
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	result, err := Import(target, bytes.NewReader(buf.Bytes()), IgnoreUnknownTTLs())
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &Result{Processed: 1, Imported: 1}, result, "should be equal")
+
+	// the TTLs are known through a cache
+	buf.Reset()
+	_, err = Export(cache.New(source), "testSnapshot", buf)
+	assert.NoError(t, err, "should be no error")
+	record := firstRecord(t, buf.Bytes())
+	assert.True(t, record.TTL > 0, "should be true")
+}
+
+func firstRecord(t *testing.T, snapshot []byte) *Record {
+	reader, err := NewReader(bytes.NewReader(snapshot))
+	assert.NoError(t, err, "should be no error")
+	record, err := reader.Next()
+	assert.NoError(t, err, "should be no error")
+	return record
+}
@@ -27,6 +27,15 @@ var (
 	ErrKeyExists = errors.New("key exists")
 	// ErrUnableToLock is returned when there is an error when acquiring a lock on a key
 	ErrUnableToLock = errors.New("failed to acquire the lock")
+	// ErrCompacted is returned when the events are watched from a revision which is no longer kept
+	ErrCompacted = errors.New("revision has been compacted")
+
+	// ErrLockExpired is reported when the lock is lost since it's not renewed in time
+	ErrLockExpired = errors.New("lock expired")
+	// ErrLockStolen is reported when the lock is lost since the key is changed by someone else
+	ErrLockStolen = errors.New("lock stolen")
+	// ErrLockUnreachable is reported when the lock is lost since the backend is unreachable
+	ErrLockUnreachable = errors.New("lock backend unreachable")
 )
 
 //go:generate mockery -name Store
@@ -46,6 +55,10 @@ type Store interface {
 	// List the content with given prefix
 	List(prefix string) ([]*KeyValue, error)
 
+	// ListPage lists a page of the content with given prefix.
+	// The returned cursor is used to get the next page and it's empty after the last page.
+	ListPage(prefix string, opts ...ListOption) ([]*KeyValue, string, error)
+
 	// Delete the value with the specified key
 	Delete(key string) error
 
@@ -55,6 +68,10 @@ type Store interface {
 	// DeleteTree deletes a range of keys under a given prefix
 	DeleteTree(prefix string) error
 
+	// Txn applies the ops only if all the compares hold, so either all ops are committed or none.
+	// It returns the results of the ops in order, which are nil for the deletes.
+	Txn(compares []Compare, ops []Op) ([]*KeyValue, error)
+
 	// Exists checks if a key exists in the backend
 	Exists(key string) (bool, error)
 
@@ -64,6 +81,10 @@ type Store interface {
 	// WatchTree watches for changes on child nodes under a given prefix
 	WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*KeyValue, error)
 
+	// WatchEvents watches the puts, deletes and expirations of the keys with given prefix.
+	// The events after now are delivered unless WatchFromRevision is given.
+	WatchEvents(prefix string, stopCh <-chan struct{}, opts ...WatchOption) (<-chan *Event, error)
+
 	// Lock locks the given key.
 	// The returned Locker is not held and must be acquired
 	Lock(key string, opts ...LockOption) (Locker, error)
@@ -80,7 +101,16 @@ type KeyValue struct {
 }
 
 // Locker provides locking mechanism on top of the backend.
+// The channel returned by Lock is closed once the lock is lost.
 type Locker interface {
 	Lock(stopChan chan struct{}) (<-chan struct{}, error)
 	Unlock() error
+
+	// Token returns the fencing token of the last acquisition, which increases
+	// on every acquisition of the key
+	Token() uint64
+
+	// Err returns why the lock is lost, which is ErrLockExpired, ErrLockStolen or
+	// ErrLockUnreachable. It's nil if the lock is held, unlocked or stopped by the user.
+	Err() error
 }
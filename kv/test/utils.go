@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -19,6 +20,12 @@ func RunTestCommon(t *testing.T, kv store.Store) {
 	testDeleteTree(t, kv)
 }
 
+// RunTestListPage tests the paginated listing supported
+// by the K/V backends
+func RunTestListPage(t *testing.T, kv store.Store) {
+	testListPage(t, kv)
+}
+
 // RunTestListLock tests the list output for mutexes
 // and checks that internal side keys are not listed
 func RunTestListLock(t *testing.T, kv store.Store) {
@@ -34,6 +41,18 @@ func RunTestAtomic(t *testing.T, kv store.Store) {
 	testAtomicDelete(t, kv)
 }
 
+// RunTestRevision tests the revisions of a key are
+// strictly increasing by the K/V backends
+func RunTestRevision(t *testing.T, kv store.Store) {
+	testRevision(t, kv)
+}
+
+// RunTestTxn tests the multi-key transactions supported
+// by the K/V backends
+func RunTestTxn(t *testing.T, kv store.Store) {
+	testTxn(t, kv)
+}
+
 // RunTestWatch tests the watch/monitor APIs supported
 // by the K/V backends.
 func RunTestWatch(t *testing.T, kv store.Store) {
@@ -41,6 +60,23 @@ func RunTestWatch(t *testing.T, kv store.Store) {
 	testWatchTree(t, kv)
 }
 
+// RunTestWatchEvents tests the event-based watch APIs supported
+// by the K/V backends.
+func RunTestWatchEvents(t *testing.T, kv store.Store) {
+	testWatchEvents(t, kv)
+}
+
+// RunTestSemaphore tests the semaphores of the backends supporting them
+func RunTestSemaphore(t *testing.T, kv store.Store) {
+	testSemaphore(t, kv)
+}
+
+// RunTestRateLimiter tests the rate limiters of the backends supporting them
+func RunTestRateLimiter(t *testing.T, kv store.Store) {
+	testRateLimiter(t, kv, store.TokenBucket)
+	testRateLimiter(t, kv, store.SlidingWindow)
+}
+
 // RunTestLock tests the KV pair Lock/Unlock APIs supported
 // by the K/V backends.
 func RunTestLock(t *testing.T, kv store.Store) {
@@ -58,6 +94,37 @@ func RunTestTTL(t *testing.T, kv store.Store, backup store.Store) {
 	testPutTTL(t, kv, backup)
 }
 
+// RunTestRemainingTTL tests the remaining TTL of the backends reporting it
+func RunTestRemainingTTL(t *testing.T, kv store.Store) {
+	testRemainingTTL(t, kv)
+}
+
+// RunTestContext tests the context-first variant of the K/V backends
+func RunTestContext(t *testing.T, kv store.ContextStore) {
+	testContextPutGet(t, kv)
+	testContextLock(t, kv)
+}
+
+// RunAll runs the tests of the whole store API over a store
+// which is its own backup, e.g. a decorator of the in-memory store
+func RunAll(t *testing.T, kv store.Store) {
+	RunTestCommon(t, kv)
+	RunTestListPage(t, kv)
+	RunTestAtomic(t, kv)
+	RunTestRevision(t, kv)
+	RunTestTxn(t, kv)
+	RunTestWatch(t, kv)
+	RunTestWatchEvents(t, kv)
+	RunTestLock(t, kv)
+	RunTestSemaphore(t, kv)
+	RunTestRateLimiter(t, kv)
+	RunTestContext(t, store.NewContextStore(kv))
+	RunTestRemainingTTL(t, kv)
+	RunTestLockTTL(t, kv, kv)
+	RunTestTTL(t, kv, kv)
+	RunCleanup(t, kv)
+}
+
 func checkPairNotNil(t *testing.T, pair *store.KeyValue) {
 	if assert.NotNil(t, pair) {
 		if !assert.NotNil(t, pair.Value) {
@@ -171,6 +238,68 @@ func testWatch(t *testing.T, kv store.Store) {
 	}
 }
 
+func testWatchEvents(t *testing.T, kv store.Store) {
+	// the hash tag keeps the keys in a single redis cluster slot
+	dir := "testWatchEvents/{events}"
+	key := dir + "/key"
+
+	next := func(events <-chan *store.Event) *store.Event {
+		select {
+		case event := <-events:
+			if assert.NotNil(t, event) {
+				assert.NotNil(t, event.KV)
+			}
+			return event
+		case <-time.After(4 * time.Second):
+			t.Fatal("Timeout reached")
+		}
+		return nil
+	}
+
+	stopCh := make(chan struct{})
+	events, err := kv.WatchEvents(dir, stopCh)
+	assert.NoError(t, err)
+	assert.NotNil(t, events)
+
+	err = kv.Put(key, []byte("first"))
+	assert.NoError(t, err)
+	err = kv.Put(key, []byte("second"))
+	assert.NoError(t, err)
+	err = kv.Delete(key)
+	assert.NoError(t, err)
+
+	created := next(events)
+	assert.Equal(t, store.EventPut, created.Type)
+	assert.Equal(t, key, created.KV.Key)
+	assert.Equal(t, []byte("first"), created.KV.Value)
+	assert.Equal(t, uint64(0), created.PrevRevision)
+
+	updated := next(events)
+	assert.Equal(t, store.EventPut, updated.Type)
+	assert.Equal(t, []byte("second"), updated.KV.Value)
+	assert.Equal(t, created.KV.Revision, updated.PrevRevision)
+	assert.True(t, updated.KV.Revision > created.KV.Revision, "revision should be increasing")
+
+	deleted := next(events)
+	assert.Equal(t, store.EventDelete, deleted.Type)
+	assert.Equal(t, key, deleted.KV.Key)
+	assert.Equal(t, updated.KV.Revision, deleted.PrevRevision)
+	assert.True(t, deleted.KV.Revision > updated.KV.Revision, "revision should be increasing")
+	close(stopCh)
+
+	// Resume from the update
+	stopCh = make(chan struct{})
+	defer close(stopCh)
+	events, err = kv.WatchEvents(dir, stopCh, store.WatchFromRevision(updated.KV.Revision))
+	assert.NoError(t, err)
+	assert.NotNil(t, events)
+
+	event := next(events)
+	assert.Equal(t, updated, event)
+	event = next(events)
+	assert.Equal(t, deleted, event)
+}
+
 func testWatchTree(t *testing.T, kv store.Store) {
 	dir := "testWatchTree"
 
@@ -343,14 +472,21 @@ func testLockUnlock(t *testing.T, kv store.Store) {
 	assert.Equal(t, pair.Value, value)
 	assert.NotEqual(t, pair.Revision, 0)
 
+	// The fencing token is given on acquisition
+	token := lock.Token()
+	assert.NotEqual(t, uint64(0), token)
+	assert.NoError(t, lock.Err())
+
 	// Unlock should succeed
 	err = lock.Unlock()
 	assert.NoError(t, err)
+	assert.NoError(t, lock.Err())
 
 	// Lock should succeed again
 	lockChan, err = lock.Lock(nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, lockChan)
+	assert.True(t, lock.Token() > token, "fencing token should be increasing")
 
 	// Get should work
 	pair, err = kv.Get(key)
@@ -363,6 +499,86 @@ func testLockUnlock(t *testing.T, kv store.Store) {
 	assert.NoError(t, err)
 }
 
+func testSemaphore(t *testing.T, kv store.Store) {
+	key := "testSemaphore"
+	ttl := store.SemaphoreExpiration(500 * time.Millisecond)
+
+	var sems []store.Semaphore
+	for i := 0; i < 3; i++ {
+		sem, err := store.NewSemaphore(kv, key, 2, ttl)
+		assert.NoError(t, err)
+		assert.NotNil(t, sem)
+		sems = append(sems, sem)
+	}
+
+	// Only two slots can be acquired
+	ok, err := sems[0].TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sems[1].TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sems[2].TryAcquire()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Acquiring a held slot renews it
+	ok, err = sems[1].TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	assert.Equal(t, store.ErrUnableToAcquire, sems[2].Acquire(stopCh))
+
+	// A released slot can be acquired
+	assert.NoError(t, sems[0].Release())
+	assert.Equal(t, store.ErrKeyNotFound, sems[0].Release())
+	assert.NoError(t, sems[2].Acquire(nil))
+
+	// The slots are freed after the ttl
+	time.Sleep(600 * time.Millisecond)
+	ok, err = sems[0].TryAcquire()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, store.ErrKeyNotFound, sems[1].Release())
+	assert.NoError(t, sems[0].Release())
+}
+
+func testRateLimiter(t *testing.T, kv store.Store, algorithm store.RateAlgorithm) {
+	key := fmt.Sprintf("testRateLimiter/%d", algorithm)
+	rate := store.RateLimit{
+		Algorithm: algorithm,
+		Limit:     2,
+		Window:    500 * time.Millisecond,
+	}
+
+	limiter, err := store.NewRateLimiter(kv, key, rate)
+	assert.NoError(t, err)
+	assert.NotNil(t, limiter)
+	_, err = store.NewRateLimiter(kv, key, store.RateLimit{Algorithm: algorithm})
+	assert.Equal(t, store.ErrInvalidLimit, err)
+
+	// The calls are limited after the first two
+	for i := 0; i < 2; i++ {
+		ok, _, err := limiter.Allow()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, wait, err := limiter.Allow()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, wait > 0 && wait <= rate.Window, fmt.Sprintf("unexpected wait %v", wait))
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+	assert.Equal(t, store.ErrUnableToAcquire, limiter.Wait(stopCh))
+
+	start := time.Now()
+	assert.NoError(t, limiter.Wait(nil))
+	assert.True(t, time.Since(start) <= rate.Window+100*time.Millisecond, "should wait for a window at most")
+}
+
 func testLockTTL(t *testing.T, kv store.Store, otherConn store.Store) {
 	key := "testLockTTL"
 	value := []byte("bar")
@@ -499,6 +715,37 @@ func testPutTTL(t *testing.T, kv store.Store, otherConn store.Store) {
 	assert.Nil(t, pair)
 }
 
+func testRemainingTTL(t *testing.T, kv store.Store) {
+	key := "testRemainingTTL/expire"
+	persistentKey := "testRemainingTTL/persistent"
+
+	err := kv.Put(key, []byte("foo"), store.PutExpiration(10*time.Second))
+	assert.NoError(t, err)
+	ttl, err := store.TTL(kv, key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= 10*time.Second, "should be in (0, 10s]")
+
+	// The key without expiration has no TTL
+	err = kv.Put(persistentKey, []byte("bar"))
+	assert.NoError(t, err)
+	ttl, err = store.TTL(kv, persistentKey)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	_, err = store.TTL(kv, "testRemainingTTL/not_exist")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+
+	// The TTLs of many keys are reported at once without the missing keys
+	ttls, err := store.TTLs(kv, []string{key, persistentKey, "testRemainingTTL/not_exist"})
+	assert.NoError(t, err)
+	assert.Len(t, ttls, 2)
+	assert.True(t, ttls[key] > 0 && ttls[key] <= 10*time.Second, "should be in (0, 10s]")
+	assert.Equal(t, time.Duration(0), ttls[persistentKey])
+
+	err = kv.DeleteTree("testRemainingTTL")
+	assert.NoError(t, err)
+}
+
 func testList(t *testing.T, kv store.Store) {
 	parentKey := "testList"
 	childKey := "testList/child"
@@ -636,6 +883,228 @@ func testDeleteTree(t *testing.T, kv store.Store) {
 	assert.Nil(t, pair)
 }
 
+func testListPage(t *testing.T, kv store.Store) {
+	prefix := "testListPage"
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("%s/key%02d", prefix, i)
+		value := []byte(strconv.Itoa(i))
+		expected[key] = value
+
+		err := kv.Put(key, value)
+		assert.NoError(t, err)
+	}
+
+	// Walk through all the pages, a key may be listed more than once
+	found := make(map[string][]byte)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatal("test failure, too many pages")
+		}
+
+		pairs, next, err := kv.ListPage(prefix, store.ListCursor(cursor), store.ListLimit(10))
+		assert.NoError(t, err)
+		for _, pair := range pairs {
+			checkPairNotNil(t, pair)
+			found[strings.TrimPrefix(pair.Key, "/")] = pair.Value
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, expected, found)
+
+	// An empty prefix returns no pairs and no cursor
+	pairs, cursor, err := kv.ListPage("testListPage/nonExist")
+	assert.NoError(t, err)
+	assert.Empty(t, pairs)
+	assert.Empty(t, cursor)
+
+	err = kv.DeleteTree(prefix)
+	assert.NoError(t, err)
+}
+
+func testRevision(t *testing.T, kv store.Store) {
+	key := "testRevision"
+
+	err := kv.Put(key, []byte("first"))
+	assert.NoError(t, err)
+	first, err := kv.Get(key)
+	assert.NoError(t, err)
+	checkPairNotNil(t, first)
+	assert.NotEqual(t, uint64(0), first.Revision)
+
+	err = kv.Put(key, []byte("second"))
+	assert.NoError(t, err)
+	second, err := kv.Get(key)
+	assert.NoError(t, err)
+	checkPairNotNil(t, second)
+	assert.True(t, second.Revision > first.Revision, "revision should be increasing")
+
+	third, err := kv.AtomicPut(key, []byte("third"), second)
+	assert.NoError(t, err)
+	checkPairNotNil(t, third)
+	assert.True(t, third.Revision > second.Revision, "revision should be increasing")
+
+	pair, err := kv.Get(key)
+	assert.NoError(t, err)
+	checkPairNotNil(t, pair)
+	assert.Equal(t, third.Revision, pair.Revision)
+
+	// A recreated key doesn't reuse the revisions
+	err = kv.Delete(key)
+	assert.NoError(t, err)
+	fourth, err := kv.AtomicPut(key, []byte("fourth"), nil)
+	assert.NoError(t, err)
+	checkPairNotNil(t, fourth)
+	assert.True(t, fourth.Revision > third.Revision, "revision should be increasing")
+
+	// The stale revision can't be used after the key is recreated
+	_, err = kv.AtomicPut(key, []byte("fifth"), third)
+	assert.Equal(t, store.ErrKeyModified, err)
+
+	err = kv.Delete(key)
+	assert.NoError(t, err)
+}
+
+func testTxn(t *testing.T, kv store.Store) {
+	// The keys share a hash tag, so they are in the same slot of a redis cluster
+	fromKey := "testTxn/{txn}/from"
+	toKey := "testTxn/{txn}/to"
+	otherKey := "testTxn/{txn}/other"
+	value := []byte("bar")
+
+	err := kv.Put(fromKey, value)
+	assert.NoError(t, err)
+	from, err := kv.Get(fromKey)
+	assert.NoError(t, err)
+	checkPairNotNil(t, from)
+
+	// Move the value from fromKey to toKey
+	results, err := kv.Txn(
+		[]store.Compare{
+			store.CompareRevision(fromKey, from.Revision),
+			store.CompareNotExist(toKey),
+		},
+		[]store.Op{
+			store.DeleteOp(fromKey),
+			store.PutOp(toKey, value),
+		},
+	)
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Nil(t, results[0])
+		checkPairNotNil(t, results[1])
+		assert.Equal(t, value, results[1].Value)
+	}
+
+	exist, err := kv.Exists(fromKey)
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	to, err := kv.Get(toKey)
+	assert.NoError(t, err)
+	checkPairNotNil(t, to)
+	assert.Equal(t, value, to.Value)
+	assert.Equal(t, results[1].Revision, to.Revision)
+
+	// None of the ops is applied if a compare fails
+	for _, c := range []struct {
+		compare store.Compare
+		err     error
+	}{
+		{store.CompareRevision(fromKey, from.Revision), store.ErrKeyNotFound},
+		{store.CompareNotExist(toKey), store.ErrKeyExists},
+		{store.CompareRevision(toKey, to.Revision+1), store.ErrKeyModified},
+	} {
+		_, err = kv.Txn(
+			[]store.Compare{store.CompareRevision(toKey, to.Revision), c.compare},
+			[]store.Op{store.PutOp(otherKey, value), store.DeleteOp(toKey)},
+		)
+		assert.Equal(t, c.err, err)
+
+		exist, err = kv.Exists(otherKey)
+		assert.NoError(t, err)
+		assert.False(t, exist)
+
+		exist, err = kv.Exists(toKey)
+		assert.NoError(t, err)
+		assert.True(t, exist)
+	}
+
+	err = kv.Delete(toKey)
+	assert.NoError(t, err)
+}
+
+func testContextPutGet(t *testing.T, kv store.ContextStore) {
+	key := "testContext/putGet"
+	value := []byte("bar")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := kv.Put(ctx, key, value)
+	assert.NoError(t, err)
+
+	pair, err := kv.Get(ctx, key)
+	assert.NoError(t, err)
+	checkPairNotNil(t, pair)
+	assert.Equal(t, pair.Value, value)
+
+	// Every operation fails on a cancelled context
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = kv.Get(cancelled, key)
+	assert.Equal(t, context.Canceled, err)
+
+	err = kv.Put(cancelled, key, value)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = kv.Watch(cancelled, key)
+	assert.Equal(t, context.Canceled, err)
+
+	err = kv.Delete(ctx, key)
+	assert.NoError(t, err)
+}
+
+func testContextLock(t *testing.T, kv store.ContextStore) {
+	key := "testContext/lock"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lock, err := kv.Lock(ctx, key, store.LockExpiration(2*time.Second))
+	assert.NoError(t, err)
+	assert.NotNil(t, lock)
+
+	lockChan, err := lock.Lock(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, lockChan)
+
+	// Another locker gives up once its context is done
+	other, err := kv.Lock(ctx, key, store.LockExpiration(2*time.Second))
+	assert.NoError(t, err)
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelTimeout()
+	_, err = other.Lock(timeout)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The lock is still held after the context of Lock is done
+	select {
+	case <-lockChan:
+		t.Fatal("lock is lost")
+	default:
+	}
+
+	err = lock.Unlock(ctx)
+	assert.NoError(t, err)
+}
+
 // RunCleanup cleans up keys introduced by the tests
 func RunCleanup(t *testing.T, kv store.Store) {
 	for _, key := range []string{
@@ -643,17 +1112,23 @@ func RunCleanup(t *testing.T, kv store.Store) {
 		"testPutGetDeleteExists",
 		"testWatch",
 		"testWatchTree",
+		"testWatchEvents",
 		"testAtomicPut",
 		"testAtomicPutCreate",
 		"testAtomicDelete",
 		"testLockUnlock",
 		"testLockTTL",
 		"testPutTTL",
+		"testRemainingTTL",
 		"testList/subfolder",
 		"testList",
 		"testListLockSide/subfolder",
 		"testListLockSide",
 		"testDeleteTree",
+		"testListPage",
+		"testContext",
+		"testTxn",
+		"testRevision",
 	} {
 		err := kv.DeleteTree(key)
 		assert.True(t, err == nil || err == store.ErrKeyNotFound, fmt.Sprintf("failed to delete tree key %s: %v", key, err))
@@ -0,0 +1,60 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import "time"
+
+// ttler is implemented by the backends which report the remaining TTL of a key
+type ttler interface {
+	TTL(key string) (time.Duration, error)
+}
+
+// TTL returns the remaining TTL of the key, which is 0 if the key doesn't expire.
+// ErrKeyNotFound is returned if the key doesn't exist, and ErrNotSupported is
+// returned if the backend doesn't report the TTL.
+func TTL(s Store, key string) (time.Duration, error) {
+	t, ok := s.(ttler)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return t.TTL(key)
+}
+
+// ttlsGetter is implemented by the backends which report the remaining TTLs of many keys at once
+type ttlsGetter interface {
+	TTLs(keys []string) (map[string]time.Duration, error)
+}
+
+// TTLs returns the remaining TTLs of the keys, which are 0 if the keys don't expire.
+// The keys which don't exist are left out. The backend is asked once if it supports
+// it, otherwise the TTLs are asked key by key.
+func TTLs(s Store, keys []string) (map[string]time.Duration, error) {
+	if t, ok := s.(ttlsGetter); ok {
+		return t.TTLs(keys)
+	}
+
+	ttls := make(map[string]time.Duration, len(keys))
+	for _, key := range keys {
+		ttl, err := TTL(s, key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ttls[key] = ttl
+	}
+	return ttls, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kv
+
+import "time"
+
+// Compare is a condition of a transaction on the revision of a key
+type Compare struct {
+	Key      string
+	Revision uint64 // the expected revision, 0 means the key should not exist
+}
+
+// CompareRevision expects the key to be at the given revision
+func CompareRevision(key string, revision uint64) Compare {
+	return Compare{
+		Key:      key,
+		Revision: revision,
+	}
+}
+
+// CompareNotExist expects the key not to exist
+func CompareNotExist(key string) Compare {
+	return Compare{
+		Key: key,
+	}
+}
+
+// OpType is the type of an operation in a transaction
+type OpType int
+
+const (
+	OpPut OpType = iota
+	OpDelete
+)
+
+// Op is an operation in a transaction
+type Op struct {
+	Type  OpType
+	Key   string
+	Value []byte
+	TTL   time.Duration
+}
+
+// PutOp puts the value with the specified key
+func PutOp(key string, value []byte, opts ...PutOption) Op {
+	o := &PutOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return Op{
+		Type:  OpPut,
+		Key:   key,
+		Value: value,
+		TTL:   o.TTL,
+	}
+}
+
+// DeleteOp deletes the value with the specified key, it's not an error if the key doesn't exist
+func DeleteOp(key string) Op {
+	return Op{
+		Type: OpDelete,
+		Key:  key,
+	}
+}
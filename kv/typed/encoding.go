@@ -0,0 +1,68 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package typed
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrNotProtoMessage is returned when the object of the Protobuf encoding is not a proto.Message
+var ErrNotProtoMessage = errors.New("not a proto message")
+
+var (
+	// JSON encodes the objects by encoding/json, which is the default
+	JSON Encoding = jsonEncoding{}
+
+	// Protobuf encodes the objects in the protobuf wire format, so the pointer
+	// of the type must be a proto.Message
+	Protobuf Encoding = protoEncoding{}
+)
+
+// Encoding marshals the objects stored in the kv.Store
+type Encoding interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonEncoding) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type protoEncoding struct{}
+
+func (protoEncoding) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+func (protoEncoding) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
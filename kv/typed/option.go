@@ -0,0 +1,50 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package typed
+
+// UpgradeFunc upgrades the encoded object of a schema version to the next version
+type UpgradeFunc func(data []byte) ([]byte, error)
+
+type options struct {
+	encoding Encoding
+	version  int
+	upgrades map[int]UpgradeFunc
+}
+
+// Option configures the repository
+type Option func(o *options)
+
+// ValueEncoding returns an option to set the encoding of the objects, which is JSON by default
+func ValueEncoding(encoding Encoding) Option {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
+
+// Version returns an option to set the schema version of the objects written, which is 1 by default
+func Version(version int) Option {
+	return func(o *options) {
+		o.version = version
+	}
+}
+
+// Upgrade returns an option to register the upgrade of the objects from the version
+// to the next one. The objects of an old version are upgraded one version at a time
+// until the current version when they're read.
+func Upgrade(from int, fn UpgradeFunc) Option {
+	return func(o *options) {
+		o.upgrades[from] = fn
+	}
+}
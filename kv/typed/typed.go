@@ -0,0 +1,226 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package typed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/getamis/sirius/kv/internal/watch"
+	"github.com/getamis/sirius/log"
+)
+
+var (
+	// ErrInvalidValue is returned when the value is not written by a repository
+	ErrInvalidValue = errors.New("invalid typed value")
+	// ErrUnknownVersion is returned when the schema version is newer than the repository's
+	ErrUnknownVersion = errors.New("unknown schema version")
+	// ErrMissingUpgrade is returned when there is no upgrade from an old schema version
+	ErrMissingUpgrade = errors.New("missing schema upgrade")
+)
+
+// maxUpdateRetries is the max number of the retries of Update on conflicts
+const maxUpdateRetries = 16
+
+// Object is an object of the repository with the key and the revision in the kv.Store
+type Object[T any] struct {
+	Key      string
+	Value    *T
+	Revision uint64
+}
+
+// Repository reads and writes the objects of type T in a kv.Store.
+// A value is stored as the schema version, a colon and the encoded object, e.g.
+// `2:{"name":"foo"}`, so the objects of the old versions are upgraded on read.
+type Repository[T any] struct {
+	store    kv.Store
+	encoding Encoding
+	version  int
+	upgrades map[int]UpgradeFunc
+}
+
+// New returns the repository of the objects of type T in the store
+func New[T any](store kv.Store, opts ...Option) *Repository[T] {
+	o := &options{
+		encoding: JSON,
+		version:  1,
+		upgrades: make(map[int]UpgradeFunc),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Repository[T]{
+		store:    store,
+		encoding: o.encoding,
+		version:  o.version,
+		upgrades: o.upgrades,
+	}
+}
+
+// Get the object with given key
+func (r *Repository[T]) Get(key string) (*Object[T], error) {
+	pair, err := r.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return r.decode(pair)
+}
+
+// Put the object with the specified key regardless of the current one
+func (r *Repository[T]) Put(key string, value *T, opts ...kv.PutOption) error {
+	data, err := r.encode(value)
+	if err != nil {
+		return err
+	}
+	return r.store.Put(key, data, opts...)
+}
+
+// Create the object with the specified key, ErrKeyExists is returned if the key exists
+func (r *Repository[T]) Create(key string, value *T, opts ...kv.PutOption) (*Object[T], error) {
+	return r.atomicPut(key, value, nil, opts...)
+}
+
+// Save the object if it's not changed since it's read, otherwise ErrKeyModified is returned
+func (r *Repository[T]) Save(obj *Object[T], opts ...kv.PutOption) (*Object[T], error) {
+	return r.atomicPut(obj.Key, obj.Value, &kv.KeyValue{
+		Key:      obj.Key,
+		Revision: obj.Revision,
+	}, opts...)
+}
+
+// Update reads the object, modifies it by fn and saves it, which is retried if the
+// object is changed by others in the meantime
+func (r *Repository[T]) Update(key string, fn func(value *T) error, opts ...kv.PutOption) (*Object[T], error) {
+	for i := 0; ; i++ {
+		obj, err := r.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(obj.Value); err != nil {
+			return nil, err
+		}
+		obj, err = r.Save(obj, opts...)
+		if err == kv.ErrKeyModified && i < maxUpdateRetries {
+			continue
+		}
+		return obj, err
+	}
+}
+
+// Delete the object with the specified key
+func (r *Repository[T]) Delete(key string) error {
+	return r.store.Delete(key)
+}
+
+// List the objects with given prefix
+func (r *Repository[T]) List(prefix string) ([]*Object[T], error) {
+	pairs, err := r.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*Object[T], len(pairs))
+	for i, pair := range pairs {
+		objs[i], err = r.decode(pair)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pair.Key, err)
+		}
+	}
+	return objs, nil
+}
+
+// Watch for changes on the object, and the values failing to be decoded are dropped
+func (r *Repository[T]) Watch(key string, stopCh <-chan struct{}) (<-chan *Object[T], error) {
+	changes, err := r.store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *Object[T])
+	go func() {
+		defer close(watchCh)
+		watch.Forward(watchCh, changes, stopCh, func(pair *kv.KeyValue) (*Object[T], bool) {
+			obj, err := r.decode(pair)
+			if err != nil {
+				log.Warn("Failed to decode the object in Watch", "key", key, "err", err)
+				return nil, false
+			}
+			return obj, true
+		})
+	}()
+	return watchCh, nil
+}
+
+func (r *Repository[T]) atomicPut(key string, value *T, expected *kv.KeyValue, opts ...kv.PutOption) (*Object[T], error) {
+	data, err := r.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	pair, err := r.store.AtomicPut(key, data, expected, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Object[T]{
+		Key:      key,
+		Value:    value,
+		Revision: pair.Revision,
+	}, nil
+}
+
+func (r *Repository[T]) encode(value *T) ([]byte, error) {
+	data, err := r.encoding.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(strconv.Itoa(r.version)+":"), data...), nil
+}
+
+// decode upgrades the value to the current version and unmarshals it
+func (r *Repository[T]) decode(pair *kv.KeyValue) (*Object[T], error) {
+	i := bytes.IndexByte(pair.Value, ':')
+	if i < 0 {
+		return nil, ErrInvalidValue
+	}
+	version, err := strconv.Atoi(string(pair.Value[:i]))
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	if version > r.version {
+		return nil, ErrUnknownVersion
+	}
+
+	data := pair.Value[i+1:]
+	for ; version < r.version; version++ {
+		upgrade, ok := r.upgrades[version]
+		if !ok {
+			return nil, ErrMissingUpgrade
+		}
+		if data, err = upgrade(data); err != nil {
+			return nil, err
+		}
+	}
+
+	value := new(T)
+	if err := r.encoding.Unmarshal(data, value); err != nil {
+		return nil, err
+	}
+	return &Object[T]{
+		Key:      pair.Key,
+		Value:    value,
+		Revision: pair.Revision,
+	}, nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package typed
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getamis/sirius/kv"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Count int    `json:"count"`
+}
+
+func TestRepository(t *testing.T) {
+	store := kv.New()
+	defer store.Close()
+	repo := New[user](store)
+
+	key := "testRepository/alice"
+	_, err := repo.Get(key)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+
+	alice := &user{Name: "alice", Email: "alice@example.com"}
+	assert.NoError(t, repo.Put(key, alice), "should be no error")
+	pair, err := store.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, `1:{"name":"alice","email":"alice@example.com","count":0}`, string(pair.Value), "should be equal")
+
+	obj, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, key, obj.Key, "should be equal")
+	assert.Equal(t, alice, obj.Value, "should be equal")
+	assert.Equal(t, pair.Revision, obj.Revision, "should be equal")
+
+	bob := &user{Name: "bob"}
+	_, err = repo.Create("testRepository/bob", bob)
+	assert.NoError(t, err, "should be no error")
+	_, err = repo.Create("testRepository/bob", bob)
+	assert.Equal(t, kv.ErrKeyExists, err, "should be equal")
+
+	objs, err := repo.List("testRepository")
+	assert.NoError(t, err, "should be no error")
+	assert.Len(t, objs, 2, "should be equal")
+	values := map[string]*user{}
+	for _, obj := range objs {
+		values[obj.Key] = obj.Value
+	}
+	assert.Equal(t, alice, values[key], "should be equal")
+	assert.Equal(t, bob, values["testRepository/bob"], "should be equal")
+
+	// a value not written by the repository
+	assert.NoError(t, store.Put("testRepository/raw", []byte("raw")), "should be no error")
+	_, err = repo.Get("testRepository/raw")
+	assert.Equal(t, ErrInvalidValue, err, "should be equal")
+
+	assert.NoError(t, repo.Delete(key), "should be no error")
+	_, err = repo.Get(key)
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+}
+
+func TestRepositoryConcurrency(t *testing.T) {
+	store := kv.New()
+	defer store.Close()
+	repo := New[user](store)
+
+	key := "testRepositoryConcurrency/alice"
+	_, err := repo.Create(key, &user{Name: "alice"})
+	assert.NoError(t, err, "should be no error")
+
+	first, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	second, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+
+	first.Value.Email = "alice@example.com"
+	first, err = repo.Save(first)
+	assert.NoError(t, err, "should be no error")
+
+	// the object is changed since the second one is read
+	second.Value.Email = "alice@example.org"
+	_, err = repo.Save(second)
+	assert.Equal(t, kv.ErrKeyModified, err, "should be equal")
+
+	obj, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "alice@example.com", obj.Value.Email, "should be equal")
+	assert.Equal(t, first.Revision, obj.Revision, "should be equal")
+
+	// the updates are retried on conflicts
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Update(key, func(u *user) error {
+				u.Count++
+				return nil
+			})
+			assert.NoError(t, err, "should be no error")
+		}()
+	}
+	wg.Wait()
+	obj, err = repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, 5, obj.Value.Count, "should be equal")
+
+	_, err = repo.Update("testRepositoryConcurrency/bob", func(u *user) error {
+		return nil
+	})
+	assert.Equal(t, kv.ErrKeyNotFound, err, "should be equal")
+}
+
+func TestRepositoryUpgrade(t *testing.T) {
+	store := kv.New()
+	defer store.Close()
+
+	type userV1 struct {
+		Name string `json:"name"`
+	}
+	key := "testRepositoryUpgrade/alice"
+	assert.NoError(t, New[userV1](store).Put(key, &userV1{Name: "alice"}), "should be no error")
+
+	// v2 adds the email, and v3 adds the count
+	upgradeV1 := func(data []byte) ([]byte, error) {
+		var u map[string]interface{}
+		if err := json.Unmarshal(data, &u); err != nil {
+			return nil, err
+		}
+		u["email"] = u["name"].(string) + "@example.com"
+		return json.Marshal(u)
+	}
+	upgradeV2 := func(data []byte) ([]byte, error) {
+		var u map[string]interface{}
+		if err := json.Unmarshal(data, &u); err != nil {
+			return nil, err
+		}
+		u["count"] = 1
+		return json.Marshal(u)
+	}
+
+	repo := New[user](store, Version(3), Upgrade(1, upgradeV1), Upgrade(2, upgradeV2))
+	obj, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, &user{Name: "alice", Email: "alice@example.com", Count: 1}, obj.Value, "should be equal")
+
+	// the upgraded object is written in the current version
+	_, err = repo.Save(obj)
+	assert.NoError(t, err, "should be no error")
+	pair, err := store.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "3:", string(pair.Value[:2]), "should be equal")
+
+	// the old repository doesn't know the new version
+	_, err = New[userV1](store).Get(key)
+	assert.Equal(t, ErrUnknownVersion, err, "should be equal")
+
+	assert.NoError(t, New[userV1](store).Put(key, &userV1{Name: "alice"}), "should be no error")
+	_, err = New[user](store, Version(3), Upgrade(2, upgradeV2)).Get(key)
+	assert.Equal(t, ErrMissingUpgrade, err, "should be equal")
+}
+
+func TestRepositoryProtobuf(t *testing.T) {
+	store := kv.New()
+	defer store.Close()
+	repo := New[wrappers.StringValue](store, ValueEncoding(Protobuf))
+
+	key := "testRepositoryProtobuf/key"
+	assert.NoError(t, repo.Put(key, &wrappers.StringValue{Value: "foo"}), "should be no error")
+	obj, err := repo.Get(key)
+	assert.NoError(t, err, "should be no error")
+	assert.Equal(t, "foo", obj.Value.Value, "should be equal")
+
+	// the type isn't a proto message
+	err = New[user](store, ValueEncoding(Protobuf)).Put(key, &user{})
+	assert.Equal(t, ErrNotProtoMessage, err, "should be equal")
+}
+
+func TestRepositoryWatch(t *testing.T) {
+	store := kv.New()
+	defer store.Close()
+	repo := New[user](store)
+
+	key := "testRepositoryWatch/alice"
+	assert.NoError(t, repo.Put(key, &user{Name: "alice"}), "should be no error")
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	watchCh, err := repo.Watch(key, stopCh)
+	assert.NoError(t, err, "should be no error")
+
+	// the values failing to be decoded are dropped
+	assert.NoError(t, store.Put(key, []byte("raw")), "should be no error")
+	assert.NoError(t, repo.Put(key, &user{Name: "alice", Count: 1}), "should be no error")
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case obj, ok := <-watchCh:
+			assert.True(t, ok, "should be true")
+			assert.Equal(t, "alice", obj.Value.Name, "should be equal")
+			if obj.Value.Count == 1 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for the object")
+		}
+	}
+}
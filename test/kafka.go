@@ -0,0 +1,71 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type KafkaContainer struct {
+	dockerContainer *Container
+	Brokers         []string
+}
+
+func (container *KafkaContainer) Start() error {
+	return container.dockerContainer.Start()
+}
+
+func (container *KafkaContainer) Suspend() error {
+	return container.dockerContainer.Suspend()
+}
+
+func (container *KafkaContainer) Stop() error {
+	return container.dockerContainer.Stop()
+}
+
+// NewKafkaContainer returns a single-node Kafka in the KRaft mode, which
+// advertises localhost:9092 to the clients
+func NewKafkaContainer() (*KafkaContainer, error) {
+	port := 9092
+	endpoint := fmt.Sprintf("127.0.0.1:%d", port)
+	checker := func(c *Container) error {
+		return retry(20, 3*time.Second, func() error {
+			conn, err := kafka.Dial("tcp", endpoint)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = conn.Brokers()
+			return err
+		})
+	}
+	container := &KafkaContainer{
+		dockerContainer: NewDockerContainer(
+			ImageRepository("apache/kafka"),
+			ImageTag("3.7.0"),
+			HostPortBindings(
+				PortBinding{ContainerPort: fmt.Sprintf("%d", port), HostPort: fmt.Sprintf("%d", port)},
+			),
+			ExposePorts(fmt.Sprintf("%d", port)),
+			HealthChecker(checker),
+		),
+		Brokers: []string{endpoint},
+	}
+
+	return container, nil
+}
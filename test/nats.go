@@ -0,0 +1,70 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+type NATSContainer struct {
+	dockerContainer *Container
+	URL             string
+}
+
+func (container *NATSContainer) Start() error {
+	return container.dockerContainer.Start()
+}
+
+func (container *NATSContainer) Suspend() error {
+	return container.dockerContainer.Suspend()
+}
+
+func (container *NATSContainer) Stop() error {
+	return container.dockerContainer.Stop()
+}
+
+// NewNATSContainer returns a NATS server with JetStream enabled
+func NewNATSContainer() (*NATSContainer, error) {
+	port := 4222
+	endpoint := fmt.Sprintf("nats://127.0.0.1:%d", port)
+	checker := func(c *Container) error {
+		return retry(10, 1*time.Second, func() error {
+			conn, err := nats.Connect(endpoint)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			return nil
+		})
+	}
+	container := &NATSContainer{
+		dockerContainer: NewDockerContainer(
+			ImageRepository("nats"),
+			ImageTag("2.10-alpine"),
+			RunOptions([]string{"-js"}),
+			HostPortBindings(
+				PortBinding{ContainerPort: fmt.Sprintf("%d", port), HostPort: fmt.Sprintf("%d", port)},
+			),
+			ExposePorts(fmt.Sprintf("%d", port)),
+			HealthChecker(checker),
+		),
+		URL: endpoint,
+	}
+
+	return container, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 AMIS Technologies
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getamis/sirius/log"
+	redis "gopkg.in/redis.v5"
+)
+
+const (
+	// DefaultRedisClusterPort is the port of the first node of the cluster,
+	// the others listen on the following ports
+	DefaultRedisClusterPort = 7000
+	// DefaultRedisClusterSize is the number of the master nodes of the cluster
+	DefaultRedisClusterSize = 3
+
+	DefaultRedisSentinelPort = 26379
+	DefaultRedisMasterPort   = 6380
+	DefaultRedisMasterName   = "mymaster"
+)
+
+// RedisClusterContainer runs all the nodes of a redis cluster in one container.
+// The nodes announce 127.0.0.1 and the ports are bound to the same host ports,
+// so the cluster is reachable from the host.
+type RedisClusterContainer struct {
+	*Container
+	Endpoints []string
+}
+
+// NewRedisClusterContainer returns a redis cluster of DefaultRedisClusterSize masters without replicas
+func NewRedisClusterContainer() *RedisClusterContainer {
+	var (
+		ports     []string
+		endpoints []string
+		bindings  []PortBinding
+	)
+	for i := 0; i < DefaultRedisClusterSize; i++ {
+		port := strconv.Itoa(DefaultRedisClusterPort + i)
+		ports = append(ports, port)
+		endpoints = append(endpoints, net.JoinHostPort("127.0.0.1", port))
+		bindings = append(bindings, PortBinding{ContainerPort: port + "/tcp", HostPort: port})
+	}
+
+	script := fmt.Sprintf(
+		"for p in %s; do redis-server --port $p --cluster-enabled yes --cluster-config-file nodes-$p.conf --daemonize yes; done; "+
+			"sleep 1; redis-cli --cluster create %s --cluster-yes; tail -f /dev/null",
+		strings.Join(ports, " "), strings.Join(endpoints, " "),
+	)
+
+	checker := func(c *Container) error {
+		return retry(20, 1*time.Second, func() error {
+			log.Debug("Checking redis cluster status", "endpoints", endpoints)
+			client := redis.NewClient(&redis.Options{
+				Addr: endpoints[0],
+			})
+			defer client.Close()
+			info, err := client.ClusterInfo().Result()
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(info, "cluster_state:ok") {
+				return fmt.Errorf("redis cluster is not ready")
+			}
+			return nil
+		})
+	}
+
+	return &RedisClusterContainer{
+		Container: NewDockerContainer(
+			ImageRepository("redis"),
+			ImageTag("6-alpine"),
+			Entrypoint([]string{"sh", "-c", script}),
+			ExposePorts(ports...),
+			HostPortBindings(bindings...),
+			HealthChecker(checker),
+		),
+		Endpoints: endpoints,
+	}
+}
+
+// RedisSentinelContainer runs a redis master and a sentinel monitoring it in one container
+type RedisSentinelContainer struct {
+	*Container
+	MasterName string
+	Endpoints  []string
+}
+
+// NewRedisSentinelContainer returns a sentinel on DefaultRedisSentinelPort, which monitors
+// the master DefaultRedisMasterName on DefaultRedisMasterPort
+func NewRedisSentinelContainer() *RedisSentinelContainer {
+	sentinelPort := strconv.Itoa(DefaultRedisSentinelPort)
+	masterPort := strconv.Itoa(DefaultRedisMasterPort)
+	endpoint := net.JoinHostPort("127.0.0.1", sentinelPort)
+
+	script := fmt.Sprintf(
+		"redis-server --port %[2]s --daemonize yes; "+
+			"printf 'port %[1]s\\nsentinel monitor %[3]s 127.0.0.1 %[2]s 1\\n' > /tmp/sentinel.conf; "+
+			"redis-sentinel /tmp/sentinel.conf",
+		sentinelPort, masterPort, DefaultRedisMasterName,
+	)
+
+	checker := func(c *Container) error {
+		return retry(20, 1*time.Second, func() error {
+			log.Debug("Checking redis sentinel status", "endpoint", endpoint)
+			client := redis.NewFailoverClient(&redis.FailoverOptions{
+				MasterName:    DefaultRedisMasterName,
+				SentinelAddrs: []string{endpoint},
+			})
+			defer client.Close()
+			return client.Ping().Err()
+		})
+	}
+
+	return &RedisSentinelContainer{
+		Container: NewDockerContainer(
+			ImageRepository("redis"),
+			ImageTag("6-alpine"),
+			Entrypoint([]string{"sh", "-c", script}),
+			ExposePorts(sentinelPort, masterPort),
+			HostPortBindings(
+				PortBinding{ContainerPort: sentinelPort + "/tcp", HostPort: sentinelPort},
+				PortBinding{ContainerPort: masterPort + "/tcp", HostPort: masterPort},
+			),
+			HealthChecker(checker),
+		),
+		MasterName: DefaultRedisMasterName,
+		Endpoints:  []string{endpoint},
+	}
+}